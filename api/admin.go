@@ -0,0 +1,103 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"db-forum/archive"
+	"db-forum/database"
+	"db-forum/logger"
+	"db-forum/models"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"github.com/valyala/fasthttp"
+)
+
+// AdminToken is the bearer token of admin endpoints; when empty they are
+// served to loopback clients only.
+var AdminToken string
+
+func Admin(handler fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if err := adminCheck(ctx.RemoteIP(), ctx.Request.Header.Peek("Authorization")); err != nil {
+			writeProblem(ctx, err.(Problem))
+			return
+		}
+		handler(ctx)
+	}
+}
+
+// adminCheck returns the Problem refusing a client at ip with the
+// authorization header, if the client isn't the admin.
+func adminCheck(ip net.IP, authorization []byte) error {
+	if AdminToken == "" {
+		if !ip.IsLoopback() {
+			return ErrForbidden.WithMessage("admin endpoints are served to localhost only")
+		}
+		return nil
+	}
+	if subtle.ConstantTimeCompare(authorization, []byte("Bearer "+AdminToken)) != 1 {
+		return ErrForbidden
+	}
+	return nil
+}
+
+func ExportForum(ctx *fasthttp.RequestCtx) {
+	slug := ctx.UserValue("slug").(string)
+	forum, err := database.GetForum(requestContext(ctx), slug)
+	if err != nil {
+		if err == database.ErrNotFound {
+			writeProblem(ctx, ErrForumNotFound)
+			return
+		}
+		writeError(ctx, err)
+		return
+	}
+	ctx.SetContentType("application/x-ndjson")
+	ctx.Response.Header.Set("Content-Disposition", `attachment; filename="`+forum.Slug+`.ndjson"`)
+	reqCtx := requestContext(ctx)
+	atomic.AddInt64(&inFlight, 1)
+	setBodyStreamWriter(ctx, func(w *bufio.Writer) {
+		defer atomic.AddInt64(&inFlight, -1)
+		if err := archive.Export(reqCtx, w, forum.Slug); err != nil {
+			logger.FromContext(reqCtx).Error(err.Error())
+		}
+	})
+}
+
+func ImportArchive(ctx *fasthttp.RequestCtx) {
+	slug := string(ctx.QueryArgs().Peek("forum"))
+	summary, err := importArchive(requestContext(ctx), bytes.NewReader(ctx.PostBody()), slug)
+	if err != nil {
+		if p, ok := err.(Problem); ok {
+			writeProblem(ctx, p)
+			return
+		}
+		writeError(ctx, err)
+		return
+	}
+	WriteResponse(ctx, http.StatusCreated, summary)
+}
+
+// importArchive loads the archive read from body. Errors of the catalog
+// are returned as Problem.
+func importArchive(ctx context.Context, body io.Reader, slug string) (*models.ArchiveSummary, error) {
+	summary, err := archive.Import(ctx, body, slug)
+	if err != nil {
+		switch errors.Cause(err) {
+		case archive.ErrFormat:
+			return nil, ErrInvalidArchive.WithMessage(err.Error())
+		case database.ErrDuplicate:
+			return nil, ErrArchiveConflict.WithMessage(err.Error())
+		case database.ErrNotFound, database.ErrConflict:
+			return nil, ErrArchiveInvalid.WithMessage(err.Error())
+		}
+		return nil, err
+	}
+	return summary, nil
+}
@@ -0,0 +1,18 @@
+package api
+
+import (
+	"github.com/valyala/fasthttp"
+)
+
+// newRequest returns a request context as the router hands it to handlers,
+// with the path parameters in values.
+func newRequest(method string, uri string, body string, values map[string]string) *fasthttp.RequestCtx {
+	var ctx fasthttp.RequestCtx
+	ctx.Request.Header.SetMethod(method)
+	ctx.Request.SetRequestURI(uri)
+	ctx.Request.SetBodyString(body)
+	for name, value := range values {
+		ctx.SetUserValue(name, value)
+	}
+	return &ctx
+}
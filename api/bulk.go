@@ -0,0 +1,127 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"db-forum/database"
+	"db-forum/logger"
+
+	"github.com/mailru/easyjson"
+	"github.com/mailru/easyjson/jwriter"
+)
+
+// Bulk serves the import endpoints on a listener of their own. fasthttp
+// reads whole request bodies into memory before calling handlers, so the
+// main server keeps a small body limit, and imports of up to maxBodySize
+// bytes are decoded here while net/http is still reading them.
+func Bulk(maxBodySize int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&inFlight, 1)
+		defer atomic.AddInt64(&inFlight, -1)
+		id := logger.RequestIDOrNew(r.Header.Get("X-Request-ID"))
+		w.Header().Set("X-Request-ID", id)
+		var seen string
+		if cookie, err := r.Cookie(WriteCookie); err == nil {
+			seen = cookie.Value
+		}
+		ctx := database.NewSession(logger.NewContext(baseContext, id), seen)
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go func() {
+			select {
+			case <-r.Context().Done():
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		if atomic.LoadInt32(&draining) != 0 {
+			w.Header().Set("Connection", "close")
+		}
+		body := &limitedBody{r: r.Body, left: maxBodySize}
+
+		result, err := bulkRoute(ctx, r, body)
+		if body.left < 0 {
+			err = ErrBodyTooLarge
+		}
+		SetWriteCookie(ctx, w.Header().Add)
+		if err != nil {
+			p, ok := err.(Problem)
+			if !ok {
+				logger.FromContext(ctx).Error(err.Error(), "method", r.Method, "path", r.URL.Path)
+				p = ErrInternal
+			}
+			writeHTTP(w, p.Status, "application/problem+json", p.Payload(ctx))
+			return
+		}
+		writeHTTP(w, http.StatusCreated, "application/json", result)
+	})
+}
+
+// bulkRoute serves the import endpoint of r. Both endpoints are admin
+// only: imports skip the rate limits and the slow mode of posting.
+func bulkRoute(ctx context.Context, r *http.Request, body io.Reader) (easyjson.Marshaler, error) {
+	slug, posts := importPath(r.URL.Path)
+	if !posts && r.URL.Path != "/api/admin/import" {
+		return nil, ErrRouteNotFound
+	}
+	host, _, _ := net.SplitHostPort(r.RemoteAddr)
+	if err := adminCheck(net.ParseIP(host), []byte(r.Header.Get("Authorization"))); err != nil {
+		return nil, err
+	}
+	if r.Method != http.MethodPost {
+		return nil, ErrMethodNotAllowed
+	}
+	if posts {
+		return importPosts(ctx, slug, body)
+	}
+	return importArchive(ctx, body, r.URL.Query().Get("forum"))
+}
+
+// importPath returns the thread of /api/thread/:slug/import.
+func importPath(path string) (string, bool) {
+	const prefix, suffix = "/api/thread/", "/import"
+	if !strings.HasPrefix(path, prefix) || !strings.HasSuffix(path, suffix) {
+		return "", false
+	}
+	slug := path[len(prefix) : len(path)-len(suffix)]
+	return slug, slug != "" && !strings.Contains(slug, "/")
+}
+
+func writeHTTP(w http.ResponseWriter, status int, contentType string, body easyjson.Marshaler) {
+	var jw jwriter.Writer
+	body.MarshalEasyJSON(&jw)
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	jw.DumpTo(w)
+}
+
+// limitedBody fails with ErrBodyTooLarge once more than left bytes are read.
+type limitedBody struct {
+	r    io.Reader
+	left int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if int64(len(p)) > b.left+1 {
+		p = p[:b.left+1]
+	}
+	n, err := b.r.Read(p)
+	b.left -= int64(n)
+	if b.left < 0 {
+		return n, ErrBodyTooLarge
+	}
+	return n, err
+}
+
+// bodyProblem returns the problem of a request body that can't be decoded.
+func bodyProblem(err error) Problem {
+	if err == ErrBodyTooLarge {
+		return ErrBodyTooLarge
+	}
+	return ErrInvalidJSON.WithMessage(err.Error())
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestImportPath(t *testing.T) {
+	tests := []struct {
+		path string
+		slug string
+		ok   bool
+	}{
+		{"/api/thread/42/import", "42", true},
+		{"/api/thread/some-slug/import", "some-slug", true},
+		{"/api/thread//import", "", false},
+		{"/api/thread/a/b/import", "", false},
+		{"/api/thread/a/create", "", false},
+		{"/api/admin/import", "", false},
+	}
+	for _, tt := range tests {
+		slug, ok := importPath(tt.path)
+		if ok != tt.ok || ok && slug != tt.slug {
+			t.Errorf("importPath(%q) = %q, %v, want %q, %v", tt.path, slug, ok, tt.slug, tt.ok)
+		}
+	}
+}
+
+func TestLimitedBody(t *testing.T) {
+	body := &limitedBody{r: strings.NewReader("12345"), left: 5}
+	if data, err := ioutil.ReadAll(body); err != nil || string(data) != "12345" {
+		t.Errorf("body at the limit: %q, %v", data, err)
+	}
+	body = &limitedBody{r: strings.NewReader("123456"), left: 5}
+	if _, err := ioutil.ReadAll(body); err != ErrBodyTooLarge {
+		t.Errorf("body over the limit: error %v, want ErrBodyTooLarge", err)
+	}
+	stream, err := newPostStream(&limitedBody{r: strings.NewReader(`[{"author":"a"},{"author":"b"}]`), left: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for err == nil {
+		_, err = stream.Next()
+	}
+	if p := bodyProblem(stream.err); p.Code != ErrBodyTooLarge.Code {
+		t.Errorf("oversized import answers %s, want %s", p.Code, ErrBodyTooLarge.Code)
+	}
+}
+
+func TestBulkRejectsOtherRoutes(t *testing.T) {
+	defer func(token string) { AdminToken = token }(AdminToken)
+	tests := []struct {
+		method        string
+		path          string
+		remote        string
+		token         string
+		authorization string
+		want          int
+	}{
+		{"POST", "/api/thread/1/create", "127.0.0.1:1234", "", "", http.StatusNotFound},
+		{"GET", "/api/thread/1/import", "127.0.0.1:1234", "", "", http.StatusMethodNotAllowed},
+		{"POST", "/api/thread/1/import", "192.0.2.1:1234", "", "", http.StatusForbidden},
+		{"POST", "/api/thread/1/import", "127.0.0.1:1234", "secret", "", http.StatusForbidden},
+		{"POST", "/api/thread/1/import", "192.0.2.1:1234", "secret", "Bearer guess", http.StatusForbidden},
+		{"POST", "/api/admin/import", "192.0.2.1:1234", "", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		AdminToken = tt.token
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		r.RemoteAddr = tt.remote
+		if tt.authorization != "" {
+			r.Header.Set("Authorization", tt.authorization)
+		}
+		w := httptest.NewRecorder()
+		Bulk(1<<20).ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s %s from %s with %q: status %d, want %d", tt.method, tt.path, tt.remote, tt.authorization, w.Code, tt.want)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/problem+json" {
+			t.Errorf("%s %s: Content-Type %q", tt.method, tt.path, ct)
+		}
+		if w.Header().Get("X-Request-ID") == "" {
+			t.Errorf("%s %s: no X-Request-ID", tt.method, tt.path)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zlib"
+	"github.com/valyala/fasthttp"
+)
+
+// compressMinSize is the smallest body worth compressing.
+const compressMinSize = 1 << 10
+
+var compressibleTypes = []string{"application/json", "application/problem+json", "application/x-ndjson", "text/"}
+
+// brotliQuality trades brotli's density for the speed of compressing
+// responses on the fly; 11 is meant for static files.
+const brotliQuality = 4
+
+var (
+	brotliWriters sync.Pool
+	gzipWriters   sync.Pool
+	zlibWriters   sync.Pool
+)
+
+// Compress compresses response bodies of handler with brotli, gzip or
+// deflate, whichever the client prefers, and suffixes their strong ETag
+// with the encoding. Small bodies, bodies already encoded and streamed
+// bodies, which compress themselves in setBodyStreamWriter, are sent as
+// they are.
+func Compress(handler fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		handler(ctx)
+		ctx.Response.Header.Add("Vary", "Accept-Encoding")
+		encoding := acceptedEncoding(string(ctx.Request.Header.Peek("Accept-Encoding")))
+		if encoding != "" && ctx.Response.StatusCode() == http.StatusNotModified {
+			// Confirm the compressed representation the client validated.
+			etag := encodedETag(string(ctx.Response.Header.Peek("ETag")), encoding)
+			if strings.Contains(string(ctx.Request.Header.Peek("If-None-Match")), etag) {
+				ctx.Response.Header.Set("ETag", etag)
+			}
+			return
+		}
+		if encoding == "" || ctx.Response.IsBodyStream() || len(ctx.Response.Header.Peek("Content-Encoding")) != 0 ||
+			!compressible(string(ctx.Response.Header.ContentType())) {
+			return
+		}
+		body := ctx.Response.Body()
+		if len(body) < compressMinSize {
+			return
+		}
+		var buf bytes.Buffer
+		zw := newCompressor(encoding, &buf)
+		_, err := zw.Write(body)
+		if err == nil {
+			err = zw.Close()
+		}
+		releaseCompressor(encoding, zw)
+		if err != nil {
+			logError(ctx, err)
+			return
+		}
+		ctx.Response.SetBody(buf.Bytes())
+		ctx.Response.Header.Set("Content-Encoding", encoding)
+		if etag := ctx.Response.Header.Peek("ETag"); len(etag) != 0 {
+			ctx.Response.Header.Set("ETag", encodedETag(string(etag), encoding))
+		}
+	}
+}
+
+// setBodyStreamWriter is ctx.SetBodyStreamWriter compressing the stream with
+// the encoding the client prefers.
+func setBodyStreamWriter(ctx *fasthttp.RequestCtx, write func(w *bufio.Writer)) {
+	release := takeRelease(ctx)
+	encoding := acceptedEncoding(string(ctx.Request.Header.Peek("Accept-Encoding")))
+	if encoding == "" {
+		ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+			defer release()
+			write(w)
+		})
+		return
+	}
+	ctx.Response.Header.Set("Content-Encoding", encoding)
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer release()
+		zw := newCompressor(encoding, w)
+		bw := bufio.NewWriter(zw)
+		write(bw)
+		bw.Flush()
+		zw.Close()
+		releaseCompressor(encoding, zw)
+	})
+}
+
+// acceptedEncoding returns br, gzip or deflate, whichever the
+// Accept-Encoding header weighs more, preferring them in this order on
+// ties, or "" when none is acceptable.
+func acceptedEncoding(header string) string {
+	weights := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(params[0]))
+		weight := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					weight = q
+				}
+			}
+		}
+		weights[coding] = weight
+	}
+	weight := func(coding string) float64 {
+		if w, ok := weights[coding]; ok {
+			return w
+		}
+		return weights["*"]
+	}
+	encoding, best := "", 0.0
+	for _, coding := range []string{"br", "gzip", "deflate"} {
+		if w := weight(coding); w > best {
+			encoding, best = coding, w
+		}
+	}
+	return encoding
+}
+
+func compressible(contentType string) bool {
+	for _, prefix := range compressibleTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// newCompressor returns a pooled brotli, gzip or, for deflate, zlib writer
+// to w.
+func newCompressor(encoding string, w io.Writer) io.WriteCloser {
+	switch encoding {
+	case "br":
+		if zw, ok := brotliWriters.Get().(*brotli.Writer); ok {
+			zw.Reset(w)
+			return zw
+		}
+		return brotli.NewWriterLevel(w, brotliQuality)
+	case "gzip":
+		if zw, ok := gzipWriters.Get().(*gzip.Writer); ok {
+			zw.Reset(w)
+			return zw
+		}
+		return gzip.NewWriter(w)
+	}
+	if zw, ok := zlibWriters.Get().(*zlib.Writer); ok {
+		zw.Reset(w)
+		return zw
+	}
+	return zlib.NewWriter(w)
+}
+
+func releaseCompressor(encoding string, zw io.WriteCloser) {
+	switch encoding {
+	case "br":
+		brotliWriters.Put(zw)
+	case "gzip":
+		gzipWriters.Put(zw)
+	default:
+		zlibWriters.Put(zw)
+	}
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/valyala/fasthttp"
+)
+
+func TestCompress(t *testing.T) {
+	body := bytes.Repeat([]byte(`{"message":"We should be afraid of the Kraken."},`), 100)
+	handler := func(etag string) fasthttp.RequestHandler {
+		return Compress(func(ctx *fasthttp.RequestCtx) {
+			ctx.SetContentType("application/json")
+			ctx.Response.Header.Set("ETag", etag)
+			ctx.SetBody(body)
+		})
+	}
+	tests := []struct {
+		name           string
+		accept         string
+		etag           string
+		encoding, want string
+	}{
+		{"brotli", "gzip, deflate, br", `"7"`, "br", `"7-br"`},
+		{"gzip", "gzip, deflate", `"7"`, "gzip", `"7-gzip"`},
+		{"gzip over brotli", "br;q=0.5, gzip", `"7"`, "gzip", `"7-gzip"`},
+		{"deflate", "deflate", `"7"`, "deflate", `"7-deflate"`},
+		{"weak etag", "gzip", `W/"1f"`, "gzip", `W/"1f"`},
+		{"identity", "", `"7"`, "", `"7"`},
+	}
+	for _, tt := range tests {
+		ctx := newRequest("GET", "/api/thread/1/posts", "", nil)
+		ctx.Request.Header.Set("Accept-Encoding", tt.accept)
+		handler(tt.etag)(ctx)
+		if got := string(ctx.Response.Header.Peek("Content-Encoding")); got != tt.encoding {
+			t.Errorf("%s: Content-Encoding %q, want %q", tt.name, got, tt.encoding)
+		}
+		if got := string(ctx.Response.Header.Peek("ETag")); got != tt.want {
+			t.Errorf("%s: ETag %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	ctx := newRequest("GET", "/api/thread/1/posts", "", nil)
+	ctx.Request.Header.Set("Accept-Encoding", "gzip")
+	handler(`"7"`)(ctx)
+	zr, err := gzip.NewReader(bytes.NewReader(ctx.Response.Body()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ioutil.ReadAll(zr); err != nil || !bytes.Equal(got, body) {
+		t.Errorf("gunzipped body differs: %v", err)
+	}
+
+	ctx = newRequest("GET", "/api/thread/1/posts", "", nil)
+	ctx.Request.Header.Set("Accept-Encoding", "br")
+	handler(`"7"`)(ctx)
+	if got, err := ioutil.ReadAll(brotli.NewReader(bytes.NewReader(ctx.Response.Body()))); err != nil || !bytes.Equal(got, body) {
+		t.Errorf("brotli-decoded body differs: %v", err)
+	}
+}
+
+// TestCompressNotModified revalidates a compressed response: the handler
+// sees its own ETag in If-None-Match and the 304 names the gzip one.
+func TestCompressNotModified(t *testing.T) {
+	handler := Compress(func(ctx *fasthttp.RequestCtx) {
+		setVersionETag(ctx, 7)
+		if etagMatches(string(ctx.Request.Header.Peek("If-None-Match")), `"7"`) {
+			ctx.SetStatusCode(http.StatusNotModified)
+		}
+	})
+	ctx := newRequest("GET", "/api/thread/1/details", "", nil)
+	ctx.Request.Header.Set("Accept-Encoding", "gzip")
+	ctx.Request.Header.Set("If-None-Match", `"7-gzip"`)
+	handler(ctx)
+	if got := ctx.Response.StatusCode(); got != http.StatusNotModified {
+		t.Fatalf("status %d, want 304", got)
+	}
+	if got := string(ctx.Response.Header.Peek("ETag")); got != `"7-gzip"` {
+		t.Errorf("ETag %q, want \"7-gzip\"", got)
+	}
+
+	ctx = newRequest("GET", "/api/thread/1/details", "", nil)
+	ctx.Request.Header.Set("Accept-Encoding", "gzip")
+	ctx.Request.Header.Set("If-None-Match", `"7"`)
+	handler(ctx)
+	if got := string(ctx.Response.Header.Peek("ETag")); got != `"7"` {
+		t.Errorf("304 to an identity ETag: ETag %q, want \"7\"", got)
+	}
+}
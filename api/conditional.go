@@ -0,0 +1,87 @@
+package api
+
+import (
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+const maxTrackedRepresentations = 100000
+
+type representation struct {
+	etag     string
+	modified time.Time
+}
+
+// Representations remember when each URI first produced its current ETag,
+// which becomes the Last-Modified of responses carrying that ETag.
+var (
+	representationsMu sync.Mutex
+	representations   = make(map[string]representation)
+)
+
+func lastModified(uri string, etag string) time.Time {
+	representationsMu.Lock()
+	defer representationsMu.Unlock()
+	if r, ok := representations[uri]; ok && r.etag == etag {
+		return r.modified
+	}
+	if len(representations) >= maxTrackedRepresentations {
+		representations = make(map[string]representation)
+	}
+	r := representation{etag: etag, modified: time.Now().UTC().Truncate(time.Second)}
+	representations[uri] = r
+	return r.modified
+}
+
+func bodyETag(body []byte) string {
+	h := fnv.New64a()
+	h.Write(body)
+	return `W/"` + strconv.FormatUint(h.Sum64(), 16) + `"`
+}
+
+func etagMatches(header string, etag string) bool {
+	opaque := strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(header, ",") {
+		tag = decodedETag(strings.TrimSpace(tag))
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == opaque {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified sets ETag and Last-Modified for a successful GET response and
+// reports whether the request's conditional headers allow answering 304.
+func notModified(ctx *fasthttp.RequestCtx, body []byte) bool {
+	etag := string(ctx.Response.Header.Peek("ETag"))
+	if etag == "" {
+		etag = bodyETag(body)
+		ctx.Response.Header.Set("ETag", etag)
+	}
+	modified := lastModified(string(ctx.RequestURI()), etag)
+	ctx.Response.Header.SetLastModified(modified)
+
+	if inm := string(ctx.Request.Header.Peek("If-None-Match")); inm != "" {
+		return etagMatches(inm, etag)
+	}
+	return !ctx.IfModifiedSince(modified)
+}
+
+// CacheControl sets the Cache-Control policy on successful responses of handler.
+func CacheControl(policy string, handler fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		handler(ctx)
+		switch ctx.Response.StatusCode() {
+		case http.StatusOK, http.StatusNotModified:
+			ctx.Response.Header.Set("Cache-Control", policy)
+		default:
+			ctx.Response.Header.Set("Cache-Control", "no-store")
+		}
+	}
+}
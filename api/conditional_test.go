@@ -0,0 +1,101 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestEtagMatches(t *testing.T) {
+	tests := []struct {
+		header string
+		etag   string
+		want   bool
+	}{
+		{`"a"`, `"a"`, true},
+		{`W/"a"`, `"a"`, true},
+		{`"a"`, `W/"a"`, true},
+		{`"b", "a"`, `W/"a"`, true},
+		{`*`, `"a"`, true},
+		{`"b"`, `"a"`, false},
+		{`"a`, `"a"`, false},
+		{`"a-gzip"`, `"a"`, true},
+		{`"b", "a-deflate"`, `"a"`, true},
+		{`"a-br"`, `"a"`, true},
+		{`"a-zstd"`, `"a"`, false},
+	}
+	for _, tt := range tests {
+		if got := etagMatches(tt.header, tt.etag); got != tt.want {
+			t.Errorf("etagMatches(%q, %q) = %v, want %v", tt.header, tt.etag, got, tt.want)
+		}
+	}
+}
+
+func TestWriteResponseConditional(t *testing.T) {
+	body := map[string]int{"n": 1}
+
+	ctx := newRequest("GET", "/api/conditional/1", "", nil)
+	WriteResponse(ctx, http.StatusOK, body)
+	etag := string(ctx.Response.Header.Peek("ETag"))
+	modified := string(ctx.Response.Header.Peek("Last-Modified"))
+	if ctx.Response.StatusCode() != http.StatusOK || etag == "" || modified == "" {
+		t.Fatalf("first GET: status %d, ETag %q, Last-Modified %q", ctx.Response.StatusCode(), etag, modified)
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		want   int
+	}{
+		{"same etag", "If-None-Match", etag, http.StatusNotModified},
+		{"other etag", "If-None-Match", `W/"0"`, http.StatusOK},
+		{"not modified since", "If-Modified-Since", modified, http.StatusNotModified},
+		{"modified since", "If-Modified-Since", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), http.StatusOK},
+	}
+	for _, tt := range tests {
+		ctx := newRequest("GET", "/api/conditional/1", "", nil)
+		ctx.Request.Header.Set(tt.header, tt.value)
+		WriteResponse(ctx, http.StatusOK, body)
+		if got := ctx.Response.StatusCode(); got != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, got, tt.want)
+		}
+		if tt.want == http.StatusNotModified && len(ctx.Response.Body()) != 0 {
+			t.Errorf("%s: 304 has body %q", tt.name, ctx.Response.Body())
+		}
+		if got := string(ctx.Response.Header.Peek("ETag")); got != etag {
+			t.Errorf("%s: ETag %q, want %q", tt.name, got, etag)
+		}
+	}
+
+	post := newRequest("POST", "/api/conditional/1", "", nil)
+	post.Request.Header.Set("If-None-Match", etag)
+	WriteResponse(post, http.StatusOK, body)
+	if got := post.Response.StatusCode(); got != http.StatusOK {
+		t.Errorf("POST with matching If-None-Match: status %d, want 200", got)
+	}
+}
+
+func TestCacheControl(t *testing.T) {
+	tests := []struct {
+		status int
+		want   string
+	}{
+		{http.StatusOK, "max-age=5"},
+		{http.StatusNotModified, "max-age=5"},
+		{http.StatusNotFound, "no-store"},
+		{http.StatusInternalServerError, "no-store"},
+	}
+	for _, tt := range tests {
+		handler := CacheControl("max-age=5", func(ctx *fasthttp.RequestCtx) {
+			ctx.SetStatusCode(tt.status)
+		})
+		ctx := newRequest("GET", "/", "", nil)
+		handler(ctx)
+		if got := string(ctx.Response.Header.Peek("Cache-Control")); got != tt.want {
+			t.Errorf("status %d: Cache-Control %q, want %q", tt.status, got, tt.want)
+		}
+	}
+}
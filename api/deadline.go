@@ -0,0 +1,56 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Deadline bounds the request context of handler by timeout, which cancels
+// its running queries. Internal errors caused by the expired or cancelled
+// context become 504 and 503 responses. A zero timeout leaves handler as is.
+// A body stream writer set by handler takes the context over, see
+// takeRelease, and is bounded by the same timeout.
+func Deadline(timeout time.Duration, handler fasthttp.RequestHandler) fasthttp.RequestHandler {
+	if timeout <= 0 {
+		return handler
+	}
+	return func(ctx *fasthttp.RequestCtx) {
+		c, cancel := context.WithTimeout(requestContext(ctx), timeout)
+		ctx.SetUserValue(contextKey, c)
+		saved := ctx.UserValue(releaseKey)
+		outer, _ := saved.(func())
+		ctx.SetUserValue(releaseKey, func() {
+			cancel()
+			if outer != nil {
+				outer()
+			}
+		})
+		handler(ctx)
+		if _, ok := ctx.UserValue(releaseKey).(func()); !ok {
+			// taken by a body stream writer
+			return
+		}
+		cancel()
+		ctx.SetUserValue(releaseKey, saved)
+		if ctx.Response.StatusCode() == http.StatusInternalServerError {
+			writeAborted(ctx, c.Err())
+		}
+	}
+}
+
+func writeAborted(ctx *fasthttp.RequestCtx, err error) {
+	var problem Problem
+	switch err {
+	case context.DeadlineExceeded:
+		problem = ErrTimeout
+	case context.Canceled:
+		problem = ErrCancelled
+	default:
+		return
+	}
+	ctx.Response.ResetBody()
+	writeProblem(ctx, problem)
+}
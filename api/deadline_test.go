@@ -0,0 +1,32 @@
+package api
+
+import (
+	"bufio"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestDeadlineHandsContextToStream(t *testing.T) {
+	var streamErr error
+	handler := Deadline(time.Second, func(ctx *fasthttp.RequestCtx) {
+		c := requestContext(ctx)
+		setBodyStreamWriter(ctx, func(w *bufio.Writer) {
+			streamErr = c.Err()
+			w.WriteString("[]")
+		})
+	})
+	ctx := newRequest("GET", "/api/thread/1/posts", "", nil)
+	handler(ctx)
+	ctx.Response.Body()
+	if streamErr != nil {
+		t.Errorf("stream writer context: %v", streamErr)
+	}
+
+	var c interface{ Err() error }
+	Deadline(time.Second, func(ctx *fasthttp.RequestCtx) { c = requestContext(ctx) })(newRequest("GET", "/api/thread/1", "", nil))
+	if c.Err() == nil {
+		t.Error("context of a handler without stream is not released")
+	}
+}
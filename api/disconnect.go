@@ -0,0 +1,108 @@
+package api
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// DisconnectProbeInterval is how often the connection of a running request
+// is checked for a client that went away.
+var DisconnectProbeInterval = 100 * time.Millisecond
+
+// conns maps remote addresses to the connections accepted by WatchConns,
+// as the vendored fasthttp doesn't hand connections to handlers.
+var conns sync.Map
+
+type watchedListener struct {
+	net.Listener
+}
+
+// WatchConns makes the connections accepted by ln known to
+// CancelOnDisconnect.
+func WatchConns(ln net.Listener) net.Listener {
+	return watchedListener{ln}
+}
+
+func (ln watchedListener) Accept() (net.Conn, error) {
+	c, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	key := c.RemoteAddr().String()
+	conns.Store(key, c)
+	return &watchedConn{Conn: c, key: key}, nil
+}
+
+type watchedConn struct {
+	net.Conn
+	key  string
+	once sync.Once
+}
+
+func (c *watchedConn) Close() error {
+	c.once.Do(func() { conns.Delete(c.key) })
+	return c.Conn.Close()
+}
+
+const releaseKey = "releaseContext"
+
+// CancelOnDisconnect cancels the request context, and so the queries of
+// the request, once its client closes the connection. fasthttp only learns
+// that when it writes the response, so the connection is peeked at every
+// DisconnectProbeInterval while the handler, or the body stream writer it
+// set, runs.
+func CancelOnDisconnect(handler fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		c, ok := conns.Load(ctx.RemoteAddr().String())
+		if !ok {
+			handler(ctx)
+			return
+		}
+		reqCtx, cancel := context.WithCancel(requestContext(ctx))
+		ctx.SetUserValue(contextKey, reqCtx)
+		done := make(chan struct{})
+		var once sync.Once
+		ctx.SetUserValue(releaseKey, func() {
+			once.Do(func() {
+				close(done)
+				cancel()
+			})
+		})
+		go watchConn(c.(net.Conn), cancel, done)
+		handler(ctx)
+		if release, ok := ctx.UserValue(releaseKey).(func()); ok {
+			release()
+		}
+	}
+}
+
+func watchConn(c net.Conn, cancel context.CancelFunc, done <-chan struct{}) {
+	ticker := time.NewTicker(DisconnectProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if closedByPeer(c) {
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+// takeRelease hands the release of the request context over to a body
+// stream writer, which runs after the handler returns.
+func takeRelease(ctx *fasthttp.RequestCtx) func() {
+	release, ok := ctx.UserValue(releaseKey).(func())
+	if !ok {
+		return func() {}
+	}
+	ctx.SetUserValue(releaseKey, nil)
+	return release
+}
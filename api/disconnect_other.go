@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package api
+
+import "net"
+
+// closedByPeer can't peek at connections here, so requests run to the end.
+func closedByPeer(c net.Conn) bool {
+	return false
+}
@@ -0,0 +1,83 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package api
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestClosedByPeerKeepsPipelinedBytes(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	if closedByPeer(server) {
+		t.Error("open idle connection reported closed")
+	}
+	client.Write([]byte("abc"))
+	time.Sleep(20 * time.Millisecond)
+	if closedByPeer(server) {
+		t.Error("connection with pending bytes reported closed")
+	}
+	b := make([]byte, 3)
+	if _, err := io.ReadFull(server, b); err != nil || string(b) != "abc" {
+		t.Errorf("read %q, %v after peeking", b, err)
+	}
+	client.Close()
+	time.Sleep(20 * time.Millisecond)
+	if !closedByPeer(server) {
+		t.Error("closed connection reported open")
+	}
+}
+
+func TestCancelOnDisconnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	cancelled := make(chan error, 1)
+	server := &fasthttp.Server{Handler: CancelOnDisconnect(func(ctx *fasthttp.RequestCtx) {
+		c := requestContext(ctx)
+		select {
+		case <-c.Done():
+			cancelled <- c.Err()
+		case <-time.After(3 * time.Second):
+			cancelled <- nil
+		}
+	})}
+	go server.Serve(WatchConns(ln))
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Write([]byte("GET / HTTP/1.1\r\nHost: test\r\n\r\n"))
+	time.Sleep(50 * time.Millisecond)
+	client.Close()
+	start := time.Now()
+	if err := <-cancelled; err != context.Canceled {
+		t.Fatalf("handler context error %v, want context.Canceled", err)
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("cancelled %v after the client left", waited)
+	}
+}
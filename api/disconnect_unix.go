@@ -0,0 +1,30 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package api
+
+import (
+	"net"
+	"syscall"
+)
+
+// closedByPeer reports whether the client closed c. It peeks, so bytes of
+// a pipelined request stay for the server to read.
+func closedByPeer(c net.Conn) bool {
+	sc, ok := c.(syscall.Conn)
+	if !ok {
+		return false
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return false
+	}
+	closed := false
+	raw.Read(func(fd uintptr) bool {
+		var b [1]byte
+		n, _, err := syscall.Recvfrom(int(fd), b[:], syscall.MSG_PEEK|syscall.MSG_DONTWAIT)
+		closed = n == 0 && err == nil || err == syscall.ECONNRESET
+		return true
+	})
+	return closed
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// VersionETag returns the strong ETag of a row version.
+func VersionETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+func setVersionETag(ctx *fasthttp.RequestCtx, version int64) {
+	ctx.Response.Header.Set("ETag", VersionETag(version))
+}
+
+func ifMatchVersion(ctx *fasthttp.RequestCtx) int64 {
+	return IfMatchVersion(string(ctx.Request.Header.Peek("If-Match")))
+}
+
+// IfMatchVersion returns the version demanded by an If-Match header: 0 when
+// the header is absent or "*", -1 when no listed ETag can ever match.
+func IfMatchVersion(header string) int64 {
+	header = strings.TrimSpace(header)
+	if header == "" || header == "*" {
+		return 0
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = decodedETag(strings.TrimSpace(tag))
+		if strings.HasPrefix(tag, "W/") || len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+			continue
+		}
+		if version, err := strconv.ParseInt(tag[1:len(tag)-1], 10, 64); err == nil && version > 0 {
+			return version
+		}
+	}
+	return -1
+}
+
+// encodedETag returns the strong etag of a body compressed with encoding:
+// "7" becomes "7-gzip", as the compressed bytes differ. Weak etags only
+// promise equivalent content and are kept.
+func encodedETag(etag string, encoding string) string {
+	if len(etag) < 2 || etag[0] != '"' || etag[len(etag)-1] != '"' {
+		return etag
+	}
+	return etag[:len(etag)-1] + "-" + encoding + `"`
+}
+
+// decodedETag strips the encoding suffix encodedETag adds.
+func decodedETag(etag string) string {
+	for _, encoding := range []string{"br", "gzip", "deflate"} {
+		if suffix := "-" + encoding + `"`; strings.HasPrefix(etag, `"`) && strings.HasSuffix(etag, suffix) {
+			return etag[:len(etag)-len(suffix)] + `"`
+		}
+	}
+	return etag
+}
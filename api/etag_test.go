@@ -0,0 +1,64 @@
+package api
+
+import (
+	"testing"
+)
+
+func TestIfMatchVersion(t *testing.T) {
+	tests := []struct {
+		header string
+		want   int64
+	}{
+		{"", 0},
+		{"*", 0},
+		{`"3"`, 3},
+		{` "3" `, 3},
+		{`"x", "5"`, 5},
+		{`W/"3"`, -1},
+		{`"0"`, -1},
+		{`"-2"`, -1},
+		{`3`, -1},
+		{`"abc"`, -1},
+		{`"3-gzip"`, 3},
+		{`"3-deflate"`, 3},
+		{`"3-br"`, 3},
+		{`"3-zstd"`, -1},
+	}
+	for _, tt := range tests {
+		ctx := newRequest("POST", "/api/thread/1/details", "", nil)
+		if tt.header != "" {
+			ctx.Request.Header.Set("If-Match", tt.header)
+		}
+		if got := ifMatchVersion(ctx); got != tt.want {
+			t.Errorf("ifMatchVersion(%q) = %d, want %d", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestSetVersionETag(t *testing.T) {
+	ctx := newRequest("GET", "/api/thread/1/details", "", nil)
+	setVersionETag(ctx, 7)
+	if got := string(ctx.Response.Header.Peek("ETag")); got != `"7"` {
+		t.Errorf("ETag = %s, want \"7\"", got)
+	}
+	ctx.Request.Header.Set("If-Match", `"7"`)
+	if got := ifMatchVersion(ctx); got != 7 {
+		t.Errorf("If-Match of the ETag gives version %d, want 7", got)
+	}
+}
+
+func TestEncodedETag(t *testing.T) {
+	tests := []struct{ etag, want string }{
+		{`"7"`, `"7-gzip"`},
+		{`W/"7"`, `W/"7"`},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := encodedETag(tt.etag, "gzip"); got != tt.want {
+			t.Errorf("encodedETag(%q) = %q, want %q", tt.etag, got, tt.want)
+		}
+		if got := decodedETag(encodedETag(tt.etag, "deflate")); got != tt.etag {
+			t.Errorf("decodedETag(encodedETag(%q)) = %q", tt.etag, got)
+		}
+	}
+}
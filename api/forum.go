@@ -3,7 +3,6 @@ package api
 import (
 	"db-forum/database"
 	"db-forum/models"
-	"log"
 	"net/http"
 	"strconv"
 
@@ -13,30 +12,26 @@ import (
 
 func CreateForum(ctx *fasthttp.RequestCtx) {
 	var forum models.Forum
-	body := ctx.PostBody()
-	if err := forum.UnmarshalJSON(body); err != nil {
-		WriteResponse(ctx, http.StatusBadRequest, models.Error{err.Error()})
+	if !decodeBody(ctx, &forum) {
 		return
 	}
-	forumAuthor, err := database.GetUserByUsername(forum.User)
+	forumAuthor, err := database.GetUserByUsername(requestContext(ctx), forum.User)
 	if err != nil {
 		if err == database.ErrNotFound {
-			WriteResponse(ctx, http.StatusNotFound, models.Error{"Can't find user"})
+			writeProblem(ctx, ErrUserNotFound)
 			return
 		}
-		log.Println(err.Error())
-		WriteResponse(ctx, http.StatusInternalServerError, models.Error{err.Error()})
+		writeError(ctx, err)
 		return
 	}
 	forum.User = forumAuthor.Nickname
-	newForum, err := database.CreateForum(&forum)
+	newForum, err := database.CreateForum(requestContext(ctx), &forum)
 	if err != nil {
 		if err == database.ErrDuplicate {
 			WriteResponse(ctx, http.StatusConflict, newForum)
 			return
 		}
-		log.Println(err.Error())
-		WriteResponse(ctx, http.StatusInternalServerError, err.Error())
+		writeError(ctx, err)
 		return
 	}
 	WriteResponse(ctx, http.StatusCreated, newForum)
@@ -44,14 +39,13 @@ func CreateForum(ctx *fasthttp.RequestCtx) {
 
 func GetForum(ctx *fasthttp.RequestCtx) {
 	slug := ctx.UserValue("slug").(string)
-	forum, err := database.GetForum(slug)
+	forum, err := database.GetForum(requestContext(ctx), slug)
 	if err != nil {
 		if err == database.ErrNotFound {
-			WriteResponse(ctx, http.StatusNotFound, models.Error{"Can't find forum"})
+			writeProblem(ctx, ErrForumNotFound)
 			return
 		}
-		log.Println(err.Error())
-		WriteResponse(ctx, http.StatusInternalServerError, models.Error{err.Error()})
+		writeError(ctx, err)
 		return
 	}
 	WriteResponse(ctx, http.StatusOK, forum)
@@ -69,21 +63,19 @@ func GetForumUsers(ctx *fasthttp.RequestCtx) {
 	if limit == "" {
 		limit = strconv.Itoa(intsets.MaxInt)
 	}
-	forum, err := database.GetForum(slug)
+	forum, err := database.GetForum(requestContext(ctx), slug)
 	if err != nil {
 		if err == database.ErrNotFound {
-			WriteResponse(ctx, http.StatusNotFound, models.Error{"can't find forum"})
+			writeProblem(ctx, ErrForumNotFound)
 			return
 		}
-		log.Println(err.Error())
-		WriteResponse(ctx, http.StatusInternalServerError, models.Error{err.Error()})
+		writeError(ctx, err)
 		return
 	}
 	slug = forum.Slug
-	users, err := database.GetForumUsers(slug, limit, since, desc)
+	users, err := database.GetForumUsers(requestContext(ctx), slug, limit, since, desc)
 	if err != nil {
-		log.Println(err.Error())
-		WriteResponse(ctx, http.StatusInternalServerError, models.Error{err.Error()})
+		writeError(ctx, err)
 		return
 	}
 	WriteResponse(ctx, http.StatusOK, users)
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync/atomic"
+
+	"db-forum/database"
+	"db-forum/logger"
+
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	inFlight int64
+	draining int32
+
+	baseContext, abort = context.WithCancel(context.Background())
+)
+
+// Track counts requests in flight, including streamed responses, and asks
+// clients to close keep-alive connections once the server drains.
+func Track(handler fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		atomic.AddInt64(&inFlight, 1)
+		defer atomic.AddInt64(&inFlight, -1)
+		if atomic.LoadInt32(&draining) != 0 {
+			ctx.SetConnectionClose()
+		}
+		handler(ctx)
+	}
+}
+
+// InFlight returns the number of requests being served.
+func InFlight() int64 {
+	return atomic.LoadInt64(&inFlight)
+}
+
+// Drain makes /readyz fail so that no new traffic is routed here.
+func Drain() {
+	atomic.StoreInt32(&draining, 1)
+}
+
+// Abort cancels the contexts of all requests in flight.
+func Abort() {
+	abort()
+}
+
+// Healthz reports that the process is alive.
+func Healthz(ctx *fasthttp.RequestCtx) {
+	ctx.SetContentType("text/plain; charset=utf-8")
+	ctx.WriteString("ok\n")
+}
+
+// Readyz reports whether the server can take traffic: it is not draining,
+// the database is reachable and its schema is current.
+func Readyz(ctx *fasthttp.RequestCtx) {
+	if err := ready(requestContext(ctx)); err != nil {
+		writeProblem(ctx, err.(Problem))
+		return
+	}
+	Healthz(ctx)
+}
+
+// ready returns ErrShuttingDown or ErrNotReady unless the server can take
+// traffic.
+func ready(ctx context.Context) error {
+	if atomic.LoadInt32(&draining) != 0 {
+		return ErrShuttingDown
+	}
+	if err := database.Ready(ctx); err != nil {
+		logger.FromContext(ctx).Error(err.Error(), "path", "/readyz")
+		return ErrNotReady
+	}
+	return nil
+}
+
+// Health serves /healthz and /readyz in front of a net/http handler, and
+// tracks requests in flight and drains connections as Track does.
+func Health(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&inFlight, 1)
+		defer atomic.AddInt64(&inFlight, -1)
+		if atomic.LoadInt32(&draining) != 0 {
+			w.Header().Set("Connection", "close")
+		}
+		switch r.URL.Path {
+		case "/readyz":
+			ctx := logger.NewContext(r.Context(), logger.RequestIDOrNew(r.Header.Get("X-Request-ID")))
+			if err := ready(ctx); err != nil {
+				p := err.(Problem)
+				w.Header().Set("Cache-Control", "no-store")
+				writeHTTP(w, p.Status, "application/problem+json", p.Payload(ctx))
+				return
+			}
+			fallthrough
+		case "/healthz":
+			w.Header().Set("Cache-Control", "no-store")
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			io.WriteString(w, "ok\n")
+		default:
+			handler.ServeHTTP(w, r)
+		}
+	})
+}
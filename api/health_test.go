@@ -0,0 +1,59 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestReadyzFailsWhileDraining(t *testing.T) {
+	defer atomic.StoreInt32(&draining, 0)
+	Drain()
+	ctx := newRequest("GET", "/readyz", "", nil)
+	Readyz(ctx)
+	if got := ctx.Response.StatusCode(); got != http.StatusServiceUnavailable {
+		t.Errorf("status %d, want 503", got)
+	}
+	if got := string(ctx.Response.Header.ContentType()); got != "application/problem+json" {
+		t.Errorf("Content-Type %q", got)
+	}
+
+	ctx = newRequest("GET", "/healthz", "", nil)
+	Track(Healthz)(ctx)
+	if got := ctx.Response.StatusCode(); got != http.StatusOK {
+		t.Errorf("/healthz while draining: status %d, want 200", got)
+	}
+	if !ctx.Response.ConnectionClose() {
+		t.Error("connection kept alive while draining")
+	}
+}
+
+func TestHealth(t *testing.T) {
+	served := false
+	handler := Health(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = true
+	}))
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	if w := serve("/healthz"); w.Code != http.StatusOK || w.Body.String() != "ok\n" || served {
+		t.Errorf("/healthz: status %d, body %q, passed on %v", w.Code, w.Body.String(), served)
+	}
+	if w := serve("/api/service/status"); !served || w.Header().Get("Connection") != "" {
+		t.Errorf("API request: passed on %v, Connection %q", served, w.Header().Get("Connection"))
+	}
+
+	defer atomic.StoreInt32(&draining, 0)
+	Drain()
+	w := serve("/readyz")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Content-Type") != "application/problem+json" {
+		t.Errorf("/readyz while draining: status %d, Content-Type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	if w.Header().Get("Connection") != "close" {
+		t.Error("connection kept alive while draining")
+	}
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"db-forum/database"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+var IdempotencyWindow = 24 * time.Hour
+
+// IdempotencyPending is how long a key stays claimed by a request that
+// neither stored nor released it, such as one whose process died.
+var IdempotencyPending = time.Minute
+
+func requestFingerprint(ctx *fasthttp.RequestCtx) string {
+	return RequestFingerprint(string(ctx.Method()), string(ctx.Path()), ctx.PostBody())
+}
+
+// RequestFingerprint tells apart the requests an Idempotency-Key may be
+// reused for: the same key with another fingerprint is refused.
+func RequestFingerprint(method string, path string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method))
+	h.Write([]byte{' '})
+	h.Write([]byte(path))
+	h.Write([]byte{'\n'})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ClaimIdempotencyKey claims key for the request with fingerprint. It
+// returns the stored response to replay or the problem refusing the
+// request; with neither, the caller serves the request and then stores or
+// releases the key.
+func ClaimIdempotencyKey(ctx context.Context, key string, fingerprint string) (*database.IdempotentResponse, *Problem, error) {
+	refuse := func(problem Problem) (*database.IdempotentResponse, *Problem, error) {
+		return nil, &problem, nil
+	}
+	if len(key) > 255 {
+		return refuse(ErrIdempotencyKey)
+	}
+	stored, err := database.ClaimIdempotencyKey(ctx, key, fingerprint, IdempotencyWindow, IdempotencyPending)
+	if err != nil || stored == nil {
+		return nil, nil, err
+	}
+	switch {
+	case stored.Fingerprint != fingerprint:
+		return refuse(ErrIdempotencyReused)
+	case stored.Pending:
+		return refuse(ErrIdempotencyPending)
+	}
+	return stored, nil, nil
+}
+
+// Idempotent stores the first response for a request carrying an
+// Idempotency-Key header and replays it byte-for-byte on retries. The key
+// is released, also when handler panics, unless the response was stored.
+func Idempotent(handler fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		key := string(ctx.Request.Header.Peek("Idempotency-Key"))
+		if key == "" || IdempotencyWindow <= 0 {
+			handler(ctx)
+			return
+		}
+		stored, problem, err := ClaimIdempotencyKey(requestContext(ctx), key, requestFingerprint(ctx))
+		if err != nil {
+			writeError(ctx, err)
+			return
+		}
+		if problem != nil {
+			writeProblem(ctx, *problem)
+			return
+		}
+		if stored != nil {
+			ctx.SetStatusCode(stored.Status)
+			ctx.SetContentType(stored.ContentType)
+			ctx.Response.Header.Set("Idempotent-Replayed", "true")
+			ctx.SetBody(stored.Body)
+			return
+		}
+
+		saved := false
+		defer func() {
+			if saved {
+				return
+			}
+			if err := database.ReleaseIdempotencyKey(detachedContext(ctx), key); err != nil {
+				logError(ctx, err)
+			}
+		}()
+
+		handler(ctx)
+
+		if !Replayable(ctx.Response.StatusCode()) {
+			return
+		}
+		if err := database.SaveIdempotencyKey(detachedContext(ctx), key, ctx.Response.StatusCode(), string(ctx.Response.Header.ContentType()), ctx.Response.Body()); err != nil {
+			logError(ctx, err)
+			return
+		}
+		saved = true
+	}
+}
+
+// Replayable tells whether a response is stored for retries. Failures the
+// retry may not hit again, such as rate limits, release the key instead.
+func Replayable(status int) bool {
+	return status < http.StatusInternalServerError && status != http.StatusTooManyRequests
+}
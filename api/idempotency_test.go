@@ -0,0 +1,61 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestRequestFingerprint(t *testing.T) {
+	base := requestFingerprint(newRequest("POST", "/api/user/a/create", `{"x":1}`, nil))
+	if again := requestFingerprint(newRequest("POST", "/api/user/a/create", `{"x":1}`, nil)); again != base {
+		t.Errorf("fingerprint of the same request changed: %s, %s", base, again)
+	}
+	for _, other := range []*fasthttp.RequestCtx{
+		newRequest("PUT", "/api/user/a/create", `{"x":1}`, nil),
+		newRequest("POST", "/api/user/b/create", `{"x":1}`, nil),
+		newRequest("POST", "/api/user/a/create", `{"x":2}`, nil),
+	} {
+		if requestFingerprint(other) == base {
+			t.Errorf("%s %s %s has the fingerprint of another request", other.Method(), other.Path(), other.PostBody())
+		}
+	}
+}
+
+func TestIdempotentWithoutKey(t *testing.T) {
+	calls := 0
+	handler := Idempotent(func(ctx *fasthttp.RequestCtx) { calls++ })
+	handler(newRequest("POST", "/api/forum/create", "{}", nil))
+	if calls != 1 {
+		t.Errorf("handler called %d times, want 1", calls)
+	}
+}
+
+func TestIdempotentRejectsLongKey(t *testing.T) {
+	calls := 0
+	handler := Idempotent(func(ctx *fasthttp.RequestCtx) { calls++ })
+	ctx := newRequest("POST", "/api/forum/create", "{}", nil)
+	ctx.Request.Header.Set("Idempotency-Key", strings.Repeat("k", 256))
+	handler(ctx)
+	if calls != 0 || ctx.Response.StatusCode() != http.StatusBadRequest {
+		t.Errorf("calls = %d, status = %d, want 0 and 400", calls, ctx.Response.StatusCode())
+	}
+}
+
+func TestReplayable(t *testing.T) {
+	for status, want := range map[int]bool{
+		http.StatusCreated:             true,
+		http.StatusConflict:            true,
+		http.StatusNotFound:            true,
+		http.StatusTooManyRequests:     false,
+		http.StatusInternalServerError: false,
+		http.StatusServiceUnavailable:  false,
+		http.StatusGatewayTimeout:      false,
+	} {
+		if got := Replayable(status); got != want {
+			t.Errorf("Replayable(%d) = %v, want %v", status, got, want)
+		}
+	}
+}
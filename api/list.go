@@ -0,0 +1,78 @@
+package api
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+
+	"db-forum/logger"
+
+	"github.com/mailru/easyjson"
+	"github.com/mailru/easyjson/jwriter"
+	"github.com/valyala/fasthttp"
+)
+
+// listChunkSize is how much JSON writeList buffers before moving it to the
+// response body.
+const listChunkSize = 32 << 10
+
+// writeList answers 200 with a JSON array of the items each passes to emit.
+// Items are marshaled as they are read instead of being collected into a
+// slice first, so they may be reused by the caller after emit.
+//
+// The array is streamed to the client unless it may be answered with 304:
+// only a conditional GET, or a HEAD, gets it buffered whole to hash for the
+// ETag and Last-Modified, with an error halfway replaced by a problem. A
+// streamed list failing halfway is logged and cut short, leaving the
+// client invalid JSON, as its status is already sent.
+func writeList(ctx *fasthttp.RequestCtx, each func(emit func(easyjson.Marshaler) error) error) {
+	ctx.SetContentType("application/json")
+	ctx.SetStatusCode(http.StatusOK)
+	if ctx.IsGet() && len(ctx.Request.Header.Peek("If-None-Match")) == 0 && len(ctx.Request.Header.Peek("If-Modified-Since")) == 0 {
+		reqCtx, path := requestContext(ctx), string(ctx.Path())
+		setBodyStreamWriter(ctx, func(w *bufio.Writer) {
+			if err := streamList(w, each); err != nil {
+				logger.FromContext(reqCtx).Error(err.Error(), "method", "GET", "path", path)
+			}
+		})
+		return
+	}
+	if err := streamList(ctx, each); err != nil {
+		ctx.Response.ResetBody()
+		writeError(ctx, err)
+		return
+	}
+	if notModified(ctx, ctx.Response.Body()) {
+		ctx.Response.ResetBody()
+		ctx.SetStatusCode(http.StatusNotModified)
+	}
+}
+
+// streamList writes the JSON array of the items each emits to out, moving
+// it there every listChunkSize bytes.
+func streamList(out io.Writer, each func(emit func(easyjson.Marshaler) error) error) error {
+	var w jwriter.Writer
+	w.RawByte('[')
+	n := 0
+	err := each(func(item easyjson.Marshaler) error {
+		if n > 0 {
+			w.RawByte(',')
+		}
+		n++
+		item.MarshalEasyJSON(&w)
+		if w.Error == nil && w.Size() >= listChunkSize {
+			_, err := w.DumpTo(out)
+			return err
+		}
+		return w.Error
+	})
+	if err != nil {
+		return err
+	}
+	w.RawByte(']')
+	if w.Error != nil {
+		return w.Error
+	}
+	_, err = w.DumpTo(out)
+	return err
+}
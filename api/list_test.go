@@ -0,0 +1,69 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"db-forum/models"
+
+	"github.com/mailru/easyjson"
+)
+
+func eachForum(slugs []string, err error) func(emit func(easyjson.Marshaler) error) error {
+	return func(emit func(easyjson.Marshaler) error) error {
+		var forum models.Forum
+		for _, slug := range slugs {
+			forum.Slug = slug
+			if err := emit(&forum); err != nil {
+				return err
+			}
+		}
+		return err
+	}
+}
+
+func TestWriteListStreams(t *testing.T) {
+	ctx := newRequest("GET", "/api/forum/x/threads", "", nil)
+	writeList(ctx, eachForum([]string{"a", "b"}, nil))
+	if !ctx.Response.IsBodyStream() {
+		t.Fatal("list is not streamed")
+	}
+	if etag := ctx.Response.Header.Peek("ETag"); len(etag) != 0 {
+		t.Errorf("streamed list has ETag %s", etag)
+	}
+	if got, want := string(ctx.Response.Body()), `[{"slug":"a","title":"","user":""},{"slug":"b","title":"","user":""}]`; got != want {
+		t.Errorf("body %s, want %s", got, want)
+	}
+
+	ctx = newRequest("GET", "/api/forum/x/threads", "", nil)
+	writeList(ctx, eachForum([]string{"a"}, errors.New("connection reset")))
+	if body := ctx.Response.Body(); json.Valid(body) {
+		t.Errorf("list failing halfway: body %s, want it cut short", body)
+	}
+}
+
+func TestWriteListConditional(t *testing.T) {
+	ctx := newRequest("GET", "/api/forum/x/threads", "", nil)
+	ctx.Request.Header.Set("If-None-Match", `"stale"`)
+	writeList(ctx, eachForum([]string{"a", "b"}, nil))
+	etag := string(ctx.Response.Header.Peek("ETag"))
+	if ctx.Response.IsBodyStream() || ctx.Response.StatusCode() != http.StatusOK || etag == "" {
+		t.Fatalf("streamed %v, status %d, ETag %q", ctx.Response.IsBodyStream(), ctx.Response.StatusCode(), etag)
+	}
+
+	ctx = newRequest("GET", "/api/forum/x/threads", "", nil)
+	ctx.Request.Header.Set("If-None-Match", etag)
+	writeList(ctx, eachForum([]string{"a", "b"}, nil))
+	if got := ctx.Response.StatusCode(); got != http.StatusNotModified {
+		t.Errorf("status %d, want 304", got)
+	}
+
+	ctx = newRequest("GET", "/api/forum/x/threads", "", nil)
+	ctx.Request.Header.Set("If-None-Match", etag)
+	writeList(ctx, eachForum([]string{"a"}, errors.New("connection reset")))
+	if got := ctx.Response.StatusCode(); got != http.StatusInternalServerError {
+		t.Errorf("buffered list failing halfway: status %d, want 500", got)
+	}
+}
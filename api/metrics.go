@@ -0,0 +1,74 @@
+package api
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"db-forum/database"
+	"db-forum/logger"
+	"db-forum/metrics"
+
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	requestsTotal = metrics.NewCounterVec("forum_http_requests_total",
+		"HTTP requests by route and status code.", "method", "route", "code")
+	requestDuration = metrics.NewHistogramVec("forum_http_request_duration_seconds",
+		"HTTP request latency by route.", metrics.DefBuckets, "method", "route")
+)
+
+func init() {
+	metrics.Default.Register(requestsTotal)
+	metrics.Default.Register(requestDuration)
+	metrics.Default.Register(metrics.NewGaugeFunc("forum_entities", "Estimated number of stored entities, from the planner statistics.",
+		[]string{"kind"}, func(emit func(float64, ...string)) {
+			counts, err := database.EstimateRows(context.Background())
+			if err != nil {
+				logger.Default().Error("can't estimate entities", "error", err)
+				return
+			}
+			for _, kind := range []string{"forum", "post", "thread", "user"} {
+				table := kind
+				if kind == "user" {
+					table = "users"
+				}
+				emit(float64(counts[table]), kind)
+			}
+		}))
+	metrics.Default.Register(metrics.NewCounterFunc("forum_cache_requests_total", "Cache lookups by cache and result.",
+		[]string{"cache", "result"}, func(emit func(float64, ...string)) {
+			all := cacheStats()
+			for _, name := range []string{"forums", "render", "threads", "users"} {
+				stats := all[name]
+				emit(float64(stats.Hits), name, "hit")
+				emit(float64(stats.Misses), name, "miss")
+			}
+		}))
+	metrics.Default.Register(metrics.NewGaugeFunc("forum_cache_entries", "Cached entries by cache.",
+		[]string{"cache"}, func(emit func(float64, ...string)) {
+			all := cacheStats()
+			for _, name := range []string{"forums", "render", "threads", "users"} {
+				emit(float64(all[name].Size), name)
+			}
+		}))
+}
+
+// Instrument counts requests of route and records their latency.
+func Instrument(method string, route string, handler fasthttp.RequestHandler) fasthttp.RequestHandler {
+	duration := requestDuration.With(method, route)
+	return func(ctx *fasthttp.RequestCtx) {
+		start := time.Now()
+		handler(ctx)
+		duration.Observe(time.Since(start).Seconds())
+		requestsTotal.With(method, route, strconv.Itoa(ctx.Response.StatusCode())).Inc()
+	}
+}
+
+func GetMetrics(ctx *fasthttp.RequestCtx) {
+	ctx.SetContentType("text/plain; version=0.0.4; charset=utf-8")
+	if err := metrics.Default.Write(ctx); err != nil {
+		logError(ctx, err)
+	}
+}
@@ -1,16 +1,23 @@
 package api
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"db-forum/database"
 	"db-forum/models"
+	"db-forum/render"
 	"encoding/json"
-	"log"
+	"io"
+	"math"
 	"net/http"
 	"strconv"
 
 	"strings"
 
 	"github.com/asaskevich/govalidator"
+	"github.com/mailru/easyjson"
+	"github.com/pkg/errors"
 	"github.com/valyala/fasthttp"
 	"golang.org/x/tools/container/intsets"
 )
@@ -21,21 +28,29 @@ func CreatePost(ctx *fasthttp.RequestCtx) {
 	body := ctx.PostBody()
 	slug := ctx.UserValue("slug").(string)
 	if err := json.Unmarshal(body, &posts); err != nil {
-		log.Println(err.Error())
-		WriteResponse(ctx, http.StatusBadRequest, models.Error{err.Error()})
+		writeProblem(ctx, ErrInvalidJSON)
+		return
 	}
-	resPosts, err := database.CreatePosts(&posts, slug)
+	resPosts, err := database.CreatePosts(requestContext(ctx), &posts, slug)
 	if err != nil {
 		if err == database.ErrNotFound {
-			WriteResponse(ctx, http.StatusNotFound, models.Error{"Can't find user"})
+			writeProblem(ctx, ErrThreadNotFound.WithMessage("Can't find thread by slug: "+slug))
+			return
+		}
+		if err == database.ErrNoAuthor {
+			writeProblem(ctx, ErrUserNotFound)
 			return
 		}
 		if err == database.ErrDuplicate {
-			WriteResponse(ctx, http.StatusConflict, models.Error{"ti priomniy"})
+			writeProblem(ctx, ErrParentInOtherThread)
 			return
 		}
-		log.Println(err.Error())
-		WriteResponse(ctx, http.StatusInternalServerError, models.Error{err.Error()})
+		if e, ok := err.(*database.SlowModeError); ok {
+			ctx.Response.Header.Set("Retry-After", seconds(e.Wait))
+			writeProblem(ctx, ErrSlowMode)
+			return
+		}
+		writeError(ctx, err)
 		return
 	}
 	WriteResponse(ctx, http.StatusCreated, resPosts)
@@ -43,7 +58,6 @@ func CreatePost(ctx *fasthttp.RequestCtx) {
 
 func GetPost(ctx *fasthttp.RequestCtx) {
 	var err error
-	var posts *[]models.Post
 	var thread *models.Thread
 	slug := ctx.UserValue("slug").(string)
 	limit, sort, since, desc := string(ctx.QueryArgs().Peek("limit")),
@@ -54,35 +68,58 @@ func GetPost(ctx *fasthttp.RequestCtx) {
 		limit = strconv.Itoa(intsets.MaxInt)
 	}
 	if govalidator.IsNumeric(slug) {
-		thread, err = database.GetThread(slug, slug)
+		thread, err = database.GetThread(requestContext(ctx), slug, slug)
 	} else {
-		thread, err = database.GetThreadBySlug(slug)
+		thread, err = database.GetThreadBySlug(requestContext(ctx), slug)
 	}
 	if err != nil {
 		if err == database.ErrNotFound {
-			WriteResponse(ctx, http.StatusNotFound, models.Error{"Can't find thread by slug " + slug})
+			writeProblem(ctx, ErrThreadNotFound.WithMessage("Can't find thread by slug: "+slug))
 			return
 		}
-		log.Println(err.Error())
-		WriteResponse(ctx, http.StatusInternalServerError, models.Error{err.Error()})
+		writeError(ctx, err)
+		return
+	}
+	if sort == "tree" && string(ctx.QueryArgs().Peek("format")) == "nested" {
+		getPostsNested(ctx, thread, limit, since, desc)
 		return
 	}
+	each := database.EachPostFlat
 	switch sort {
-	case "flat":
-		posts, err = database.GetPostsFlat(thread.ID, limit, since, desc)
 	case "tree":
-		posts, err = database.GetPostsTree(thread.ID, limit, since, desc)
+		each = database.EachPostTree
 	case "parent_tree":
-		posts, err = database.GetPostsParentTree(thread.ID, limit, since, desc)
-	default:
-		posts, err = database.GetPostsFlat(thread.ID, limit, since, desc)
+		each = database.EachPostParentTree
 	}
+	html := wantHTML(ctx)
+	writeList(ctx, func(emit func(easyjson.Marshaler) error) error {
+		return each(requestContext(ctx), thread.ID, limit, since, desc, func(post *models.Post) error {
+			if html {
+				post.HTML = render.HTML(post.Message)
+			}
+			return emit(post)
+		})
+	})
+}
+
+func getPostsNested(ctx *fasthttp.RequestCtx, thread *models.Thread, limit string, since string, desc string) {
+	var depth int
+	if d := string(ctx.QueryArgs().Peek("depth")); d != "" {
+		var err error
+		if depth, err = strconv.Atoi(d); err != nil || depth < 0 {
+			writeProblem(ctx, ErrInvalidParameter.WithField("depth").WithMessage("depth must be a non-negative integer"))
+			return
+		}
+	}
+	nodes, err := database.GetPostsTreeNodes(requestContext(ctx), thread.ID, limit, since, desc, depth)
 	if err != nil {
-		log.Println(err.Error())
-		WriteResponse(ctx, http.StatusInternalServerError, models.Error{err.Error()})
+		writeError(ctx, err)
 		return
 	}
-	WriteResponse(ctx, http.StatusOK, posts)
+	if wantHTML(ctx) {
+		renderTree(*nodes)
+	}
+	WriteResponse(ctx, http.StatusOK, nestPosts(*nodes))
 }
 
 func GetPostDetails(ctx *fasthttp.RequestCtx) {
@@ -92,58 +129,270 @@ func GetPostDetails(ctx *fasthttp.RequestCtx) {
 	params = append(params, strings.Split(related, ",")...)
 	id, err := strconv.Atoi(slug)
 	if err != nil {
-		WriteResponse(ctx, http.StatusBadRequest, models.Error{err.Error()})
+		writeProblem(ctx, ErrInvalidParameter.WithField("id").WithMessage("id must be an integer"))
 		return
 	}
-	post, err := database.GetPostByID(int64(id))
+	post, err := database.GetPostByID(requestContext(ctx), int64(id))
 	if err != nil {
 		if err == database.ErrNotFound {
-			WriteResponse(ctx, http.StatusNotFound, models.Error{"can't find post"})
+			writeProblem(ctx, ErrPostNotFound)
 			return
 		}
-		log.Println(err.Error())
-		WriteResponse(ctx, http.StatusInternalServerError, models.Error{err.Error()})
+		writeError(ctx, err)
 		return
 	}
 	var postFull models.PostFull
 	for _, param := range params {
 		switch param {
 		case "user":
-			postFull.Author, err = database.GetUserByUsername(post.Author)
+			postFull.Author, err = database.GetUserByUsername(requestContext(ctx), post.Author)
 		case "forum":
-			postFull.Forum, err = database.GetForum(post.Forum)
+			postFull.Forum, err = database.GetForum(requestContext(ctx), post.Forum)
 		case "thread":
-			postFull.Thread, err = database.GetThreadByIDint32(post.Thread)
+			postFull.Thread, err = database.GetThreadByIDint32(requestContext(ctx), post.Thread)
 		}
 	}
-	postFull.Post = post
+	if wantHTML(ctx) {
+		post.HTML = render.HTML(post.Message)
+		if postFull.Thread != nil {
+			postFull.Thread.HTML = render.HTML(postFull.Thread.Message)
+		}
+	}
+	postFull.Post = &post.Post
+	if related == "" {
+		setVersionETag(ctx, post.Version)
+	}
 	WriteResponse(ctx, http.StatusOK, postFull)
 }
 
+// The subtree of a post context has defaultDescendants posts unless the
+// client asks for up to maxDescendants.
+const (
+	defaultDescendants = 100
+	maxDescendants     = 1000
+)
+
+// parseBound parses a query argument from 0 to max, def when it is empty.
+func parseBound(arg string, def int, max int) (int, error) {
+	if arg == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 0 || n > max {
+		return 0, errors.Errorf("must be an integer from 0 to %d", max)
+	}
+	return n, nil
+}
+
+func GetPostContext(ctx *fasthttp.RequestCtx) {
+	slug := ctx.UserValue("slug").(string)
+	id, err := strconv.Atoi(slug)
+	if err != nil {
+		writeProblem(ctx, ErrInvalidParameter.WithField("id").WithMessage("id must be an integer"))
+		return
+	}
+	ancestors, descendants, depth := 0, defaultDescendants, 0
+	for _, b := range []struct {
+		name  string
+		value *int
+		max   int
+	}{
+		{"ancestors", &ancestors, math.MaxInt32},
+		{"descendants", &descendants, maxDescendants},
+		{"depth", &depth, math.MaxInt32},
+	} {
+		if *b.value, err = parseBound(string(ctx.QueryArgs().Peek(b.name)), *b.value, b.max); err != nil {
+			writeProblem(ctx, ErrInvalidParameter.WithField(b.name).WithMessage(b.name+" "+err.Error()))
+			return
+		}
+	}
+	post, err := database.GetPostByID(requestContext(ctx), int64(id))
+	if err != nil {
+		if err == database.ErrNotFound {
+			writeProblem(ctx, ErrPostNotFound)
+			return
+		}
+		writeError(ctx, err)
+		return
+	}
+	parents, err := database.GetPostAncestors(requestContext(ctx), post, ancestors)
+	if err != nil {
+		writeError(ctx, err)
+		return
+	}
+	children, err := database.GetPostDescendants(requestContext(ctx), post, descendants, depth)
+	if err != nil {
+		writeError(ctx, err)
+		return
+	}
+	if wantHTML(ctx) {
+		post.HTML = render.HTML(post.Message)
+		renderPosts(*parents)
+		renderTree(*children)
+	}
+	WriteResponse(ctx, http.StatusOK, models.PostContext{
+		Ancestors:   *parents,
+		Descendants: nestPosts(*children),
+		Post:        &post.Post,
+	})
+}
+
 func UpdatePost(ctx *fasthttp.RequestCtx) {
 	slug := ctx.UserValue("slug").(string)
 	id, err := strconv.Atoi(slug)
 	if err != nil {
-		WriteResponse(ctx, http.StatusBadRequest, models.Error{err.Error()})
+		writeProblem(ctx, ErrInvalidParameter.WithField("id").WithMessage("id must be an integer"))
 		return
 	}
-	var post models.Post
-	body := ctx.PostBody()
-	if err := post.UnmarshalJSON(body); err != nil {
-		WriteResponse(ctx, http.StatusBadRequest, models.Error{err.Error()})
+	var update models.PostUpdate
+	if !decodeBody(ctx, &update) {
 		return
 	}
-	post.ID = int64(id)
-	newPost, err := database.UpdatePost(&post)
+	var post database.PostRow
+	post.ID, post.Message, post.Version = int64(id), update.Message, ifMatchVersion(ctx)
+	newPost, err := database.UpdatePost(requestContext(ctx), &post)
 	if err != nil {
 		if err == database.ErrNotFound {
-			WriteResponse(ctx, http.StatusNotFound, models.Error{"can't find post"})
+			writeProblem(ctx, ErrPostNotFound)
+			return
+		}
+		if err == database.ErrPreconditionFailed {
+			writeProblem(ctx, ErrPreconditionFailed.WithMessage("post was modified, ETag doesn't match"))
+			return
+		}
+		writeError(ctx, err)
+		return
+	}
+	setVersionETag(ctx, newPost.Version)
+
+	WriteResponse(ctx, http.StatusOK, &newPost.Post)
+}
+
+func MovePost(ctx *fasthttp.RequestCtx) {
+	slug := ctx.UserValue("slug").(string)
+	id, err := strconv.Atoi(slug)
+	if err != nil {
+		writeProblem(ctx, ErrInvalidParameter.WithField("id").WithMessage("id must be an integer"))
+		return
+	}
+	var move models.PostMove
+	if err := move.UnmarshalJSON(ctx.PostBody()); err != nil {
+		writeProblem(ctx, ErrInvalidJSON)
+		return
+	}
+	var thread *models.Thread
+	if move.Thread != "" {
+		thread, err = database.GetThreadBySlugOrID(requestContext(ctx), move.Thread)
+		if err != nil {
+			if err == database.ErrNotFound {
+				writeProblem(ctx, ErrThreadNotFound.WithMessage("Can't find thread by slug: "+move.Thread))
+				return
+			}
+			writeError(ctx, err)
+			return
+		}
+	}
+	post, err := database.MovePost(requestContext(ctx), int64(id), thread, move.Parent)
+	if err != nil {
+		switch err {
+		case database.ErrNotFound:
+			writeProblem(ctx, ErrPostNotFound)
+		case database.ErrConflict:
+			writeProblem(ctx, ErrBadMoveTarget)
+		default:
+			writeError(ctx, err)
+		}
+		return
+	}
+	WriteResponse(ctx, http.StatusOK, post)
+}
+
+// postStream decodes posts one by one from a JSON array or NDJSON body.
+type postStream struct {
+	dec   *json.Decoder
+	array bool
+	err   error
+}
+
+func newPostStream(body io.Reader) (*postStream, error) {
+	r := bufio.NewReader(body)
+	stream := &postStream{}
+	for {
+		c, err := r.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if c == ' ' || c == '\t' || c == '\r' || c == '\n' {
+			continue
+		}
+		r.UnreadByte()
+		stream.array = c == '['
+		break
+	}
+	stream.dec = json.NewDecoder(r)
+	if stream.array {
+		if _, err := stream.dec.Token(); err != nil {
+			return nil, err
+		}
+	}
+	return stream, nil
+}
+
+func (s *postStream) Next() (*models.Post, error) {
+	if s.array && !s.dec.More() {
+		return nil, io.EOF
+	}
+	var post models.Post
+	if err := s.dec.Decode(&post); err != nil {
+		if err != io.EOF {
+			s.err = err
+		}
+		return nil, err
+	}
+	return &post, nil
+}
+
+func ImportPosts(ctx *fasthttp.RequestCtx) {
+	imported, err := importPosts(requestContext(ctx), ctx.UserValue("slug").(string), bytes.NewReader(ctx.PostBody()))
+	if err != nil {
+		if p, ok := err.(Problem); ok {
+			writeProblem(ctx, p)
 			return
 		}
-		log.Println(err.Error())
-		WriteResponse(ctx, http.StatusInternalServerError, models.Error{err.Error()})
+		writeError(ctx, err)
 		return
 	}
+	WriteResponse(ctx, http.StatusCreated, imported)
+}
 
-	WriteResponse(ctx, http.StatusOK, newPost)
+// importPosts imports the posts read from body into the thread slug.
+// Errors of the catalog are returned as Problem.
+func importPosts(ctx context.Context, slug string, body io.Reader) (*models.PostImport, error) {
+	thread, err := database.GetThreadBySlugOrID(ctx, slug)
+	if err != nil {
+		if err == database.ErrNotFound {
+			return nil, ErrThreadNotFound.WithMessage("Can't find thread by slug: " + slug)
+		}
+		return nil, err
+	}
+	stream, err := newPostStream(body)
+	if err != nil {
+		return nil, bodyProblem(err)
+	}
+	imported, err := database.ImportPosts(ctx, thread, stream.Next)
+	if err != nil {
+		switch {
+		case stream.err != nil:
+			return nil, bodyProblem(stream.err)
+		case err == database.ErrNotFound:
+			return nil, ErrUserNotFound
+		case err == database.ErrConflict:
+			return nil, ErrImportConflict
+		}
+		return nil, err
+	}
+	return &models.PostImport{Imported: imported}, nil
 }
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestParseBound(t *testing.T) {
+	tests := []struct {
+		arg     string
+		want    int
+		wantErr bool
+	}{
+		{"", 100, false},
+		{"0", 0, false},
+		{"1000", 1000, false},
+		{"1001", 0, true},
+		{"-1", 0, true},
+		{"abc", 0, true},
+		{"99999999999999999999", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseBound(tt.arg, defaultDescendants, maxDescendants)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseBound(%q) = %d, %v, want %d, error %v", tt.arg, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestGetPostContextRejectsBadBounds(t *testing.T) {
+	for _, query := range []string{"descendants=abc", "descendants=-1", "descendants=1001", "ancestors=x", "depth=-2"} {
+		ctx := newRequest("GET", "/api/post/1/context?"+query, "", map[string]string{"slug": "1"})
+		GetPostContext(ctx)
+		if ctx.Response.StatusCode() != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", query, ctx.Response.StatusCode())
+		}
+	}
+}
+
+func TestMovePostRejectsBadInput(t *testing.T) {
+	tests := []struct {
+		slug string
+		body string
+		code string
+	}{
+		{"x", `{"parent": 1}`, ErrInvalidParameter.Code},
+		{"1", `{"parent": `, ErrInvalidJSON.Code},
+	}
+	for _, tt := range tests {
+		ctx := newRequest("POST", "/api/post/"+tt.slug+"/move", tt.body, map[string]string{"slug": tt.slug})
+		MovePost(ctx)
+		if ctx.Response.StatusCode() != http.StatusBadRequest || !strings.Contains(string(ctx.Response.Body()), tt.code) {
+			t.Errorf("move %s %s: %d %s, want 400 %s", tt.slug, tt.body, ctx.Response.StatusCode(), ctx.Response.Body(), tt.code)
+		}
+	}
+}
+
+func TestPostStream(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		want  []string
+		valid bool
+	}{
+		{"array", ` [{"author":"a"}, {"author":"b"}]`, []string{"a", "b"}, true},
+		{"ndjson", "{\"author\":\"a\"}\n{\"author\":\"b\"}\n", []string{"a", "b"}, true},
+		{"empty array", "[]", nil, true},
+		{"empty body", " \n", nil, true},
+		{"broken", `[{"author":"a"}, {"author":`, []string{"a"}, false},
+	}
+	for _, tt := range tests {
+		stream, err := newPostStream(strings.NewReader(tt.body))
+		if err != nil {
+			t.Errorf("%s: newPostStream: %v", tt.name, err)
+			continue
+		}
+		var got []string
+		for {
+			post, err := stream.Next()
+			if err != nil {
+				break
+			}
+			got = append(got, post.Author)
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") || (stream.err == nil) != tt.valid {
+			t.Errorf("%s: posts %v, error %v, want %v, valid %v", tt.name, got, stream.err, tt.want, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"db-forum/logger"
+	"db-forum/models"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Problem is an error of the catalog answered as application/problem+json.
+// Code is stable and meant for clients, Message for people.
+type Problem struct {
+	Status  int
+	Code    string
+	Message string
+	Field   string
+}
+
+func (p Problem) Error() string {
+	return p.Message
+}
+
+// WithMessage returns p with a more specific message.
+func (p Problem) WithMessage(message string) Problem {
+	p.Message = message
+	return p
+}
+
+// WithField returns p blaming the request parameter or body field.
+func (p Problem) WithField(field string) Problem {
+	p.Field = field
+	return p
+}
+
+// Payload returns the response body of p in the request of ctx.
+func (p Problem) Payload(ctx context.Context) *models.Error {
+	return &models.Error{
+		Code:      p.Code,
+		Status:    int32(p.Status),
+		Message:   p.Message,
+		Field:     p.Field,
+		RequestID: logger.RequestID(ctx),
+	}
+}
+
+// The error catalog.
+var (
+	ErrInvalidJSON      = Problem{Status: http.StatusBadRequest, Code: "invalid_json", Message: "request body is not valid JSON"}
+	ErrInvalidRequest   = Problem{Status: http.StatusBadRequest, Code: "invalid_request", Message: "request parameters are invalid"}
+	ErrInvalidParameter = Problem{Status: http.StatusBadRequest, Code: "invalid_parameter", Message: "request parameter is invalid"}
+	ErrInvalidArchive   = Problem{Status: http.StatusBadRequest, Code: "invalid_archive", Message: "archive is malformed"}
+	ErrIdempotencyKey   = Problem{Status: http.StatusBadRequest, Code: "invalid_idempotency_key", Message: "Idempotency-Key is too long", Field: "Idempotency-Key"}
+
+	ErrForbidden = Problem{Status: http.StatusForbidden, Code: "forbidden", Message: "bad admin token"}
+
+	ErrRouteNotFound  = Problem{Status: http.StatusNotFound, Code: "route_not_found", Message: "no such endpoint"}
+	ErrUserNotFound   = Problem{Status: http.StatusNotFound, Code: "user_not_found", Message: "Can't find user"}
+	ErrForumNotFound  = Problem{Status: http.StatusNotFound, Code: "forum_not_found", Message: "Can't find forum"}
+	ErrThreadNotFound = Problem{Status: http.StatusNotFound, Code: "thread_not_found", Message: "Can't find thread"}
+	ErrPostNotFound   = Problem{Status: http.StatusNotFound, Code: "post_not_found", Message: "Can't find post"}
+
+	ErrMethodNotAllowed = Problem{Status: http.StatusMethodNotAllowed, Code: "method_not_allowed", Message: "method is not allowed"}
+
+	ErrEmailTaken          = Problem{Status: http.StatusConflict, Code: "email_taken", Message: "This email is already registered", Field: "email"}
+	ErrParentInOtherThread = Problem{Status: http.StatusConflict, Code: "parent_in_other_thread", Message: "Parent post was created in another thread", Field: "parent"}
+	ErrBadMoveTarget       = Problem{Status: http.StatusConflict, Code: "bad_move_target", Message: "parent post must be in the target thread and outside the moved subtree", Field: "parent"}
+	ErrPostNotInThread     = Problem{Status: http.StatusConflict, Code: "post_not_in_thread", Message: "post doesn't belong to the thread", Field: "post"}
+	ErrMergeIntoItself     = Problem{Status: http.StatusConflict, Code: "merge_into_itself", Message: "can't merge thread into itself", Field: "thread"}
+	ErrImportConflict      = Problem{Status: http.StatusConflict, Code: "import_conflict", Message: "parent posts must exist in the import or in the thread, ids must be unique"}
+	ErrArchiveConflict     = Problem{Status: http.StatusConflict, Code: "archive_conflict", Message: "archive conflicts with existing data"}
+	ErrIdempotencyPending  = Problem{Status: http.StatusConflict, Code: "idempotency_key_in_progress", Message: "request with this Idempotency-Key is still in progress"}
+
+	ErrPreconditionFailed = Problem{Status: http.StatusPreconditionFailed, Code: "precondition_failed", Message: "resource was modified, ETag doesn't match", Field: "If-Match"}
+
+	ErrBodyTooLarge = Problem{Status: http.StatusRequestEntityTooLarge, Code: "body_too_large", Message: "request body is too large"}
+
+	ErrIdempotencyReused = Problem{Status: http.StatusUnprocessableEntity, Code: "idempotency_key_reused", Message: "Idempotency-Key was already used for a different request"}
+	ErrArchiveInvalid    = Problem{Status: http.StatusUnprocessableEntity, Code: "archive_inconsistent", Message: "archive refers to missing or conflicting data"}
+
+	ErrRateLimited = Problem{Status: http.StatusTooManyRequests, Code: "rate_limited", Message: "too many requests, retry later"}
+	ErrSlowMode    = Problem{Status: http.StatusTooManyRequests, Code: "slow_mode", Message: "thread is in slow mode, retry later"}
+
+	ErrInternal     = Problem{Status: http.StatusInternalServerError, Code: "internal", Message: "internal server error"}
+	ErrShuttingDown = Problem{Status: http.StatusServiceUnavailable, Code: "shutting_down", Message: "shutting down"}
+	ErrNotReady     = Problem{Status: http.StatusServiceUnavailable, Code: "not_ready", Message: "database is not ready"}
+	ErrCancelled    = Problem{Status: http.StatusServiceUnavailable, Code: "cancelled", Message: "request was cancelled"}
+	ErrTimeout      = Problem{Status: http.StatusGatewayTimeout, Code: "timeout", Message: "request timed out"}
+)
+
+// writeProblem answers the request with p.
+func writeProblem(ctx *fasthttp.RequestCtx, p Problem) {
+	WriteResponse(ctx, p.Status, p.Payload(requestContext(ctx)))
+}
+
+// writeError logs an unexpected error and answers 500 without its text,
+// which may quote SQL.
+func writeError(ctx *fasthttp.RequestCtx, err error) {
+	logError(ctx, err)
+	writeProblem(ctx, ErrInternal)
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"crypto/subtle"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Limit is a token bucket: a client may make Burst requests at once and
+// Rate requests a second after that. Zero Rate is no limit.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateStatus is the state of a client's bucket after Take.
+type RateStatus struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// Header sets the X-RateLimit-* headers of s, and Retry-After when the
+// request is refused.
+func (s RateStatus) Header(set func(key string, value string)) {
+	set("X-RateLimit-Limit", strconv.Itoa(s.Limit))
+	set("X-RateLimit-Remaining", strconv.Itoa(s.Remaining))
+	set("X-RateLimit-Reset", seconds(s.Reset))
+	if !s.Allowed {
+		set("Retry-After", seconds(s.RetryAfter))
+	}
+}
+
+// seconds formats d as whole seconds rounded up, for headers.
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// Limiter keeps a bucket of one Limit per client. Buckets refilled to the
+// brim are dropped once a minute.
+type Limiter struct {
+	limit Limit
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+func NewLimiter(limit Limit) *Limiter {
+	return &Limiter{limit: limit, buckets: make(map[string]*bucket), swept: time.Now()}
+}
+
+// Take takes a token from the bucket of key if there is one.
+func (l *Limiter) Take(key string) RateStatus {
+	return l.take(key, time.Now())
+}
+
+func (l *Limiter) take(key string, now time.Time) RateStatus {
+	burst := float64(l.limit.Burst)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.swept) > time.Minute {
+		for k, b := range l.buckets {
+			if l.refill(b, now) >= burst {
+				delete(l.buckets, k)
+			}
+		}
+		l.swept = now
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, updated: now}
+		l.buckets[key] = b
+	}
+	b.tokens, b.updated = l.refill(b, now), now
+	status := RateStatus{Limit: l.limit.Burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		status.Allowed = true
+	} else {
+		status.RetryAfter = l.wait(1 - b.tokens)
+	}
+	status.Remaining = int(b.tokens)
+	status.Reset = l.wait(burst - b.tokens)
+	return status
+}
+
+func (l *Limiter) refill(b *bucket, now time.Time) float64 {
+	return math.Min(float64(l.limit.Burst), b.tokens+now.Sub(b.updated).Seconds()*l.limit.Rate)
+}
+
+// wait returns how long refilling tokens takes.
+func (l *Limiter) wait(tokens float64) time.Duration {
+	return time.Duration(tokens / l.limit.Rate * float64(time.Second))
+}
+
+// RateKey returns the client a request is counted against: the admin,
+// the only client that authenticates, or else the remote address.
+func RateKey(ip net.IP, authorization []byte) string {
+	if AdminToken != "" && subtle.ConstantTimeCompare(authorization, []byte("Bearer "+AdminToken)) == 1 {
+		return "admin"
+	}
+	return ip.String()
+}
+
+// RateLimit answers 429 to clients that run out of tokens of limiter and
+// tells every client how many are left. A nil limiter leaves handler as is.
+func RateLimit(limiter *Limiter, handler fasthttp.RequestHandler) fasthttp.RequestHandler {
+	if limiter == nil {
+		return handler
+	}
+	return func(ctx *fasthttp.RequestCtx) {
+		status := limiter.Take(RateKey(ctx.RemoteIP(), ctx.Request.Header.Peek("Authorization")))
+		status.Header(ctx.Response.Header.Set)
+		if !status.Allowed {
+			writeProblem(ctx, ErrRateLimited)
+			return
+		}
+		handler(ctx)
+	}
+}
@@ -0,0 +1,18 @@
+package api
+
+import (
+	"db-forum/models"
+	"db-forum/render"
+
+	"github.com/valyala/fasthttp"
+)
+
+func wantHTML(ctx *fasthttp.RequestCtx) bool {
+	return string(ctx.QueryArgs().Peek("render")) == "html"
+}
+
+func renderPosts(posts []models.Post) {
+	for i := range posts {
+		posts[i].HTML = render.HTML(posts[i].Message)
+	}
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"db-forum/database"
+	"db-forum/logger"
+
+	"github.com/valyala/fasthttp"
+)
+
+const contextKey = "requestContext"
+
+// WriteCookie carries the WAL position after the last write of a client,
+// so that its later requests read from the primary until the replica has
+// replayed that write. It expires after writeCookieAge, by when a healthy
+// replica has long caught up.
+const WriteCookie = "forum_write"
+
+const writeCookieAge = 5 * 60
+
+// RequestID honors a valid X-Request-ID header or generates one, echoes it
+// in the response and stores a context carrying it for the handlers.
+func RequestID(handler fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		id := logger.RequestIDOrNew(string(ctx.Request.Header.Peek("X-Request-ID")))
+		ctx.Response.Header.Set("X-Request-ID", id)
+		session := database.NewSession(logger.NewContext(baseContext, id), string(ctx.Request.Header.Cookie(WriteCookie)))
+		ctx.SetUserValue(contextKey, session)
+		handler(ctx)
+		SetWriteCookie(session, ctx.Response.Header.Set)
+	}
+}
+
+// SetWriteCookie sets WriteCookie if the session ctx wrote to the database.
+func SetWriteCookie(ctx context.Context, set func(key string, value string)) {
+	if lsn := database.WritePosition(ctx); lsn != "" {
+		cookie := &http.Cookie{Name: WriteCookie, Value: lsn, Path: "/", MaxAge: writeCookieAge, HttpOnly: true}
+		set("Set-Cookie", cookie.String())
+	}
+}
+
+// requestContext returns the context of the request for database calls.
+func requestContext(ctx *fasthttp.RequestCtx) context.Context {
+	if c, ok := ctx.UserValue(contextKey).(context.Context); ok {
+		return c
+	}
+	return context.Background()
+}
+
+// detachedContext keeps the request ID of the request but not its deadline,
+// for bookkeeping that has to run after the request was cut short.
+func detachedContext(ctx *fasthttp.RequestCtx) context.Context {
+	return DetachedContext(requestContext(ctx))
+}
+
+// DetachedContext is detachedContext for a request context of net/http.
+func DetachedContext(ctx context.Context) context.Context {
+	return logger.NewContext(context.Background(), logger.RequestID(ctx))
+}
+
+func logError(ctx *fasthttp.RequestCtx, err error) {
+	logger.FromContext(requestContext(ctx)).Error(err.Error(),
+		"method", string(ctx.Method()), "path", string(ctx.Path()))
+}
@@ -2,27 +2,86 @@ package api
 
 import (
 	"encoding/json"
-
 	"net/http"
 
-	"log"
+	"db-forum/logger"
+	"db-forum/models"
 
+	"github.com/mailru/easyjson"
+	"github.com/mailru/easyjson/jwriter"
 	"github.com/valyala/fasthttp"
 )
 
+// WriteResponse answers with statusCode and body marshaled straight into
+// the response buffer, by easyjson when body supports it.
 func WriteResponse(ctx *fasthttp.RequestCtx, statusCode int, body interface{}) {
 	ctx.SetContentType("application/json")
 
-	resp, err := json.Marshal(body)
-	if err != nil {
-		log.Println(err.Error())
-		ctx.SetStatusCode(http.StatusInternalServerError)
+	switch e := body.(type) {
+	case models.Error:
+		problemBody(ctx, statusCode, &e)
+		body = e
+	case *models.Error:
+		problemBody(ctx, statusCode, e)
+	}
+	ctx.SetStatusCode(statusCode)
+	var w jwriter.Writer
+	marshal(&w, body)
+	writeBody(ctx, &w)
+}
+
+// writeBody appends the JSON in w to the response body and answers 304
+// instead when the client has the same representation.
+func writeBody(ctx *fasthttp.RequestCtx, w *jwriter.Writer) {
+	if w.Error != nil {
+		writeError(ctx, w.Error)
 		return
 	}
-	if _, err := ctx.Write(resp); err != nil {
-		log.Println(err.Error())
-		ctx.SetStatusCode(http.StatusInternalServerError)
+	if _, err := w.DumpTo(ctx); err != nil {
+		writeError(ctx, err)
 		return
 	}
-	ctx.SetStatusCode(statusCode)
+	if ctx.Response.StatusCode() == http.StatusOK && (ctx.IsGet() || ctx.IsHead()) && notModified(ctx, ctx.Response.Body()) {
+		ctx.Response.ResetBody()
+		ctx.SetStatusCode(http.StatusNotModified)
+	}
+}
+
+func marshal(w *jwriter.Writer, body interface{}) {
+	switch b := body.(type) {
+	case easyjson.Marshaler:
+		b.MarshalEasyJSON(w)
+	case *[]models.Post:
+		marshalArray(w, len(*b), func(i int) easyjson.Marshaler { return &(*b)[i] })
+	case *[]models.Thread:
+		marshalArray(w, len(*b), func(i int) easyjson.Marshaler { return &(*b)[i] })
+	case []models.Thread:
+		marshalArray(w, len(b), func(i int) easyjson.Marshaler { return &b[i] })
+	case *[]models.User:
+		marshalArray(w, len(*b), func(i int) easyjson.Marshaler { return &(*b)[i] })
+	case []models.User:
+		marshalArray(w, len(b), func(i int) easyjson.Marshaler { return &b[i] })
+	default:
+		w.Raw(json.Marshal(body))
+	}
+}
+
+func marshalArray(w *jwriter.Writer, n int, item func(i int) easyjson.Marshaler) {
+	w.RawByte('[')
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			w.RawByte(',')
+		}
+		item(i).MarshalEasyJSON(w)
+	}
+	w.RawByte(']')
+}
+
+// problemBody completes an error response as application/problem+json.
+func problemBody(ctx *fasthttp.RequestCtx, statusCode int, e *models.Error) {
+	ctx.SetContentType("application/problem+json")
+	e.RequestID = logger.RequestID(requestContext(ctx))
+	if e.Status == 0 {
+		e.Status = int32(statusCode)
+	}
 }
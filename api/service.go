@@ -3,16 +3,29 @@ package api
 import (
 	"net/http"
 
+	"db-forum/cache"
 	"db-forum/database"
+	"db-forum/render"
 
 	"github.com/valyala/fasthttp"
 )
 
 func ClearService(ctx *fasthttp.RequestCtx) {
-	database.ClearTable()
+	database.ClearTable(requestContext(ctx))
+	render.Reset()
 	WriteResponse(ctx, http.StatusOK, nil)
 }
 
 func GetServiceStatus(ctx *fasthttp.RequestCtx) {
-	WriteResponse(ctx, http.StatusOK, database.GetStatus())
+	WriteResponse(ctx, http.StatusOK, database.GetStatus(requestContext(ctx)))
+}
+
+func GetCacheStats(ctx *fasthttp.RequestCtx) {
+	WriteResponse(ctx, http.StatusOK, cacheStats())
+}
+
+func cacheStats() map[string]cache.Stats {
+	stats := database.CacheStats()
+	stats["render"] = render.CacheStats()
+	return stats
 }
@@ -3,51 +3,47 @@ package api
 import (
 	"db-forum/database"
 	"db-forum/models"
-	"log"
+	"db-forum/render"
 	"net/http"
 	"strconv"
 
 	"github.com/asaskevich/govalidator"
+	"github.com/mailru/easyjson"
 	"github.com/valyala/fasthttp"
 	"golang.org/x/tools/container/intsets"
 )
 
 func CreateThread(ctx *fasthttp.RequestCtx, forumName string) {
 	var thread models.Thread
-	if err := thread.UnmarshalJSON(ctx.PostBody()); err != nil {
-		log.Println(err.Error())
-		WriteResponse(ctx, http.StatusInternalServerError, models.Error{err.Error()})
+	if !decodeBody(ctx, &thread) {
 		return
 	}
 	thread.Forum = forumName
-	user, err := database.GetUserByUsername(thread.Author)
+	user, err := database.GetUserByUsername(requestContext(ctx), thread.Author)
 	if err != nil {
 		if err == database.ErrNotFound {
-			WriteResponse(ctx, http.StatusNotFound, models.Error{"Can't find user"})
+			writeProblem(ctx, ErrUserNotFound)
 			return
 		}
-		log.Println(err.Error())
-		WriteResponse(ctx, http.StatusInternalServerError, models.Error{err.Error()})
+		writeError(ctx, err)
 		return
 	}
 	thread.Author = user.Nickname
-	forum, err := database.GetForum(thread.Forum)
+	forum, err := database.GetForum(requestContext(ctx), thread.Forum)
 	if err != nil {
 		if err == database.ErrNotFound {
-			WriteResponse(ctx, http.StatusNotFound, models.Error{"Can't find forum"})
+			writeProblem(ctx, ErrForumNotFound)
 			return
 		}
-		log.Println(err.Error())
-		WriteResponse(ctx, http.StatusInternalServerError, models.Error{err.Error()})
+		writeError(ctx, err)
 		return
 	}
 	thread.Forum = forum.Slug
 	if thread.Slug != "" {
-		existsThread, err := database.GetThreadBySlug(thread.Slug)
+		existsThread, err := database.GetThreadBySlug(requestContext(ctx), thread.Slug)
 		if err != nil {
 			if err != database.ErrNotFound {
-				log.Println(err.Error())
-				WriteResponse(ctx, http.StatusInternalServerError, models.Error{err.Error()})
+				writeError(ctx, err)
 				return
 			}
 		}
@@ -56,14 +52,13 @@ func CreateThread(ctx *fasthttp.RequestCtx, forumName string) {
 			return
 		}
 	}
-	newThread, err := database.CreateThread(&thread)
+	newThread, err := database.CreateThread(requestContext(ctx), &thread)
 	if err != nil {
 		if err == database.ErrDuplicate {
 			WriteResponse(ctx, http.StatusConflict, newThread)
 			return
 		}
-		log.Println(err.Error())
-		WriteResponse(ctx, http.StatusInternalServerError, models.Error{err.Error()})
+		writeError(ctx, err)
 		return
 	}
 	WriteResponse(ctx, http.StatusCreated, newThread)
@@ -71,23 +66,20 @@ func CreateThread(ctx *fasthttp.RequestCtx, forumName string) {
 
 func GetThread(ctx *fasthttp.RequestCtx) {
 	slug := ctx.UserValue("slug").(string)
-	var thread *models.Thread
-	var err error
-	if govalidator.IsNumeric(slug) {
-		thread, err = database.GetThread(slug, slug)
-	} else {
-		thread, err = database.GetThreadBySlug(slug)
-	}
-
+	thread, err := database.GetThreadRow(requestContext(ctx), slug)
 	if err != nil {
 		if err == database.ErrNotFound {
-			WriteResponse(ctx, http.StatusNotFound, models.Error{"Can't find forum by slug: " + slug})
+			writeProblem(ctx, ErrThreadNotFound.WithMessage("Can't find thread by slug: "+slug))
 			return
 		}
-		WriteResponse(ctx, http.StatusInternalServerError, models.Error{err.Error()})
+		writeError(ctx, err)
 		return
 	}
-	WriteResponse(ctx, http.StatusOK, thread)
+	if wantHTML(ctx) {
+		thread.HTML = render.HTML(thread.Message)
+	}
+	setVersionETag(ctx, thread.Version)
+	WriteResponse(ctx, http.StatusOK, &thread.Thread)
 }
 
 func GetForumThreads(ctx *fasthttp.RequestCtx) {
@@ -103,7 +95,8 @@ func GetForumThreads(ctx *fasthttp.RequestCtx) {
 	} else {
 		queryLimit, err = strconv.Atoi(string(limit))
 		if err != nil {
-			WriteResponse(ctx, http.StatusBadRequest, models.Error{err.Error()})
+			writeProblem(ctx, ErrInvalidParameter.WithField("limit").WithMessage("limit must be an integer"))
+			return
 		}
 	}
 
@@ -113,98 +106,179 @@ func GetForumThreads(ctx *fasthttp.RequestCtx) {
 		queryDesc = "ASC"
 	}
 
-	_, err = database.GetForum(slug)
+	_, err = database.GetForum(requestContext(ctx), slug)
 	if err != nil {
 		if err == database.ErrNotFound {
-			WriteResponse(ctx, http.StatusNotFound, models.Error{"Can't find forum by slug: " + slug})
+			writeProblem(ctx, ErrForumNotFound.WithMessage("Can't find forum by slug: "+slug))
 			return
 		}
-	}
-
-	threads, err := database.GetForumThreads(slug, querySince, queryDesc, queryLimit)
-	if err != nil {
-		log.Println(err.Error())
-		WriteResponse(ctx, http.StatusInternalServerError, models.Error{err.Error()})
+		writeError(ctx, err)
 		return
 	}
 
-	WriteResponse(ctx, http.StatusOK, (*threads))
+	html := wantHTML(ctx)
+	writeList(ctx, func(emit func(easyjson.Marshaler) error) error {
+		return database.EachForumThread(requestContext(ctx), slug, querySince, queryDesc, queryLimit, func(thread *models.Thread) error {
+			if html {
+				thread.HTML = render.HTML(thread.Message)
+			}
+			return emit(thread)
+		})
+	})
 }
 
 func UpdateThread(ctx *fasthttp.RequestCtx) {
 	slug := ctx.UserValue("slug").(string)
-	body := ctx.PostBody()
-	var thread *models.Thread
-	var postThread models.Thread
-	if err := postThread.UnmarshalJSON(body); err != nil {
-		WriteResponse(ctx, http.StatusBadRequest, models.Error{err.Error()})
+	var postThread models.ThreadUpdate
+	if !decodeBody(ctx, &postThread) {
 		return
 	}
-	var err error
-	if govalidator.IsNumeric(slug) {
-		thread, err = database.GetThread(slug, slug)
-	} else {
-		thread, err = database.GetThreadBySlug(slug)
-	}
+	thread, err := database.GetThreadRow(requestContext(ctx), slug)
 	if err != nil {
 		if err == database.ErrNotFound {
-			WriteResponse(ctx, http.StatusNotFound, models.Error{err.Error()})
+			writeProblem(ctx, ErrThreadNotFound.WithMessage("Can't find thread by slug: "+slug))
 			return
 		}
-		WriteResponse(ctx, http.StatusInternalServerError, models.Error{err.Error()})
+		writeError(ctx, err)
 		return
 	}
 	thread.Title, thread.Message = postThread.Title, postThread.Message
-	resThread, err := database.UpdateThread(thread)
+	thread.Version = ifMatchVersion(ctx)
+	resThread, err := database.UpdateThread(requestContext(ctx), thread, postThread.SlowMode)
 	if err != nil {
-		WriteResponse(ctx, http.StatusInternalServerError, models.Error{err.Error()})
+		if err == database.ErrPreconditionFailed {
+			writeProblem(ctx, ErrPreconditionFailed.WithMessage("thread was modified, ETag doesn't match"))
+			return
+		}
+		if err == database.ErrNotFound {
+			writeProblem(ctx, ErrThreadNotFound.WithMessage("Can't find thread by slug: "+slug))
+			return
+		}
+		writeError(ctx, err)
 		return
 	}
-	WriteResponse(ctx, http.StatusOK, resThread)
+	setVersionETag(ctx, resThread.Version)
+	WriteResponse(ctx, http.StatusOK, &resThread.Thread)
 }
 
 func VoteThread(ctx *fasthttp.RequestCtx) {
 	slug := ctx.UserValue("slug").(string)
-	body := ctx.PostBody()
 	var voice models.Vote
-	if err := voice.UnmarshalJSON(body); err != nil {
-		WriteResponse(ctx, http.StatusBadRequest, models.Error{err.Error()})
+	if !decodeBody(ctx, &voice) {
 		return
 	}
-	user, err := database.GetUserByUsername(voice.Nickname)
+	user, err := database.GetUserByUsername(requestContext(ctx), voice.Nickname)
 	if err != nil {
 		if err == database.ErrNotFound {
-			WriteResponse(ctx, http.StatusNotFound, models.Error{"Can't find user"})
+			writeProblem(ctx, ErrUserNotFound)
 			return
 		}
-		log.Println(err.Error())
-		WriteResponse(ctx, http.StatusInternalServerError, models.Error{err.Error()})
+		writeError(ctx, err)
 		return
 	}
 	voice.Nickname = user.Nickname
 	var thread *models.Thread
 	if govalidator.IsNumeric(slug) {
-		thread, err = database.GetThread(slug, slug)
+		thread, err = database.GetThread(requestContext(ctx), slug, slug)
 	} else {
-		thread, err = database.GetThreadBySlug(slug)
+		thread, err = database.GetThreadBySlug(requestContext(ctx), slug)
 	}
 	if err != nil {
 		if err == database.ErrNotFound {
-			WriteResponse(ctx, http.StatusNotFound, models.Error{"Can't find thread"})
+			writeProblem(ctx, ErrThreadNotFound)
 			return
 		}
-		log.Println(err.Error())
-		WriteResponse(ctx, http.StatusInternalServerError, models.Error{err.Error()})
+		writeError(ctx, err)
 		return
 	}
 	slug = thread.Slug
 	voice.ThreadId = thread.ID
-	newVote, err := database.VoteThread(&voice)
+	newVote, err := database.VoteThread(requestContext(ctx), &voice)
 	if err != nil {
-		log.Println(err.Error())
-		WriteResponse(ctx, http.StatusInternalServerError, models.Error{err.Error()})
+		writeError(ctx, err)
 		return
 	}
 	thread.Votes = newVote
 	WriteResponse(ctx, http.StatusOK, thread)
 }
+
+func SplitThread(ctx *fasthttp.RequestCtx) {
+	slug := ctx.UserValue("slug").(string)
+	var split models.ThreadSplit
+	if err := split.UnmarshalJSON(ctx.PostBody()); err != nil {
+		writeProblem(ctx, ErrInvalidJSON)
+		return
+	}
+	if split.Post == 0 || split.Title == "" {
+		writeProblem(ctx, ErrInvalidRequest.WithMessage("post and title are required"))
+		return
+	}
+	thread, err := database.GetThreadBySlugOrID(requestContext(ctx), slug)
+	if err != nil {
+		if err == database.ErrNotFound {
+			writeProblem(ctx, ErrThreadNotFound.WithMessage("Can't find thread by slug: "+slug))
+			return
+		}
+		writeError(ctx, err)
+		return
+	}
+	if split.Author != "" {
+		user, err := database.GetUserByUsername(requestContext(ctx), split.Author)
+		if err != nil {
+			if err == database.ErrNotFound {
+				writeProblem(ctx, ErrUserNotFound)
+				return
+			}
+			writeError(ctx, err)
+			return
+		}
+		split.Author = user.Nickname
+	}
+	newThread, err := database.SplitThread(requestContext(ctx), thread, &split)
+	if err != nil {
+		switch err {
+		case database.ErrNotFound:
+			writeProblem(ctx, ErrPostNotFound)
+		case database.ErrDuplicate:
+			WriteResponse(ctx, http.StatusConflict, newThread)
+		case database.ErrConflict:
+			writeProblem(ctx, ErrPostNotInThread.WithMessage("post doesn't belong to thread "+slug))
+		default:
+			writeError(ctx, err)
+		}
+		return
+	}
+	WriteResponse(ctx, http.StatusCreated, newThread)
+}
+
+func MergeThread(ctx *fasthttp.RequestCtx) {
+	slug := ctx.UserValue("slug").(string)
+	var merge models.ThreadMerge
+	if err := merge.UnmarshalJSON(ctx.PostBody()); err != nil {
+		writeProblem(ctx, ErrInvalidJSON)
+		return
+	}
+	threads := make([]*models.Thread, 0, 2)
+	for _, s := range []string{slug, merge.Thread} {
+		thread, err := database.GetThreadBySlugOrID(requestContext(ctx), s)
+		if err != nil {
+			if err == database.ErrNotFound {
+				writeProblem(ctx, ErrThreadNotFound.WithMessage("Can't find thread by slug: "+s))
+				return
+			}
+			writeError(ctx, err)
+			return
+		}
+		threads = append(threads, thread)
+	}
+	thread, err := database.MergeThreads(requestContext(ctx), threads[0], threads[1])
+	if err != nil {
+		if err == database.ErrConflict {
+			writeProblem(ctx, ErrMergeIntoItself)
+			return
+		}
+		writeError(ctx, err)
+		return
+	}
+	WriteResponse(ctx, http.StatusOK, thread)
+}
@@ -0,0 +1,30 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestSplitAndMergeRejectBadInput(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler fasthttp.RequestHandler
+		body    string
+		code    string
+	}{
+		{"split bad JSON", SplitThread, `{"post":`, ErrInvalidJSON.Code},
+		{"split without post", SplitThread, `{"title": "t"}`, ErrInvalidRequest.Code},
+		{"split without title", SplitThread, `{"post": 3}`, ErrInvalidRequest.Code},
+		{"merge bad JSON", MergeThread, `[]`, ErrInvalidJSON.Code},
+	}
+	for _, tt := range tests {
+		ctx := newRequest("POST", "/api/thread/1/x", tt.body, map[string]string{"slug": "1"})
+		tt.handler(ctx)
+		if ctx.Response.StatusCode() != http.StatusBadRequest || !strings.Contains(string(ctx.Response.Body()), tt.code) {
+			t.Errorf("%s: %d %s, want 400 %s", tt.name, ctx.Response.StatusCode(), ctx.Response.Body(), tt.code)
+		}
+	}
+}
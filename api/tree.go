@@ -0,0 +1,31 @@
+package api
+
+import (
+	"db-forum/models"
+	"db-forum/render"
+)
+
+// nestPosts links nodes, ordered parents first, into trees and returns
+// their roots in order. A node whose parent is missing becomes a root.
+func nestPosts(nodes []models.PostTree) []*models.PostTree {
+	byID := make(map[int64]*models.PostTree, len(nodes))
+	for i := range nodes {
+		nodes[i].Children = make([]*models.PostTree, 0)
+		byID[nodes[i].ID] = &nodes[i]
+	}
+	roots := make([]*models.PostTree, 0)
+	for i := range nodes {
+		if parent, ok := byID[nodes[i].Parent]; ok && nodes[i].Parent != 0 {
+			parent.Children = append(parent.Children, &nodes[i])
+		} else {
+			roots = append(roots, &nodes[i])
+		}
+	}
+	return roots
+}
+
+func renderTree(nodes []models.PostTree) {
+	for i := range nodes {
+		nodes[i].HTML = render.HTML(nodes[i].Message)
+	}
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+
+	"db-forum/models"
+)
+
+func treeNode(id int64, parent int64) models.PostTree {
+	return models.PostTree{Post: models.Post{ID: id, Parent: parent}}
+}
+
+// shape describes trees as ids with the shapes of their children.
+func shape(trees []*models.PostTree) []interface{} {
+	res := make([]interface{}, 0, len(trees))
+	for _, tree := range trees {
+		res = append(res, tree.ID)
+		if len(tree.Children) != 0 {
+			res = append(res, shape(tree.Children))
+		}
+	}
+	return res
+}
+
+func TestNestPosts(t *testing.T) {
+	tests := []struct {
+		name  string
+		nodes []models.PostTree
+		want  []interface{}
+	}{
+		{"empty", nil, []interface{}{}},
+		{"roots", []models.PostTree{treeNode(1, 0), treeNode(2, 0)}, []interface{}{int64(1), int64(2)}},
+		{
+			"nested in path order",
+			[]models.PostTree{treeNode(1, 0), treeNode(3, 1), treeNode(5, 3), treeNode(4, 1), treeNode(2, 0), treeNode(6, 2)},
+			[]interface{}{int64(1), []interface{}{int64(3), []interface{}{int64(5)}, int64(4)}, int64(2), []interface{}{int64(6)}},
+		},
+		{
+			"roots in descending order",
+			[]models.PostTree{treeNode(2, 0), treeNode(6, 2), treeNode(1, 0), treeNode(3, 1)},
+			[]interface{}{int64(2), []interface{}{int64(6)}, int64(1), []interface{}{int64(3)}},
+		},
+		{"missing parent", []models.PostTree{treeNode(3, 1), treeNode(4, 3)}, []interface{}{int64(3), []interface{}{int64(4)}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shape(nestPosts(tt.nodes)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("nestPosts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNestPostsKeepsCollapsedAndEmptyChildren(t *testing.T) {
+	nodes := []models.PostTree{treeNode(1, 0), treeNode(2, 1)}
+	nodes[1].Collapsed = 7
+	roots := nestPosts(nodes)
+	leaf := roots[0].Children[0]
+	if leaf.Collapsed != 7 {
+		t.Errorf("collapsed = %d, want 7", leaf.Collapsed)
+	}
+	if leaf.Children == nil {
+		t.Error("leaf children are nil, want an empty array in JSON")
+	}
+}
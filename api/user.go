@@ -3,7 +3,6 @@ package api
 import (
 	"db-forum/database"
 	"db-forum/models"
-	"log"
 	"net/http"
 
 	"github.com/valyala/fasthttp"
@@ -11,18 +10,17 @@ import (
 
 func CreateUser(ctx *fasthttp.RequestCtx) {
 	var user models.User
-	if err := user.UnmarshalJSON(ctx.PostBody()); err != nil {
-		WriteResponse(ctx, http.StatusBadRequest, models.Error{err.Error()})
+	if !decodeBody(ctx, &user) {
 		return
 	}
 	user.Nickname = ctx.UserValue("nickname").(string)
-	usr, err := database.CreateUser(&user)
+	usr, err := database.CreateUser(requestContext(ctx), &user)
 	if err != nil {
 		if err == database.ErrDuplicate {
 			WriteResponse(ctx, http.StatusConflict, usr)
 			return
 		}
-		WriteResponse(ctx, http.StatusInternalServerError, models.Error{err.Error()})
+		writeError(ctx, err)
 		return
 	}
 	WriteResponse(ctx, http.StatusCreated, (*usr)[0])
@@ -30,48 +28,54 @@ func CreateUser(ctx *fasthttp.RequestCtx) {
 
 func GetUser(ctx *fasthttp.RequestCtx) {
 	nickname := ctx.UserValue("nickname").(string)
-	usr, err := database.GetUserByUsername(nickname)
+	usr, err := database.GetUserRow(requestContext(ctx), nickname)
 	if err != nil {
 		if err == database.ErrNotFound {
-			WriteResponse(ctx, http.StatusNotFound, models.Error{"Can't find user\n"})
+			writeProblem(ctx, ErrUserNotFound)
 			return
 		}
-		log.Println(err.Error())
-		WriteResponse(ctx, http.StatusInternalServerError, models.Error{err.Error()})
+		writeError(ctx, err)
 		return
 	}
-	WriteResponse(ctx, http.StatusOK, usr)
+	setVersionETag(ctx, usr.Version)
+	WriteResponse(ctx, http.StatusOK, usr.User)
 }
 
 func UpdateUser(ctx *fasthttp.RequestCtx) {
-	var user models.User
-	if err := user.UnmarshalJSON(ctx.PostBody()); err != nil {
-		log.Println(err.Error())
-		WriteResponse(ctx, http.StatusBadRequest, models.Error{err.Error()})
+	var update models.UserUpdate
+	if !decodeBody(ctx, &update) {
 		return
 	}
+	var user database.UserRow
+	user.About, user.Email, user.Fullname = update.About, string(update.Email), update.Fullname
 	user.Nickname = ctx.UserValue("nickname").(string)
-	_, err := database.GetUserByUsername(user.Nickname)
+	_, err := database.GetUserByUsername(requestContext(ctx), user.Nickname)
 	if err != nil {
 		if err == database.ErrNotFound {
-			WriteResponse(ctx, http.StatusNotFound, models.Error{"Can't find user by nickname: " + user.Nickname})
+			writeProblem(ctx, ErrUserNotFound.WithMessage("Can't find user by nickname: "+user.Nickname))
 			return
 		}
-		WriteResponse(ctx, http.StatusInternalServerError, models.Error{err.Error()})
+		writeError(ctx, err)
 		return
 	}
-	usr, err := database.UpdateUser(&user)
+	user.Version = ifMatchVersion(ctx)
+	usr, err := database.UpdateUser(requestContext(ctx), &user)
 	if err != nil {
+		if err == database.ErrPreconditionFailed {
+			writeProblem(ctx, ErrPreconditionFailed.WithMessage("user was modified, ETag doesn't match"))
+			return
+		}
 		if err == database.ErrDuplicate {
-			WriteResponse(ctx, http.StatusConflict, models.Error{"This email is already registered by user: " + user.Nickname})
+			writeProblem(ctx, ErrEmailTaken.WithMessage("This email is already registered by user: "+user.Nickname))
 			return
 		}
 		if err == database.ErrNotFound {
-			WriteResponse(ctx, http.StatusNotFound, models.Error{"Can't find user by nickname: " + user.Nickname})
+			writeProblem(ctx, ErrUserNotFound.WithMessage("Can't find user by nickname: "+user.Nickname))
 			return
 		}
-		WriteResponse(ctx, http.StatusInternalServerError, models.Error{err.Error()})
+		writeError(ctx, err)
 		return
 	}
-	WriteResponse(ctx, http.StatusOK, (*usr)[0])
+	setVersionETag(ctx, usr.Version)
+	WriteResponse(ctx, http.StatusOK, usr.User)
 }
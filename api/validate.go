@@ -0,0 +1,279 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+
+	"db-forum/models"
+
+	oaerrors "github.com/go-openapi/errors"
+	"github.com/go-openapi/loads"
+	"github.com/go-openapi/spec"
+	"github.com/go-openapi/strfmt"
+	"github.com/go-openapi/validate"
+	"github.com/pkg/errors"
+	"github.com/valyala/fasthttp"
+)
+
+// specOperation is an operation of the swagger spec requests are checked
+// against.
+type specOperation struct {
+	segments []string
+	params   []spec.Parameter
+}
+
+var (
+	specBasePath   string
+	specOperations map[string][]specOperation
+)
+
+// LoadSpec reads the swagger spec Validate checks requests against.
+func LoadSpec(doc json.RawMessage) error {
+	d, err := loads.Analyzed(doc, "")
+	if err != nil {
+		return errors.Wrap(err, "can't load swagger spec")
+	}
+	if d, err = d.Expanded(); err != nil {
+		return errors.Wrap(err, "can't expand swagger spec")
+	}
+	operations := make(map[string][]specOperation)
+	for path, item := range d.Spec().Paths.Paths {
+		for method, op := range map[string]*spec.Operation{"GET": item.Get, "POST": item.Post} {
+			if op == nil {
+				continue
+			}
+			var params []spec.Parameter
+			for _, param := range d.Analyzer.ParamsFor(method, path) {
+				params = append(params, param)
+			}
+			sort.Slice(params, func(i, j int) bool { return params[i].Name < params[j].Name })
+			operations[method] = append(operations[method], specOperation{splitPath(path), params})
+		}
+	}
+	specBasePath, specOperations = d.BasePath(), operations
+	return nil
+}
+
+func splitPath(path string) []string {
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
+
+// Validate checks the path and query parameters and the body of requests
+// to the route method pattern against the spec loaded by LoadSpec and
+// answers 400 listing the invalid ones. The spec operations of the route
+// are resolved once here; routes missing in the spec get handler unchecked.
+func Validate(method string, pattern string, handler fasthttp.RequestHandler) fasthttp.RequestHandler {
+	ops := routeOperations(method, pattern)
+	if len(ops) == 0 {
+		return handler
+	}
+	return func(ctx *fasthttp.RequestCtx) {
+		segments := splitPath(strings.TrimPrefix(string(ctx.Path()), specBasePath))
+		for _, op := range ops {
+			values, ok := matchPath(op.segments, segments)
+			if !ok {
+				continue
+			}
+			fields, err := validateRequest(ctx, op, values)
+			if err != nil {
+				writeProblem(ctx, ErrInvalidJSON)
+				return
+			}
+			if len(fields) != 0 {
+				writeInvalid(ctx, fields)
+				return
+			}
+			break
+		}
+		handler(ctx)
+	}
+}
+
+// routeOperations returns the spec operations a router pattern may serve:
+// one for most routes, a few for catch-all ones such as /api/forum/*options.
+func routeOperations(method string, pattern string) []specOperation {
+	if !strings.HasPrefix(pattern, specBasePath) {
+		return nil
+	}
+	route := splitPath(pattern[len(specBasePath):])
+	var ops []specOperation
+	for _, op := range specOperations[method] {
+		if routeServes(route, op.segments) {
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
+
+// routeServes reports whether the router pattern route may match requests
+// to the spec path op.
+func routeServes(route []string, op []string) bool {
+	for i, r := range route {
+		if strings.HasPrefix(r, "*") {
+			return true
+		}
+		if i >= len(op) {
+			return false
+		}
+		if !strings.HasPrefix(r, ":") && !strings.HasPrefix(op[i], "{") && r != op[i] {
+			return false
+		}
+	}
+	return len(route) == len(op)
+}
+
+func matchPath(pattern []string, segments []string) (map[string]string, bool) {
+	if len(pattern) != len(segments) {
+		return nil, false
+	}
+	values := make(map[string]string)
+	for i, p := range pattern {
+		if strings.HasPrefix(p, "{") && strings.HasSuffix(p, "}") {
+			values[p[1:len(p)-1]] = segments[i]
+		} else if p != segments[i] {
+			return nil, false
+		}
+	}
+	return values, true
+}
+
+// validateRequest lists the parameters of the request breaking op. It
+// fails if the body is not valid JSON.
+func validateRequest(ctx *fasthttp.RequestCtx, op specOperation, values map[string]string) ([]*models.FieldError, error) {
+	var errs []error
+	for i := range op.params {
+		param := &op.params[i]
+		var raw string
+		switch param.In {
+		case "path":
+			raw = values[param.Name]
+		case "query":
+			raw = string(ctx.QueryArgs().Peek(param.Name))
+		case "body":
+			if param.Schema == nil {
+				continue
+			}
+			if len(bytes.TrimSpace(ctx.PostBody())) == 0 {
+				if param.Required {
+					errs = append(errs, oaerrors.Required(param.Name, param.In))
+				}
+				continue
+			}
+			var body interface{}
+			decoder := json.NewDecoder(bytes.NewReader(ctx.PostBody()))
+			decoder.UseNumber()
+			if err := decoder.Decode(&body); err != nil {
+				return nil, errors.Wrap(err, "can't decode body")
+			}
+			errs = append(errs, validateBody(param.Schema, body)...)
+			continue
+		default:
+			continue
+		}
+		if raw == "" {
+			if param.Required {
+				errs = append(errs, oaerrors.Required(param.Name, param.In))
+			}
+			continue
+		}
+		value, err := paramValue(param, raw)
+		if err != nil {
+			errs = append(errs, oaerrors.InvalidType(param.Name, param.In, param.Type, raw))
+			continue
+		}
+		if res := validate.NewParamValidator(param, strfmt.Default).Validate(value); res != nil {
+			errs = append(errs, res.Errors...)
+		}
+	}
+	return FieldErrors(errs), nil
+}
+
+// validateBody checks a decoded body against schema. The items of array
+// bodies are checked one by one: the vendored validator loses their index
+// in the field names.
+func validateBody(schema *spec.Schema, body interface{}) []error {
+	items, ok := body.([]interface{})
+	if !schema.Type.Contains("array") || schema.Items == nil || schema.Items.Schema == nil || !ok {
+		if err := validate.AgainstSchema(schema, body, strfmt.Default); err != nil {
+			return []error{err}
+		}
+		return nil
+	}
+	var errs []error
+	array := *schema
+	array.Items = nil
+	if err := validate.AgainstSchema(&array, body, strfmt.Default); err != nil {
+		errs = append(errs, err)
+	}
+	for i, item := range items {
+		err := validate.AgainstSchema(schema.Items.Schema, item, strfmt.Default)
+		if err == nil {
+			continue
+		}
+		for _, e := range err.(*oaerrors.CompositeError).Errors {
+			if v, ok := e.(*oaerrors.Validation); ok {
+				v.Name = strconv.Itoa(i) + v.Name
+			}
+			errs = append(errs, e)
+		}
+	}
+	return errs
+}
+
+// paramValue converts a path or query value to the type of param.
+func paramValue(param *spec.Parameter, raw string) (interface{}, error) {
+	switch param.Type {
+	case "integer":
+		return strconv.ParseInt(raw, 10, 64)
+	case "number":
+		if param.Format == "int32" || param.Format == "int64" {
+			return strconv.ParseInt(raw, 10, 64)
+		}
+		return strconv.ParseFloat(raw, 64)
+	case "boolean":
+		return strconv.ParseBool(raw)
+	case "array":
+		return strings.Split(raw, ","), nil
+	}
+	return raw, nil
+}
+
+// decodeBody decodes the request body into m, which Validate already
+// checked against the spec. It answers 400 and returns false if the body
+// is not valid JSON.
+func decodeBody(ctx *fasthttp.RequestCtx, m json.Unmarshaler) bool {
+	if err := m.UnmarshalJSON(ctx.PostBody()); err != nil {
+		writeProblem(ctx, ErrInvalidJSON)
+		return false
+	}
+	return true
+}
+
+func writeInvalid(ctx *fasthttp.RequestCtx, fields []*models.FieldError) {
+	payload := ErrInvalidRequest.Payload(requestContext(ctx))
+	payload.Fields = fields
+	WriteResponse(ctx, ErrInvalidRequest.Status, payload)
+}
+
+// FieldErrors lists the fields go-openapi validation errors complain about.
+func FieldErrors(errs []error) []*models.FieldError {
+	var fields []*models.FieldError
+	for _, err := range errs {
+		switch e := err.(type) {
+		case *oaerrors.Validation:
+			in := e.In
+			if in == "" {
+				in = "body"
+			}
+			fields = append(fields, &models.FieldError{Field: strings.TrimPrefix(e.Name, "."), In: in, Message: e.Error()})
+		case *oaerrors.CompositeError:
+			fields = append(fields, FieldErrors(e.Errors)...)
+		default:
+			fields = append(fields, &models.FieldError{In: "body", Message: e.Error()})
+		}
+	}
+	return fields
+}
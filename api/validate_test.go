@@ -0,0 +1,175 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"db-forum/models"
+
+	"github.com/go-openapi/swag"
+	"github.com/valyala/fasthttp"
+)
+
+func loadTestSpec(t *testing.T) {
+	data, err := ioutil.ReadFile("../swagger.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := swag.BytesToYAMLDoc(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec, err := swag.YAMLToJSON(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadSpec(spec); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// invalidFields returns the fields a 400 response complains about.
+func invalidFields(t *testing.T, ctx *fasthttp.RequestCtx) []string {
+	if got := ctx.Response.StatusCode(); got != http.StatusBadRequest {
+		t.Fatalf("status %d, want 400: %s", got, ctx.Response.Body())
+	}
+	var payload models.Error
+	if err := payload.UnmarshalJSON(ctx.Response.Body()); err != nil {
+		t.Fatal(err)
+	}
+	fields := make([]string, 0, len(payload.Fields))
+	for _, field := range payload.Fields {
+		fields = append(fields, field.In+":"+field.Field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func TestRouteOperations(t *testing.T) {
+	loadTestSpec(t)
+	tests := []struct {
+		method, pattern string
+		want            []string
+	}{
+		{"GET", "/api/thread/:slug/posts", []string{"thread/{slug_or_id}/posts"}},
+		{"POST", "/api/post/:slug/details", []string{"post/{id}/details"}},
+		{"POST", "/api/forum/*options", []string{"forum/create", "forum/{slug}/create"}},
+		{"POST", "/api/thread/:slug/split", nil},
+		{"GET", "/metrics", nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, op := range routeOperations(tt.method, tt.pattern) {
+			got = append(got, strings.Join(op.segments, "/"))
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("routeOperations(%s %s) = %q, want %q", tt.method, tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestValidateParams(t *testing.T) {
+	loadTestSpec(t)
+	served := 0
+	handler := Validate("GET", "/api/thread/:slug/posts", func(ctx *fasthttp.RequestCtx) { served++ })
+	for _, uri := range []string{
+		"/api/thread/42/posts",
+		"/api/thread/jones/posts?limit=10&since=5&sort=parent_tree&desc=true",
+	} {
+		ctx := newRequest("GET", uri, "", nil)
+		handler(ctx)
+		if served != 1 || ctx.Response.StatusCode() != http.StatusOK {
+			t.Errorf("%s: served %d times, status %d: %s", uri, served, ctx.Response.StatusCode(), ctx.Response.Body())
+		}
+		served = 0
+	}
+
+	tests := []struct {
+		uri  string
+		want []string
+	}{
+		{"/api/thread/42/posts?limit=0", []string{"query:limit"}},
+		{"/api/thread/42/posts?limit=many", []string{"query:limit"}},
+		{"/api/thread/42/posts?sort=random&desc=maybe", []string{"query:desc", "query:sort"}},
+	}
+	for _, tt := range tests {
+		ctx := newRequest("GET", tt.uri, "", nil)
+		handler(ctx)
+		if served != 0 {
+			t.Errorf("%s reached the handler", tt.uri)
+		}
+		if got := invalidFields(t, ctx); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: invalid fields %q, want %q", tt.uri, got, tt.want)
+		}
+	}
+
+	ctx := newRequest("GET", "/metrics?limit=0", "", nil)
+	Validate("GET", "/metrics", func(ctx *fasthttp.RequestCtx) { served++ })(ctx)
+	if served != 1 {
+		t.Error("a route missing in the spec was checked")
+	}
+}
+
+func TestValidateBody(t *testing.T) {
+	loadTestSpec(t)
+	served := 0
+	handler := Validate("POST", "/api/forum/*options", func(ctx *fasthttp.RequestCtx) { served++ })
+	tests := []struct {
+		body string
+		want []string
+	}{
+		{``, []string{"body:forum"}},
+		{`{"slug": "pirate-stories", "title": "Pirate stories"}`, []string{"body:user"}},
+		{`{"slug": "not a slug", "title": "", "user": "j.sparrow"}`, []string{"body:slug", "body:title"}},
+	}
+	for _, tt := range tests {
+		ctx := newRequest("POST", "/api/forum/create", tt.body, nil)
+		handler(ctx)
+		if served != 0 {
+			t.Errorf("%s reached the handler", tt.body)
+		}
+		if got := invalidFields(t, ctx); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: invalid fields %q, want %q", tt.body, got, tt.want)
+		}
+	}
+
+	ctx := newRequest("POST", "/api/forum/create", `{"slug": `, nil)
+	handler(ctx)
+	if served != 0 || ctx.Response.StatusCode() != http.StatusBadRequest {
+		t.Errorf("broken JSON: served %d times, status %d", served, ctx.Response.StatusCode())
+	}
+
+	ctx = newRequest("POST", "/api/forum/create", `{"slug": "pirate-stories", "title": "Pirate stories", "user": "j.sparrow"}`, nil)
+	handler(ctx)
+	if served != 1 {
+		t.Errorf("valid body: served %d times, response %s", served, ctx.Response.Body())
+	}
+}
+
+func TestValidateEachPost(t *testing.T) {
+	loadTestSpec(t)
+	body := `[{"author": "j.sparrow", "message": "first"}, {"author": "j.sparrow"}]`
+	ctx := newRequest("POST", "/api/thread/42/create", body, map[string]string{"slug": "42"})
+	Validate("POST", "/api/thread/:slug/create", CreatePost)(ctx)
+	if got, want := invalidFields(t, ctx), []string{"body:1.message"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid fields %q, want %q", got, want)
+	}
+}
+
+func TestDecodeBody(t *testing.T) {
+	ctx := newRequest("POST", "/api/forum/create", `{"slug": `, nil)
+	var forum models.Forum
+	if decodeBody(ctx, &forum) || ctx.Response.StatusCode() != http.StatusBadRequest {
+		t.Errorf("broken JSON: status %d", ctx.Response.StatusCode())
+	}
+
+	ctx = newRequest("POST", "/api/forum/create", `{"slug": "pirate-stories", "user": "j.sparrow"}`, nil)
+	if !decodeBody(ctx, &forum) || forum.User != "j.sparrow" {
+		t.Errorf("decoded %+v, response %s", forum, ctx.Response.Body())
+	}
+}
@@ -0,0 +1,275 @@
+// Package archive moves a forum between instances as NDJSON: a header line
+// followed by users, the forum, threads, posts grouped by thread and votes,
+// one record per line, and a trailer with the number of those records. An
+// archive cut short has no trailer and is rejected.
+package archive
+
+import (
+	"bufio"
+	"context"
+	"db-forum/database"
+	"db-forum/models"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// Version is the archive format written by Export and read by Import.
+const Version = 1
+
+const (
+	TypeHeader = "header"
+	TypeUser   = "user"
+	TypeForum  = "forum"
+	TypeThread = "thread"
+	TypePost   = "post"
+	TypeVote   = "vote"
+
+	TypeTrailer = "trailer"
+)
+
+// ErrFormat is the cause of errors about malformed archives.
+var ErrFormat = errors.New("bad archive format")
+
+type Record struct {
+	Type    string         `json:"type"`
+	Version int            `json:"version,omitempty"`
+	Source  string         `json:"source,omitempty"`
+	User    *models.User   `json:"user,omitempty"`
+	Forum   *models.Forum  `json:"forum,omitempty"`
+	Thread  *models.Thread `json:"thread,omitempty"`
+	Post    *models.Post   `json:"post,omitempty"`
+	Vote    *models.Vote   `json:"vote,omitempty"`
+	Records int64          `json:"records,omitempty"`
+}
+
+func (r *Record) valid() bool {
+	switch r.Type {
+	case TypeUser:
+		return r.User != nil
+	case TypeForum:
+		return r.Forum != nil
+	case TypeThread:
+		return r.Thread != nil
+	case TypePost:
+		return r.Post != nil
+	case TypeVote:
+		return r.Vote != nil
+	}
+	return false
+}
+
+// importer adds archived records in one transaction; it is a
+// *database.ForumImport but in tests.
+type importer interface {
+	AddUser(user *models.User) (bool, error)
+	AddForum(forum *models.Forum) error
+	AddThread(thread *models.Thread) error
+	AddPosts(thread int32, next func() (*models.Post, error)) (int64, error)
+	AddVote(vote *models.Vote) error
+	Commit() error
+	Rollback() error
+}
+
+var (
+	exportForum = database.ExportForum
+	beginImport = func(ctx context.Context) (importer, error) {
+		im, err := database.BeginForumImport(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return im, nil
+	}
+)
+
+func formatError(format string, args ...interface{}) error {
+	return errors.Wrap(ErrFormat, fmt.Sprintf(format, args...))
+}
+
+// Export writes the archive of the forum to w.
+func Export(ctx context.Context, w io.Writer, slug string) error {
+	buf := bufio.NewWriter(w)
+	enc := json.NewEncoder(buf)
+	if err := enc.Encode(&Record{Type: TypeHeader, Version: Version, Source: slug}); err != nil {
+		return errors.Wrap(err, "can't write archive")
+	}
+	var records int64
+	err := exportForum(ctx, slug, func(value interface{}) error {
+		var record Record
+		switch v := value.(type) {
+		case *models.User:
+			record = Record{Type: TypeUser, User: v}
+		case *models.Forum:
+			record = Record{Type: TypeForum, Forum: v}
+		case *models.Thread:
+			record = Record{Type: TypeThread, Thread: v}
+		case *models.Post:
+			record = Record{Type: TypePost, Post: v}
+		case *models.Vote:
+			record = Record{Type: TypeVote, Vote: v}
+		default:
+			return errors.Errorf("can't export %T", value)
+		}
+		if err := enc.Encode(&record); err != nil {
+			return errors.Wrap(err, "can't write archive")
+		}
+		records++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := enc.Encode(&Record{Type: TypeTrailer, Records: records}); err != nil {
+		return errors.Wrap(err, "can't write archive")
+	}
+	return errors.Wrap(buf.Flush(), "can't write archive")
+}
+
+// Import loads the archive from r in one transaction. A non-empty slug
+// renames the imported forum.
+func Import(ctx context.Context, r io.Reader, slug string) (*models.ArchiveSummary, error) {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	var header Record
+	if err := dec.Decode(&header); err != nil {
+		return nil, formatError("can't read header: %v", err)
+	}
+	if header.Type != TypeHeader {
+		return nil, formatError("archive must start with a header")
+	}
+	if header.Version != Version {
+		return nil, formatError("unsupported archive version %d", header.Version)
+	}
+	im, err := beginImport(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer im.Rollback()
+
+	var pending *Record
+	var readErr error
+	var records int64
+	next := func() (*Record, error) {
+		if pending != nil {
+			record := pending
+			pending = nil
+			return record, nil
+		}
+		if readErr != nil {
+			return nil, readErr
+		}
+		var record Record
+		if err := dec.Decode(&record); err != nil {
+			if err == io.EOF {
+				readErr = formatError("archive is truncated: no trailer after %d records", records)
+			} else {
+				readErr = formatError("can't read record: %v", err)
+			}
+			return nil, readErr
+		}
+		if record.Type == TypeTrailer {
+			readErr = trailerError(dec, &record, records)
+			if readErr == nil {
+				readErr = io.EOF
+			}
+			return nil, readErr
+		}
+		records++
+		if !record.valid() {
+			readErr = formatError("bad %q record", record.Type)
+			return nil, readErr
+		}
+		return &record, nil
+	}
+
+	var summary models.ArchiveSummary
+	done := make(map[int32]bool)
+	for {
+		record, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch record.Type {
+		case TypeUser:
+			created, err := im.AddUser(record.User)
+			if err != nil {
+				return nil, errors.Wrap(err, "user "+record.User.Nickname)
+			}
+			if created {
+				summary.Users++
+			}
+		case TypeForum:
+			if slug != "" {
+				record.Forum.Slug = slug
+			}
+			if err := im.AddForum(record.Forum); err != nil {
+				return nil, errors.Wrap(err, "forum "+record.Forum.Slug)
+			}
+			summary.Forum = record.Forum.Slug
+		case TypeThread:
+			if err := im.AddThread(record.Thread); err != nil {
+				return nil, errors.Wrapf(err, "thread %d", record.Thread.ID)
+			}
+			summary.Threads++
+		case TypePost:
+			thread := record.Post.Thread
+			if done[thread] {
+				return nil, formatError("posts of thread %d are not grouped", thread)
+			}
+			done[thread] = true
+			first := record.Post
+			imported, err := im.AddPosts(thread, func() (*models.Post, error) {
+				if first != nil {
+					post := first
+					first = nil
+					return post, nil
+				}
+				record, err := next()
+				if err != nil {
+					return nil, err
+				}
+				if record.Type != TypePost || record.Post.Thread != thread {
+					pending = record
+					return nil, io.EOF
+				}
+				return record.Post, nil
+			})
+			if readErr != nil && readErr != io.EOF {
+				return nil, readErr
+			}
+			if err != nil {
+				return nil, errors.Wrapf(err, "posts of thread %d", thread)
+			}
+			summary.Posts += imported
+		case TypeVote:
+			if err := im.AddVote(record.Vote); err != nil {
+				return nil, errors.Wrapf(err, "vote of %s", record.Vote.Nickname)
+			}
+			summary.Votes++
+		}
+	}
+	if summary.Forum == "" {
+		return nil, formatError("archive has no forum")
+	}
+	if err := im.Commit(); err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
+
+// trailerError checks that trailer counts the records read before it and
+// ends the archive.
+func trailerError(dec *json.Decoder, trailer *Record, records int64) error {
+	if trailer.Records != records {
+		return formatError("trailer counts %d records, archive has %d", trailer.Records, records)
+	}
+	var extra json.RawMessage
+	if err := dec.Decode(&extra); err != io.EOF {
+		return formatError("records after the trailer")
+	}
+	return nil
+}
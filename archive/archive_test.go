@@ -0,0 +1,181 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"db-forum/models"
+
+	"github.com/pkg/errors"
+)
+
+// memory is a forum kept in the order ExportForum emits it.
+type memory struct {
+	values    []interface{}
+	committed bool
+}
+
+func (m *memory) AddUser(user *models.User) (bool, error) {
+	m.values = append(m.values, user)
+	return true, nil
+}
+
+func (m *memory) AddForum(forum *models.Forum) error {
+	m.values = append(m.values, forum)
+	return nil
+}
+
+func (m *memory) AddThread(thread *models.Thread) error {
+	m.values = append(m.values, thread)
+	return nil
+}
+
+func (m *memory) AddPosts(thread int32, next func() (*models.Post, error)) (int64, error) {
+	var n int64
+	for {
+		post, err := next()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		m.values = append(m.values, post)
+		n++
+	}
+}
+
+func (m *memory) AddVote(vote *models.Vote) error {
+	m.values = append(m.values, vote)
+	return nil
+}
+
+func (m *memory) Commit() error {
+	m.committed = true
+	return nil
+}
+
+func (m *memory) Rollback() error {
+	return nil
+}
+
+func sampleForum() *memory {
+	return &memory{values: []interface{}{
+		&models.User{Nickname: "alice", Email: "a@example.com"},
+		&models.Forum{Slug: "pirates", Title: "Pirates", User: "alice"},
+		&models.Thread{ID: 1, Forum: "pirates", Author: "alice", Title: "t1"},
+		&models.Thread{ID: 2, Forum: "pirates", Author: "alice", Title: "t2"},
+		&models.Post{ID: 1, Thread: 1, Author: "alice", Message: "a"},
+		&models.Post{ID: 2, Thread: 1, Parent: 1, Author: "alice", Message: "b"},
+		&models.Post{ID: 3, Thread: 2, Author: "alice", Message: "c"},
+		&models.Vote{Nickname: "alice", Voice: 1, ThreadId: 2},
+	}}
+}
+
+// fake points the package at src for exports and returns the importer
+// imports will write to.
+func fake(src *memory) *memory {
+	dst := &memory{}
+	exportForum = func(ctx context.Context, slug string, emit func(interface{}) error) error {
+		for _, v := range src.values {
+			if err := emit(v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	beginImport = func(ctx context.Context) (importer, error) {
+		return dst, nil
+	}
+	return dst
+}
+
+func export(t *testing.T, src *memory) string {
+	var buf bytes.Buffer
+	if err := Export(context.Background(), &buf, "pirates"); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestRoundTrip(t *testing.T) {
+	src := sampleForum()
+	dst := fake(src)
+	data := export(t, src)
+	lines := strings.Split(strings.TrimSuffix(data, "\n"), "\n")
+	if want := `{"type":"trailer","records":8}`; lines[len(lines)-1] != want {
+		t.Errorf("last line %s, want %s", lines[len(lines)-1], want)
+	}
+
+	summary, err := Import(context.Background(), strings.NewReader(data), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := models.ArchiveSummary{Forum: "pirates", Users: 1, Threads: 2, Posts: 3, Votes: 1}
+	if *summary != want {
+		t.Errorf("summary %+v, want %+v", *summary, want)
+	}
+	if !dst.committed || len(dst.values) != len(src.values) {
+		t.Fatalf("imported %d records, committed %v", len(dst.values), dst.committed)
+	}
+	for i, v := range src.values {
+		want, _ := json.Marshal(v)
+		got, _ := json.Marshal(dst.values[i])
+		if !bytes.Equal(got, want) {
+			t.Errorf("record %d is %s, want %s", i, got, want)
+		}
+	}
+}
+
+func TestImportRejectsBrokenArchives(t *testing.T) {
+	src := sampleForum()
+	fake(src)
+	data := export(t, src)
+	lines := strings.SplitAfter(data, "\n")
+	lines = lines[:len(lines)-1]
+	last := len(lines) - 1
+
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"empty", ""},
+		{"no trailer", strings.Join(lines[:last], "")},
+		{"cut in posts", strings.Join(lines[:6], "")},
+		{"cut mid-line", data[:len(data)-10]},
+		{"wrong count", strings.Join(lines[:last], "") + `{"type":"trailer","records":7}` + "\n"},
+		{"after trailer", data + lines[1]},
+		{"unknown version", strings.Replace(data, `"version":1`, `"version":2`, 1)},
+	}
+	for _, tt := range tests {
+		dst := fake(src)
+		_, err := Import(context.Background(), strings.NewReader(tt.data), "")
+		if errors.Cause(err) != ErrFormat {
+			t.Errorf("%s: error %v, want ErrFormat", tt.name, err)
+		}
+		if dst.committed {
+			t.Errorf("%s: import committed", tt.name)
+		}
+	}
+}
+
+func TestExportWithoutTrailerOnError(t *testing.T) {
+	src := sampleForum()
+	fake(src)
+	failing := errors.New("connection lost")
+	exportForum = func(ctx context.Context, slug string, emit func(interface{}) error) error {
+		emit(src.values[0])
+		return failing
+	}
+	var buf bytes.Buffer
+	if err := Export(context.Background(), &buf, "pirates"); err != failing {
+		t.Errorf("error %v, want %v", err, failing)
+	}
+	if strings.Contains(buf.String(), TypeTrailer) {
+		t.Errorf("failed export has a trailer: %s", buf.String())
+	}
+}
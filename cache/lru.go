@@ -0,0 +1,145 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+type Stats struct {
+	Size     int    `json:"size"`
+	Capacity int    `json:"capacity"`
+	Hits     uint64 `json:"hits"`
+	Misses   uint64 `json:"misses"`
+}
+
+type entry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+// LRU is a size-bounded least-recently-used cache safe for concurrent use.
+// Values are shared between callers and must not be mutated.
+type LRU struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	ll       *list.List
+	items    map[string]*list.Element
+	epoch    uint64
+	hits     uint64
+	misses   uint64
+}
+
+func New(capacity int) *LRU {
+	return &LRU{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (c *LRU) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*entry)
+		if c.ttl <= 0 || time.Now().Before(e.expires) {
+			c.ll.MoveToFront(el)
+			c.hits++
+			return e.value, true
+		}
+		c.ll.Remove(el)
+		delete(c.items, key)
+	}
+	c.misses++
+	return nil, false
+}
+
+func (c *LRU) Add(key string, value interface{}) {
+	c.mu.Lock()
+	c.add(key, value)
+	c.mu.Unlock()
+}
+
+// Epoch returns a token for AddSince; take it before loading the value.
+func (c *LRU) Epoch() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.epoch
+}
+
+// AddSince stores value unless the cache was invalidated after epoch, so a
+// value loaded concurrently with an update never outlives the invalidation.
+func (c *LRU) AddSince(epoch uint64, key string, value interface{}) {
+	c.mu.Lock()
+	if c.epoch == epoch {
+		c.add(key, value)
+	}
+	c.mu.Unlock()
+}
+
+func (c *LRU) add(key string, value interface{}) {
+	if c.capacity <= 0 {
+		return
+	}
+	expires := time.Now().Add(c.ttl)
+	if el, ok := c.items[key]; ok {
+		c.ll.MoveToFront(el)
+		e := el.Value.(*entry)
+		e.value, e.expires = value, expires
+		return
+	}
+	c.items[key] = c.ll.PushFront(&entry{key, value, expires})
+	for c.ll.Len() > c.capacity {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*entry).key)
+	}
+}
+
+func (c *LRU) Remove(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.epoch++
+	for _, key := range keys {
+		if el, ok := c.items[key]; ok {
+			c.ll.Remove(el)
+			delete(c.items, key)
+		}
+	}
+}
+
+func (c *LRU) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.epoch++
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+}
+
+func (c *LRU) Resize(capacity int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.capacity = capacity
+	for c.ll.Len() > 0 && c.ll.Len() > capacity {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*entry).key)
+	}
+}
+
+// SetTTL makes entries added from now on expire after ttl, so values that
+// other processes change are reloaded. Zero keeps entries until evicted.
+func (c *LRU) SetTTL(ttl time.Duration) {
+	c.mu.Lock()
+	c.ttl = ttl
+	c.mu.Unlock()
+}
+
+func (c *LRU) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return Stats{Size: c.ll.Len(), Capacity: c.capacity, Hits: c.hits, Misses: c.misses}
+}
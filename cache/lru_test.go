@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	c := New(2)
+	c.Add("a", 1)
+	c.Add("b", 2)
+	c.Get("a")
+	c.Add("c", 3)
+	if _, ok := c.Get("b"); ok {
+		t.Error("b survived although it was used least recently")
+	}
+	for key, want := range map[string]int{"a": 1, "c": 3} {
+		if v, ok := c.Get(key); !ok || v.(int) != want {
+			t.Errorf("Get(%q) = %v, %v, want %d", key, v, ok, want)
+		}
+	}
+	if s := c.Stats(); s.Size != 2 || s.Capacity != 2 || s.Hits != 3 || s.Misses != 1 {
+		t.Errorf("Stats() = %+v", s)
+	}
+}
+
+func TestLRUAddSinceSkipsInvalidated(t *testing.T) {
+	c := New(10)
+	epoch := c.Epoch()
+	c.Remove("a")
+	c.AddSince(epoch, "a", "stale")
+	if _, ok := c.Get("a"); ok {
+		t.Error("value loaded before Remove was cached")
+	}
+	c.AddSince(c.Epoch(), "a", "fresh")
+	if v, ok := c.Get("a"); !ok || v != "fresh" {
+		t.Errorf("Get(a) = %v, %v, want fresh", v, ok)
+	}
+	epoch = c.Epoch()
+	c.Purge()
+	c.AddSince(epoch, "b", "stale")
+	if s := c.Stats(); s.Size != 0 {
+		t.Errorf("size after Purge = %d, want 0", s.Size)
+	}
+}
+
+func TestLRUResize(t *testing.T) {
+	c := New(3)
+	c.Add("a", 1)
+	c.Add("b", 2)
+	c.Add("c", 3)
+	c.Resize(1)
+	if _, ok := c.Get("c"); !ok {
+		t.Error("newest entry evicted by Resize")
+	}
+	if s := c.Stats(); s.Size != 1 {
+		t.Errorf("size = %d, want 1", s.Size)
+	}
+	c.Resize(0)
+	c.Add("d", 4)
+	if s := c.Stats(); s.Size != 0 {
+		t.Errorf("size with capacity 0 = %d, want 0", s.Size)
+	}
+}
+
+func TestLRUTTL(t *testing.T) {
+	c := New(10)
+	c.SetTTL(20 * time.Millisecond)
+	c.Add("a", 1)
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("fresh entry missing")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, ok := c.Get("a"); ok {
+		t.Error("expired entry served")
+	}
+	if s := c.Stats(); s.Size != 0 {
+		t.Errorf("expired entry kept, size = %d", s.Size)
+	}
+	c.SetTTL(0)
+	c.Add("b", 2)
+	time.Sleep(30 * time.Millisecond)
+	if _, ok := c.Get("b"); !ok {
+		t.Error("entry without TTL expired")
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"db-forum/archive"
+	"db-forum/database"
+	"flag"
+	"io"
+	"log"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+func runCommand(name string, args []string) error {
+	switch name {
+	case "backfill":
+		return backfill()
+	case "export":
+		return export(args)
+	case "import":
+		return load(args)
+	case "config":
+		return printConfig(args)
+	}
+	return errors.Errorf("unknown command %q (want backfill, export, import or config)", name)
+}
+
+func printConfig(args []string) error {
+	if len(args) != 1 || args[0] != "print" {
+		return errors.New("usage: config print")
+	}
+	if err := cfg.Print(os.Stdout); err != nil {
+		return errors.Wrap(err, "can't print config")
+	}
+	return cfg.Validate()
+}
+
+func backfill() error {
+	if err := database.OpenDB(cfg.DSN()); err != nil {
+		return errors.Wrap(err, "can't open DB")
+	}
+	added, err := database.BackfillForumUsers(context.Background())
+	if err != nil {
+		return errors.Wrap(err, "can't backfill forum users")
+	}
+	log.Printf("backfill done: %d forum users added\n", added)
+	return nil
+}
+
+func export(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	forum := fs.String("forum", "", "slug of the exported forum")
+	out := fs.String("out", "-", "archive file ('-' for stdout)")
+	fs.Parse(args)
+	if *forum == "" {
+		return errors.New("export needs --forum")
+	}
+	if err := database.OpenDB(cfg.DSN()); err != nil {
+		return errors.Wrap(err, "can't open DB")
+	}
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return errors.Wrap(err, "can't create archive")
+		}
+		defer f.Close()
+		w = f
+	}
+	return archive.Export(context.Background(), w, *forum)
+}
+
+func load(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	forum := fs.String("forum", "", "new slug for the imported forum (default: keep the archived one)")
+	in := fs.String("in", "-", "archive file ('-' for stdin)")
+	fs.Parse(args)
+	if err := database.OpenDB(cfg.DSN()); err != nil {
+		return errors.Wrap(err, "can't open DB")
+	}
+	var r io.Reader = os.Stdin
+	if *in != "-" {
+		f, err := os.Open(*in)
+		if err != nil {
+			return errors.Wrap(err, "can't open archive")
+		}
+		defer f.Close()
+		r = f
+	}
+	summary, err := archive.Import(context.Background(), r, *forum)
+	if err != nil {
+		return err
+	}
+	log.Printf("imported forum %s: %d users, %d threads, %d posts, %d votes\n",
+		summary.Forum, summary.Users, summary.Threads, summary.Posts, summary.Votes)
+	return nil
+}
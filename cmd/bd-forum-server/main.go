@@ -1,21 +1,221 @@
 package main
 
 import (
+	"context"
+	"db-forum/api"
+	"db-forum/config"
 	"db-forum/database"
+	"db-forum/logger"
+	"db-forum/reqlog"
+	"db-forum/restapi"
 	"db-forum/router"
-	"flag"
 	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/valyala/fasthttp"
 )
 
+var cfg *config.Config
+
 func main() {
-	flag.Parse()
-	if err := database.InitDB(config.DB); err != nil {
-		log.Println("can't init DB", err.Error())
+	var args []string
+	var err error
+	cfg, args, err = config.Load(os.Args[0], os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(args) != 0 {
+		if err := runCommand(args[0], args[1:]); err != nil {
+			log.Fatal(err)
+		}
 		return
 	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	level, _ := logger.ParseLevel(cfg.LogLevel)
+	logger.Configure(level, cfg.LogJSON)
+	log := logger.Default()
+	router.DefaultTimeout = cfg.RequestTimeout
+	router.RateLimits = rateLimits()
+	timeouts, _ := config.ParseRouteTimeouts(cfg.RouteTimeouts)
+	policies, _ := config.ParseRoutes(cfg.RouteCache)
+	if err := router.Configure(timeouts, policies); err != nil {
+		log.Error("can't configure routes", "error", err)
+		os.Exit(1)
+	}
+	database.SetCacheSize(cfg.CacheSize)
+	database.SetCacheTTL(cfg.CacheTTL)
+	database.SetPool(database.Pool{
+		MaxOpenConns:    cfg.DB.MaxOpenConns,
+		MaxIdleConns:    cfg.DB.MaxIdleConns,
+		ConnMaxLifetime: cfg.DB.ConnMaxLifetime,
+	})
+	if err := database.InitDB(withStatementTimeout(cfg.DSN())); err != nil {
+		log.Error("can't init DB", "error", err)
+		os.Exit(1)
+	}
+	if dsn := cfg.ReplicaDSN(); dsn != "" {
+		if err := database.OpenReplica(withStatementTimeout(dsn)); err != nil {
+			log.Error("can't open replica", "error", err)
+			os.Exit(1)
+		}
+	}
+	api.IdempotencyWindow = cfg.IdempotencyWindow
+	api.IdempotencyPending = cfg.IdempotencyPending
+	if cfg.IdempotencyWindow > 0 {
+		go sweepIdempotencyKeys()
+	}
+	api.AdminToken = cfg.AdminToken
+	if err := api.LoadSpec(restapi.SwaggerJSON); err != nil {
+		log.Error("can't load API spec", "error", err)
+		os.Exit(1)
+	}
+	serve, stop, err := newServer()
+	if err != nil {
+		log.Error("can't start server", "mode", cfg.Mode, "error", err)
+		os.Exit(1)
+	}
+	ln, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		log.Error("can't listen", "addr", cfg.Listen, "error", err)
+		os.Exit(1)
+	}
+	log.Info("starting server", "addr", cfg.Listen, "mode", cfg.Mode)
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(ln)
+	}()
+	var bulk *http.Server
+	if cfg.BulkListen != "" {
+		bulkLn, err := net.Listen("tcp", cfg.BulkListen)
+		if err != nil {
+			log.Error("can't listen", "addr", cfg.BulkListen, "error", err)
+			os.Exit(1)
+		}
+		log.Info("starting bulk server", "addr", cfg.BulkListen)
+		bulk = &http.Server{Handler: api.Bulk(cfg.BulkMaxBodySize)}
+		go func() {
+			served <- bulk.Serve(bulkLn)
+		}()
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err := <-served:
+		log.Error("server stopped", "error", err)
+		os.Exit(1)
+	case sig := <-signals:
+		log.Info("shutting down", "signal", sig.String(), "drain_delay", cfg.DrainDelay)
+	}
+	api.Drain()
+	time.Sleep(cfg.DrainDelay)
+	bulkStopped := make(chan error, 1)
+	if bulk != nil {
+		go func() {
+			bulkStopped <- shutdownHTTP(bulk, cfg.ShutdownTimeout)
+		}()
+	} else {
+		bulkStopped <- nil
+	}
+	if err := stop(ln); err != nil {
+		log.Warn("shutdown timed out", "error", err)
+	}
+	if err := <-bulkStopped; err != nil {
+		log.Warn("bulk shutdown timed out", "error", err)
+	}
+	if err := database.Close(); err != nil {
+		log.Error(err.Error())
+	}
+	log.Info("server stopped")
+}
+
+// idempotencySweepInterval is how often expired idempotency keys are
+// deleted; claims only expire the key they claim.
+const idempotencySweepInterval = 10 * time.Minute
+
+func sweepIdempotencyKeys() {
+	log := logger.Default()
+	for range time.Tick(idempotencySweepInterval) {
+		swept, err := database.SweepIdempotencyKeys(context.Background(), cfg.IdempotencyWindow, cfg.IdempotencyPending)
+		if err != nil {
+			log.Error("can't sweep idempotency keys", "error", err)
+			continue
+		}
+		log.Debug("swept idempotency keys", "deleted", swept)
+	}
+}
+
+// newServer returns functions serving the API in the configured mode and
+// shutting it down gracefully.
+func newServer() (serve func(net.Listener) error, stop func(net.Listener) error, err error) {
+	if cfg.Mode == "swagger" {
+		handler, err := restapi.Handler()
+		if err != nil {
+			return nil, nil, err
+		}
+		server := &http.Server{Handler: api.Health(limitBody(handler, cfg.MaxBodySize))}
+		stop = func(net.Listener) error {
+			return shutdownHTTP(server, cfg.ShutdownTimeout)
+		}
+		return server.Serve, stop, nil
+	}
 	r := router.CreateRouter()
-	log.Println("starting server on " + config.Port)
-	log.Fatal(fasthttp.ListenAndServe(config.Port, r.Handler))
+	handler := api.Track(api.RequestID(api.CancelOnDisconnect(r.Handler)))
+	if cfg.RecordDir != "" {
+		recorder, err := reqlog.NewRecorder(reqlog.RecorderConfig{
+			Dir:         cfg.RecordDir,
+			Sample:      cfg.RecordSample,
+			MaxFileSize: cfg.RecordMaxSize,
+			MaxFiles:    cfg.RecordMaxFiles,
+			MaxBodySize: cfg.RecordMaxBody,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		handler = recorder.Handler(handler)
+	}
+	server := &fasthttp.Server{
+		Handler:            api.Compress(handler),
+		MaxRequestBodySize: cfg.MaxBodySize,
+	}
+	serve = func(ln net.Listener) error {
+		return server.Serve(api.WatchConns(ln))
+	}
+	stop = func(ln net.Listener) error {
+		return shutdown(ln, cfg.ShutdownTimeout)
+	}
+	return serve, stop, nil
+}
+
+func withStatementTimeout(dsn string) string {
+	timeout := cfg.DB.StatementTimeout
+	if timeout < 0 {
+		return dsn
+	}
+	if timeout == 0 {
+		timeout = router.MaxTimeout() + time.Second
+	}
+	return database.WithStatementTimeout(dsn, timeout)
+}
+
+// rateLimits returns the limits of the routes the config sets rates for.
+func rateLimits() map[string]api.Limit {
+	limits := make(map[string]api.Limit)
+	for path, rate := range map[string]string{
+		"/api/thread/:slug/create":   cfg.RatePosts,
+		"/api/forum/*options":        cfg.RateThreads,
+		"/api/thread/:slug/vote":     cfg.RateVotes,
+		"/api/user/:nickname/create": cfg.RateUsers,
+	} {
+		if n, per, _ := config.ParseRate(rate); n > 0 {
+			limits[path] = api.Limit{Rate: float64(n) / per.Seconds(), Burst: n}
+		}
+	}
+	return limits
 }
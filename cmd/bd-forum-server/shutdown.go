@@ -0,0 +1,39 @@
+package main
+
+import (
+	"db-forum/api"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// abortGrace is how long cancelled requests get to answer.
+const abortGrace = time.Second
+
+// shutdown stops accepting connections and waits up to timeout for the
+// requests in flight. Requests still running then are cancelled.
+func shutdown(ln net.Listener, timeout time.Duration) error {
+	if err := ln.Close(); err != nil {
+		return errors.Wrap(err, "can't close listener")
+	}
+	if waitIdle(timeout) {
+		return nil
+	}
+	api.Abort()
+	if waitIdle(abortGrace) {
+		return errors.New("requests in flight were cancelled")
+	}
+	return errors.Errorf("%d requests still in flight", api.InFlight())
+}
+
+func waitIdle(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for api.InFlight() != 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return true
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// limitBody fails reading request bodies longer than max bytes.
+func limitBody(handler http.Handler, max int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, int64(max))
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// shutdownHTTP stops server from accepting connections and waits up to
+// timeout for the requests in flight, then closes the rest.
+func shutdownHTTP(server *http.Server, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		server.Close()
+		return errors.Wrap(err, "requests in flight were cancelled")
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// compareJSON returns "" when got matches want as JSON, skipping object
+// keys in ignore at any depth, and a short description otherwise.
+func compareJSON(want []byte, got []byte, ignore map[string]bool) string {
+	var w, g interface{}
+	if err := json.Unmarshal(want, &w); err != nil {
+		if strings.TrimSpace(string(want)) == strings.TrimSpace(string(got)) {
+			return ""
+		}
+		return "body differs"
+	}
+	if err := json.Unmarshal(got, &g); err != nil {
+		return "response is not JSON: " + err.Error()
+	}
+	return diff("$", strip(w, ignore), strip(g, ignore))
+}
+
+func strip(value interface{}, ignore map[string]bool) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if ignore[key] {
+				delete(v, key)
+				continue
+			}
+			v[key] = strip(item, ignore)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = strip(item, ignore)
+		}
+	}
+	return value
+}
+
+func diff(path string, want interface{}, got interface{}) string {
+	switch w := want.(type) {
+	case map[string]interface{}:
+		g, ok := got.(map[string]interface{})
+		if !ok {
+			return fmt.Sprintf("%s: want object", path)
+		}
+		for key, item := range w {
+			if d := diff(path+"."+key, item, g[key]); d != "" {
+				return d
+			}
+		}
+		for key := range g {
+			if _, ok := w[key]; !ok {
+				return fmt.Sprintf("%s.%s: unexpected key", path, key)
+			}
+		}
+		return ""
+	case []interface{}:
+		g, ok := got.([]interface{})
+		if !ok {
+			return fmt.Sprintf("%s: want array", path)
+		}
+		if len(w) != len(g) {
+			return fmt.Sprintf("%s: %d items, want %d", path, len(g), len(w))
+		}
+		for i := range w {
+			if d := diff(fmt.Sprintf("%s[%d]", path, i), w[i], g[i]); d != "" {
+				return d
+			}
+		}
+		return ""
+	}
+	if !reflect.DeepEqual(want, got) {
+		return fmt.Sprintf("%s: %v, want %v", path, got, want)
+	}
+	return ""
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompareJSON(t *testing.T) {
+	ignore := map[string]bool{"created": true}
+	tests := []struct {
+		name string
+		want string
+		got  string
+		diff string
+	}{
+		{"equal", `{"a":1,"b":[1,2]}`, `{"b":[1,2],"a":1}`, ""},
+		{"ignored key", `{"a":1,"created":"x"}`, `{"a":1,"created":"y"}`, ""},
+		{"ignored nested", `[{"id":1,"created":"x"}]`, `[{"id":1}]`, ""},
+		{"value", `{"a":1}`, `{"a":2}`, "$.a: 2, want 1"},
+		{"missing key", `{"a":1,"b":2}`, `{"a":1}`, "$.b: <nil>, want 2"},
+		{"extra key", `{"a":1}`, `{"a":1,"b":2}`, "$.b: unexpected key"},
+		{"length", `[1,2]`, `[1]`, "$: 1 items, want 2"},
+		{"item", `[{"a":[1]}]`, `[{"a":[2]}]`, "$[0].a[0]: 2, want 1"},
+		{"type", `{"a":{}}`, `{"a":[]}`, "$.a: want object"},
+		{"not JSON in log", "ok\n", "ok", ""},
+		{"not JSON in log differs", "ok", "fail", "body differs"},
+		{"not JSON in response", `{}`, "<html>", "response is not JSON"},
+	}
+	for _, tt := range tests {
+		got := compareJSON([]byte(tt.want), []byte(tt.got), ignore)
+		if tt.diff == "" && got != "" || !strings.HasPrefix(got, tt.diff) {
+			t.Errorf("%s: compareJSON = %q, want %q", tt.name, got, tt.diff)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var sorted []time.Duration
+	for i := 1; i <= 100; i++ {
+		sorted = append(sorted, time.Duration(i))
+	}
+	tests := []struct {
+		p    float64
+		want time.Duration
+	}{
+		{0, 1}, {0.5, 50}, {0.9, 90}, {0.99, 99}, {1, 100},
+	}
+	for _, tt := range tests {
+		if got := percentile(sorted, tt.p); got != tt.want {
+			t.Errorf("percentile(%v) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+	if got := percentile(nil, 0.5); got != 0 {
+		t.Errorf("percentile of nothing = %v", got)
+	}
+}
+
+func TestValidateFlags(t *testing.T) {
+	good := flags{Concurrency: 1, Repeat: 1, Timeout: time.Second}
+	if err := good.validate(); err != nil {
+		t.Errorf("validate() = %v", err)
+	}
+	for _, bad := range []flags{
+		{Concurrency: 0, Repeat: 1, Timeout: time.Second},
+		{Concurrency: -1, Repeat: 1, Timeout: time.Second},
+		{Concurrency: 1, Repeat: -1, Timeout: time.Second},
+		{Concurrency: 1, Repeat: 1, Rate: -1, Timeout: time.Second},
+		{Concurrency: 1, Repeat: 1},
+	} {
+		if err := bad.validate(); err == nil {
+			t.Errorf("validate(%+v) accepted", bad)
+		}
+	}
+}
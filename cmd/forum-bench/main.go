@@ -0,0 +1,193 @@
+// Command forum-bench replays a reqlog request log against a running
+// server and reports latency percentiles per route.
+package main
+
+import (
+	"db-forum/reqlog"
+	"db-forum/router"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+type flags struct {
+	Log         string
+	Target      string
+	Concurrency int
+	Rate        float64
+	Repeat      int
+	Timeout     time.Duration
+	Check       bool
+	Ignore      string
+}
+
+var config flags
+
+func init() {
+	flag.StringVar(&config.Log, "log", "requests.jsonl", "request log to replay ('-' for stdin)")
+	flag.StringVar(&config.Target, "target", "localhost:5000", "host:port of the server")
+	flag.IntVar(&config.Concurrency, "c", 8, "number of concurrent clients (1 keeps log order)")
+	flag.Float64Var(&config.Rate, "rate", 0, "max requests per second (0: unlimited)")
+	flag.IntVar(&config.Repeat, "n", 1, "how many times to replay the log")
+	flag.DurationVar(&config.Timeout, "timeout", 10*time.Second, "request timeout")
+	flag.BoolVar(&config.Check, "check", true, "compare statuses and JSON bodies with the log")
+	flag.StringVar(&config.Ignore, "ignore", "created", "comma separated JSON keys skipped when comparing bodies")
+}
+
+// validate returns an error about the first flag that can't work.
+func (f flags) validate() error {
+	switch {
+	case f.Concurrency < 1:
+		return errors.New("-c must be at least 1")
+	case f.Repeat < 0:
+		return errors.New("-n can't be negative")
+	case f.Rate < 0:
+		return errors.New("-rate can't be negative")
+	case f.Timeout <= 0:
+		return errors.New("-timeout must be positive")
+	}
+	return nil
+}
+
+func main() {
+	flag.Parse()
+	if err := config.validate(); err != nil {
+		log.Fatal(err)
+	}
+	entries, skipped, truncated, err := readLog(config.Log)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if skipped != 0 {
+		log.Printf("skipped %d lines that are not recorded requests\n", skipped)
+	}
+	if truncated != 0 {
+		log.Printf("skipped %d requests recorded with truncated bodies\n", truncated)
+	}
+	if len(entries) == 0 {
+		log.Fatal("no requests to replay in " + config.Log)
+	}
+
+	ignore := make(map[string]bool)
+	for _, key := range strings.Split(config.Ignore, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			ignore[key] = true
+		}
+	}
+	client := &fasthttp.Client{MaxConnsPerHost: config.Concurrency}
+	stats := newStats()
+	jobs := make(chan *reqlog.Entry)
+	var wg sync.WaitGroup
+	for i := 0; i < config.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for entry := range jobs {
+				stats.add(replay(client, entry, ignore))
+			}
+		}()
+	}
+
+	var tick <-chan time.Time
+	if config.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / config.Rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	start := time.Now()
+	for i := 0; i < config.Repeat; i++ {
+		for _, entry := range entries {
+			if tick != nil {
+				<-tick
+			}
+			jobs <- entry
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	stats.report(os.Stdout, time.Since(start))
+	if stats.failed() {
+		os.Exit(1)
+	}
+}
+
+// readLog returns the requests in the log, the number of lines that are
+// not requests and of requests that can't be replayed because the recorder
+// cut their bodies.
+func readLog(name string) ([]*reqlog.Entry, int, int, error) {
+	var r io.Reader = os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		defer f.Close()
+		r = f
+	}
+	reader := reqlog.NewReader(r)
+	entries := make([]*reqlog.Entry, 0)
+	truncated := 0
+	for {
+		entry, err := reader.Next()
+		if err == io.EOF {
+			return entries, reader.Skipped, truncated, nil
+		}
+		if err != nil {
+			return nil, reader.Skipped, truncated, err
+		}
+		if entry.BodyTruncated {
+			truncated++
+			continue
+		}
+		entries = append(entries, entry)
+	}
+}
+
+type result struct {
+	route    string
+	latency  time.Duration
+	err      error
+	mismatch string
+}
+
+func replay(client *fasthttp.Client, entry *reqlog.Entry, ignore map[string]bool) result {
+	res := result{route: router.Match(entry.Method, entry.Path)}
+	if res.route == "" {
+		res.route = "unmatched"
+	}
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+	req.Header.SetMethod(entry.Method)
+	req.SetRequestURI("http://" + config.Target + entry.URI())
+	if entry.Body != "" {
+		req.Header.SetContentType("application/json")
+		req.SetBodyString(entry.Body)
+	}
+	start := time.Now()
+	res.err = client.DoTimeout(req, resp, config.Timeout)
+	res.latency = time.Since(start)
+	if res.err != nil || !config.Check {
+		return res
+	}
+	if entry.Status != 0 && entry.Status != resp.StatusCode() {
+		res.mismatch = fmt.Sprintf("%s %s: status %d, want %d", entry.Method, entry.URI(), resp.StatusCode(), entry.Status)
+		return res
+	}
+	if entry.Response != "" && !entry.ResponseTruncated {
+		if diff := compareJSON([]byte(entry.Response), resp.Body(), ignore); diff != "" {
+			res.mismatch = fmt.Sprintf("%s %s: %s", entry.Method, entry.URI(), diff)
+		}
+	}
+	return res
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+const maxReportedMismatches = 20
+
+type routeStats struct {
+	latencies  []time.Duration
+	errors     int
+	mismatches int
+}
+
+type stats struct {
+	mu         sync.Mutex
+	routes     map[string]*routeStats
+	mismatches []string
+}
+
+func newStats() *stats {
+	return &stats{routes: make(map[string]*routeStats)}
+}
+
+func (s *stats) add(res result) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	route, ok := s.routes[res.route]
+	if !ok {
+		route = &routeStats{}
+		s.routes[res.route] = route
+	}
+	if res.err != nil {
+		route.errors++
+		return
+	}
+	route.latencies = append(route.latencies, res.latency)
+	if res.mismatch != "" {
+		route.mismatches++
+		if len(s.mismatches) < maxReportedMismatches {
+			s.mismatches = append(s.mismatches, res.mismatch)
+		}
+	}
+}
+
+func (s *stats) failed() bool {
+	for _, route := range s.routes {
+		if route.errors != 0 || route.mismatches != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(p*float64(len(sorted))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+func (s *stats) report(w io.Writer, elapsed time.Duration) {
+	names := make([]string, 0, len(s.routes))
+	total := 0
+	for name, route := range s.routes {
+		names = append(names, name)
+		total += len(route.latencies) + route.errors
+	}
+	sort.Strings(names)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "route\tcount\terrors\tmismatch\tp50\tp90\tp99\tmax\t")
+	for _, name := range names {
+		route := s.routes[name]
+		sort.Slice(route.latencies, func(i, j int) bool { return route.latencies[i] < route.latencies[j] })
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%v\t%v\t%v\t%v\t\n", name, len(route.latencies), route.errors, route.mismatches,
+			percentile(route.latencies, 0.5), percentile(route.latencies, 0.9), percentile(route.latencies, 0.99),
+			percentile(route.latencies, 1))
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\n%d requests in %v (%.1f req/s)\n", total, elapsed, float64(total)/elapsed.Seconds())
+	for _, mismatch := range s.mismatches {
+		fmt.Fprintln(w, "mismatch:", mismatch)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"db-forum/models"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/go-openapi/strfmt"
+)
+
+var epoch = time.Date(2018, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// generator derives every name, text and choice from one seeded source,
+// so the same flags always produce the same dataset.
+type generator struct {
+	rnd    *rand.Rand
+	prefix string
+
+	users   []models.User
+	forums  []models.Forum
+	threads []models.Thread
+}
+
+func newGenerator(seed int64) *generator {
+	return &generator{rnd: rand.New(rand.NewSource(seed)), prefix: fmt.Sprintf("s%d", seed)}
+}
+
+// zipf picks indexes in [0, n) with a power-law skew towards small ones.
+func (g *generator) zipf(n int) *rand.Zipf {
+	return rand.NewZipf(g.rnd, config.Skew, 1, uint64(n-1))
+}
+
+func stamp(t time.Time) *strfmt.DateTime {
+	dt := strfmt.DateTime(t)
+	return &dt
+}
+
+var words = []string{
+	"index", "query", "forum", "thread", "post", "tree", "path", "root", "vote", "user",
+	"cache", "plan", "scan", "join", "sort", "limit", "since", "slug", "parent", "nested",
+	"fast", "slow", "deep", "wide", "bench", "load", "replica", "vacuum", "lock", "commit",
+}
+
+func (g *generator) text(min, max int) string {
+	n := min + g.rnd.Intn(max-min+1)
+	buf := make([]byte, 0, n*8)
+	for i := 0; i < n; i++ {
+		if i != 0 {
+			buf = append(buf, ' ')
+		}
+		buf = append(buf, words[g.rnd.Intn(len(words))]...)
+	}
+	return string(buf)
+}
+
+func (g *generator) genUsers(n int) {
+	g.users = make([]models.User, n)
+	for i := range g.users {
+		nickname := fmt.Sprintf("%s_u%d", g.prefix, i)
+		g.users[i] = models.User{
+			Nickname: nickname,
+			Fullname: g.text(2, 3),
+			About:    g.text(0, 20),
+			Email:    nickname + "@seed.example",
+		}
+	}
+}
+
+func (g *generator) genForums(n int) {
+	author := g.zipf(len(g.users))
+	g.forums = make([]models.Forum, n)
+	for i := range g.forums {
+		g.forums[i] = models.Forum{
+			Slug:  fmt.Sprintf("%s-f%d", g.prefix, i),
+			Title: g.text(2, 5),
+			User:  g.users[author.Uint64()].Nickname,
+		}
+	}
+}
+
+func (g *generator) genThreads(n int) {
+	forum, author := g.zipf(len(g.forums)), g.zipf(len(g.users))
+	g.threads = make([]models.Thread, n)
+	for i := range g.threads {
+		g.threads[i] = models.Thread{
+			Slug:    fmt.Sprintf("%s-t%d", g.prefix, i),
+			Title:   g.text(3, 8),
+			Message: g.text(5, 60),
+			Author:  g.users[author.Uint64()].Nickname,
+			Forum:   g.forums[forum.Uint64()].Slug,
+			Created: stamp(epoch.Add(time.Duration(i) * time.Minute)),
+		}
+	}
+}
+
+// postCounts spreads total posts over threads with a power law, so a few
+// threads get most of the posts.
+func (g *generator) postCounts(total int) []int {
+	counts := make([]int, len(g.threads))
+	thread := g.zipf(len(g.threads))
+	for i := 0; i < total; i++ {
+		counts[thread.Uint64()]++
+	}
+	return counts
+}
+
+// genPosts builds the tree of one thread by preferential attachment: a
+// post starts a new root with probability RootShare, otherwise it replies
+// to an earlier post chosen in proportion to 1 + its number of replies.
+// Ids are local to the thread and only link parents to children.
+func (g *generator) genPosts(thread *models.Thread, n int) []models.Post {
+	posts := make([]models.Post, n)
+	targets := make([]int64, 0, 2*n)
+	author := g.zipf(len(g.users))
+	created := time.Time(*thread.Created)
+	for i := range posts {
+		id := int64(i + 1)
+		var parent int64
+		if len(targets) != 0 && g.rnd.Float64() >= config.RootShare {
+			parent = targets[g.rnd.Intn(len(targets))]
+			targets = append(targets, parent)
+		}
+		targets = append(targets, id)
+		posts[i] = models.Post{
+			ID:       id,
+			Parent:   parent,
+			Author:   g.users[author.Uint64()].Nickname,
+			Message:  g.text(3, 80),
+			IsEdited: g.rnd.Intn(20) == 0,
+			Created:  stamp(created.Add(time.Duration(i) * time.Second)),
+		}
+	}
+	return posts
+}
+
+// genVotes lets users vote on threads, popular threads getting more
+// votes, mostly upvotes.
+func (g *generator) genVotes(n int) []models.Vote {
+	votes := make([]models.Vote, n)
+	thread, user := g.zipf(len(g.threads)), g.zipf(len(g.users))
+	for i := range votes {
+		voice := int32(1)
+		if g.rnd.Float64() < config.Downvotes {
+			voice = -1
+		}
+		votes[i] = models.Vote{
+			Nickname: g.users[user.Uint64()].Nickname,
+			Voice:    voice,
+			ThreadId: int32(thread.Uint64()),
+		}
+	}
+	return votes
+}
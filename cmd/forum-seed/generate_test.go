@@ -0,0 +1,87 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func generate(seed int64) *generator {
+	g := newGenerator(seed)
+	g.genUsers(50)
+	g.genForums(3)
+	g.genThreads(20)
+	return g
+}
+
+func TestGeneratorIsDeterministic(t *testing.T) {
+	a, b := generate(7), generate(7)
+	if !reflect.DeepEqual(a.users, b.users) || !reflect.DeepEqual(a.forums, b.forums) || !reflect.DeepEqual(a.threads, b.threads) {
+		t.Fatal("same seed gave different users, forums or threads")
+	}
+	if !reflect.DeepEqual(a.postCounts(500), b.postCounts(500)) {
+		t.Error("same seed gave different post counts")
+	}
+	if !reflect.DeepEqual(a.genPosts(&a.threads[0], 100), b.genPosts(&b.threads[0], 100)) {
+		t.Error("same seed gave different posts")
+	}
+	if !reflect.DeepEqual(a.genVotes(100), b.genVotes(100)) {
+		t.Error("same seed gave different votes")
+	}
+	if c := generate(8); reflect.DeepEqual(a.users, c.users) {
+		t.Error("different seeds gave the same users")
+	}
+}
+
+func TestGeneratedData(t *testing.T) {
+	g := generate(1)
+	nicknames := make(map[string]bool)
+	for _, user := range g.users {
+		if nicknames[user.Nickname] {
+			t.Errorf("nickname %s repeats", user.Nickname)
+		}
+		nicknames[user.Nickname] = true
+	}
+	for _, thread := range g.threads {
+		if !nicknames[thread.Author] {
+			t.Errorf("thread %s by unknown %s", thread.Slug, thread.Author)
+		}
+	}
+
+	counts := g.postCounts(1000)
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
+	if total != 1000 {
+		t.Errorf("post counts add up to %d, want 1000", total)
+	}
+	if counts[0] < counts[len(counts)-1] {
+		t.Errorf("first thread got %d posts, last %d: no skew", counts[0], counts[len(counts)-1])
+	}
+
+	posts := g.genPosts(&g.threads[0], 200)
+	roots := 0
+	for i, post := range posts {
+		if post.ID != int64(i+1) {
+			t.Fatalf("post %d has id %d", i, post.ID)
+		}
+		if post.Parent >= post.ID {
+			t.Errorf("post %d replies to later post %d", post.ID, post.Parent)
+		}
+		if post.Parent == 0 {
+			roots++
+		}
+		if !nicknames[post.Author] {
+			t.Errorf("post %d by unknown %s", post.ID, post.Author)
+		}
+	}
+	if roots == 0 || roots == len(posts) {
+		t.Errorf("%d roots of %d posts", roots, len(posts))
+	}
+
+	for _, vote := range g.genVotes(100) {
+		if vote.Voice != 1 && vote.Voice != -1 || int(vote.ThreadId) >= len(g.threads) {
+			t.Errorf("bad vote %+v", vote)
+		}
+	}
+}
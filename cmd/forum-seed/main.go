@@ -0,0 +1,145 @@
+// Command forum-seed fills a forum database with a synthetic dataset that
+// is fully determined by its flags: skewed user activity, popular forums
+// and threads, and post trees grown by preferential attachment.
+package main
+
+import (
+	"db-forum/database"
+	"db-forum/models"
+	"flag"
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/valyala/fasthttp"
+)
+
+type flags struct {
+	Seed      int64
+	Users     int
+	Forums    int
+	Threads   int
+	Posts     int
+	Votes     int
+	Skew      float64
+	RootShare float64
+	Downvotes float64
+
+	Mode   string
+	DB     string
+	Target string
+	Bulk   string
+	Token  string
+}
+
+var config flags
+
+func init() {
+	flag.Int64Var(&config.Seed, "seed", 1, "seed of the dataset; also prefixes all names")
+	flag.IntVar(&config.Users, "users", 1000, "number of users")
+	flag.IntVar(&config.Forums, "forums", 10, "number of forums")
+	flag.IntVar(&config.Threads, "threads", 1000, "number of threads")
+	flag.IntVar(&config.Posts, "posts", 100000, "total number of posts")
+	flag.IntVar(&config.Votes, "votes", 10000, "number of votes")
+	flag.Float64Var(&config.Skew, "skew", 1.2, "power-law exponent of activity (> 1, larger is more skewed)")
+	flag.Float64Var(&config.RootShare, "root-share", 0.05, "probability that a post starts a new root instead of replying")
+	flag.Float64Var(&config.Downvotes, "downvotes", 0.3, "share of negative votes")
+	flag.StringVar(&config.Mode, "mode", "db", "where to write: db (through the database package) or http")
+	flag.StringVar(&config.DB, "db", "user=docker password=docker dbname=docker sslmode=disable", "DSN for db mode")
+	flag.StringVar(&config.Target, "target", "localhost:5000", "host:port of the server for http mode")
+	flag.StringVar(&config.Bulk, "bulk", "", "host:port of the server's -bulk-listen for post imports in http mode (empty: -target)")
+	flag.StringVar(&config.Token, "admin-token", "", "admin token of the server, needed for post imports in http mode")
+}
+
+func validate() error {
+	if config.Users < 1 || config.Forums < 1 || config.Threads < 1 {
+		return errors.New("users, forums and threads must be positive")
+	}
+	if config.Posts < 0 || config.Votes < 0 {
+		return errors.New("posts and votes can't be negative")
+	}
+	if config.Skew <= 1 {
+		return errors.New("skew must be greater than 1")
+	}
+	if config.RootShare < 0 || config.RootShare > 1 || config.Downvotes < 0 || config.Downvotes > 1 {
+		return errors.New("root-share and downvotes must be within [0, 1]")
+	}
+	return nil
+}
+
+func main() {
+	flag.Parse()
+	if err := validate(); err != nil {
+		log.Fatal(err)
+	}
+	var out sink
+	switch config.Mode {
+	case "db":
+		if err := database.OpenDB(config.DB); err != nil {
+			log.Fatal(err)
+		}
+		out = dbSink{}
+	case "http":
+		bulk := config.Bulk
+		if bulk == "" {
+			bulk = config.Target
+		}
+		out = &httpSink{client: &fasthttp.Client{}, target: config.Target, bulk: bulk, token: config.Token}
+	default:
+		log.Fatalf("unknown mode %q", config.Mode)
+	}
+	if err := seed(newGenerator(config.Seed), out); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func seed(g *generator, out sink) error {
+	start := time.Now()
+	g.genUsers(config.Users)
+	for i := range g.users {
+		if err := out.User(&g.users[i]); err != nil {
+			return errors.Wrap(err, "can't create user")
+		}
+	}
+	log.Printf("%d users\n", len(g.users))
+
+	g.genForums(config.Forums)
+	for i := range g.forums {
+		if err := out.Forum(&g.forums[i]); err != nil {
+			return errors.Wrap(err, "can't create forum")
+		}
+	}
+	log.Printf("%d forums\n", len(g.forums))
+
+	g.genThreads(config.Threads)
+	counts := g.postCounts(config.Posts)
+	stored := make([]*models.Thread, len(g.threads))
+	posts := 0
+	for i := range g.threads {
+		thread, created, err := out.Thread(&g.threads[i])
+		if err != nil {
+			return errors.Wrap(err, "can't create thread")
+		}
+		stored[i] = thread
+		// Posts are generated even for existing threads to keep the
+		// random sequence, and so the rest of the dataset, unchanged.
+		threadPosts := g.genPosts(&g.threads[i], counts[i])
+		if !created || len(threadPosts) == 0 {
+			continue
+		}
+		if err := out.Posts(thread, threadPosts); err != nil {
+			return errors.Wrapf(err, "can't create posts of thread %d", thread.ID)
+		}
+		posts += len(threadPosts)
+	}
+	log.Printf("%d threads, %d posts\n", len(g.threads), posts)
+
+	votes := g.genVotes(config.Votes)
+	for i := range votes {
+		if err := out.Vote(stored[votes[i].ThreadId], &votes[i]); err != nil {
+			return errors.Wrap(err, "can't vote")
+		}
+	}
+	log.Printf("%d votes, done in %v\n", len(votes), time.Since(start))
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"db-forum/database"
+	"db-forum/models"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/valyala/fasthttp"
+)
+
+// sink stores generated objects. Thread returns the thread as stored, with
+// its id, and whether it was created by this call; Posts takes posts with
+// thread-local ids and parents.
+type sink interface {
+	User(user *models.User) error
+	Forum(forum *models.Forum) error
+	Thread(thread *models.Thread) (*models.Thread, bool, error)
+	Posts(thread *models.Thread, posts []models.Post) error
+	Vote(thread *models.Thread, vote *models.Vote) error
+}
+
+func postIterator(posts []models.Post) func() (*models.Post, error) {
+	i := 0
+	return func() (*models.Post, error) {
+		if i == len(posts) {
+			return nil, io.EOF
+		}
+		i++
+		return &posts[i-1], nil
+	}
+}
+
+type dbSink struct{}
+
+func (dbSink) User(user *models.User) error {
+	if _, err := database.CreateUser(context.Background(), user); err != nil && err != database.ErrDuplicate {
+		return err
+	}
+	return nil
+}
+
+func (dbSink) Forum(forum *models.Forum) error {
+	if _, err := database.CreateForum(context.Background(), forum); err != nil && err != database.ErrDuplicate {
+		return err
+	}
+	return nil
+}
+
+func (dbSink) Thread(thread *models.Thread) (*models.Thread, bool, error) {
+	newThread, err := database.CreateThread(context.Background(), thread)
+	if err == database.ErrDuplicate {
+		return newThread, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return newThread, true, nil
+}
+
+func (dbSink) Posts(thread *models.Thread, posts []models.Post) error {
+	_, err := database.ImportPosts(context.Background(), thread, postIterator(posts))
+	return err
+}
+
+func (dbSink) Vote(thread *models.Thread, vote *models.Vote) error {
+	v := *vote
+	v.ThreadId = thread.ID
+	_, err := database.VoteThread(context.Background(), &v)
+	return err
+}
+
+type httpSink struct {
+	client *fasthttp.Client
+	target string
+	bulk   string
+	token  string
+}
+
+func (s *httpSink) do(uri string, body []byte, out json.Unmarshaler, ok ...int) (int, error) {
+	return s.doAt(s.target, uri, body, out, ok...)
+}
+
+func (s *httpSink) doAt(target string, uri string, body []byte, out json.Unmarshaler, ok ...int) (int, error) {
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+	req.Header.SetMethod("POST")
+	req.Header.SetContentType("application/json")
+	req.SetRequestURI("http://" + target + uri)
+	req.SetBody(body)
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	if err := s.client.Do(req, resp); err != nil {
+		return 0, errors.Wrap(err, "POST "+uri)
+	}
+	for _, status := range ok {
+		if resp.StatusCode() == status {
+			if out != nil {
+				return status, errors.Wrap(out.UnmarshalJSON(resp.Body()), "POST "+uri)
+			}
+			return status, nil
+		}
+	}
+	return 0, errors.Errorf("POST %s: status %d: %s", uri, resp.StatusCode(), bytes.TrimSpace(resp.Body()))
+}
+
+func (s *httpSink) User(user *models.User) error {
+	body, err := user.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	_, err = s.do("/api/user/"+user.Nickname+"/create", body, nil, http.StatusCreated, http.StatusConflict)
+	return err
+}
+
+func (s *httpSink) Forum(forum *models.Forum) error {
+	body, err := forum.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	_, err = s.do("/api/forum/create", body, nil, http.StatusCreated, http.StatusConflict)
+	return err
+}
+
+func (s *httpSink) Thread(thread *models.Thread) (*models.Thread, bool, error) {
+	body, err := thread.MarshalJSON()
+	if err != nil {
+		return nil, false, err
+	}
+	var newThread models.Thread
+	status, err := s.do("/api/forum/"+thread.Forum+"/create", body, &newThread, http.StatusCreated, http.StatusConflict)
+	if err != nil {
+		return nil, false, err
+	}
+	return &newThread, status == http.StatusCreated, nil
+}
+
+func (s *httpSink) Posts(thread *models.Thread, posts []models.Post) error {
+	var buf bytes.Buffer
+	for i := range posts {
+		line, err := posts[i].MarshalJSON()
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	_, err := s.doAt(s.bulk, "/api/thread/"+strconv.Itoa(int(thread.ID))+"/import", buf.Bytes(), nil, http.StatusCreated)
+	return err
+}
+
+func (s *httpSink) Vote(thread *models.Thread, vote *models.Vote) error {
+	body, err := vote.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	_, err = s.do("/api/thread/"+strconv.Itoa(int(thread.ID))+"/vote", body, nil, http.StatusOK)
+	return err
+}
@@ -0,0 +1,280 @@
+// Package config loads the configuration of bd-forum-server from defaults,
+// a YAML or TOML file, FORUM_* environment variables and command line
+// flags, each overriding the previous ones.
+//
+// Every option has one key, such as db.max_open_conns. In files it is
+// written as a nested or dotted key, in the environment as
+// FORUM_DB_MAX_OPEN_CONNS and on the command line as -db-max-open-conns.
+package config
+
+import (
+	"flag"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"db-forum/logger"
+
+	"github.com/pkg/errors"
+)
+
+type Config struct {
+	Mode            string
+	Listen          string
+	MaxBodySize     int
+	RequestTimeout  time.Duration
+	ShutdownTimeout time.Duration
+	DrainDelay      time.Duration
+	AdminToken      string
+
+	BulkListen      string
+	BulkMaxBodySize int64
+
+	DB DB
+
+	CacheSize          int
+	CacheTTL           time.Duration
+	IdempotencyWindow  time.Duration
+	IdempotencyPending time.Duration
+
+	RatePosts   string
+	RateThreads string
+	RateVotes   string
+	RateUsers   string
+
+	RouteTimeouts string
+	RouteCache    string
+
+	LogLevel string
+	LogJSON  bool
+
+	RecordDir      string
+	RecordSample   float64
+	RecordMaxSize  int64
+	RecordMaxFiles int
+	RecordMaxBody  int
+}
+
+type DB struct {
+	DSN              string
+	ReplicaDSN       string
+	Password         string
+	MaxOpenConns     int
+	MaxIdleConns     int
+	ConnMaxLifetime  time.Duration
+	StatementTimeout time.Duration
+}
+
+// secrets are the options hidden by Print.
+var secrets = map[string]bool{
+	"admin-token": true,
+	"db-password": true,
+}
+
+// bind registers the options of c on fs with their defaults.
+func (c *Config) bind(fs *flag.FlagSet) {
+	fs.StringVar(&c.Mode, "mode", "fasthttp", "server to run: fasthttp or swagger (the generated go-swagger server)")
+	fs.StringVar(&c.Listen, "listen", ":5000", "address to listen on")
+	fs.IntVar(&c.MaxBodySize, "max-body-size", 4<<20, "max request body size in bytes")
+	fs.DurationVar(&c.RequestTimeout, "request-timeout", 5*time.Second, "deadline of requests to routes without their own")
+	fs.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", 30*time.Second, "how long requests in flight may run after SIGINT or SIGTERM")
+	fs.DurationVar(&c.DrainDelay, "drain-delay", 5*time.Second, "how long /readyz fails before the listener closes on shutdown, so load balancers stop routing here first")
+	fs.StringVar(&c.AdminToken, "admin-token", "", "bearer token for /api/admin endpoints (empty: localhost only)")
+
+	fs.StringVar(&c.BulkListen, "bulk-listen", "", "address of the import endpoints with streamed bodies (empty: imports go through -listen, bounded by -max-body-size)")
+	fs.Int64Var(&c.BulkMaxBodySize, "bulk-max-body-size", 512<<20, "max request body size in bytes on -bulk-listen")
+
+	fs.StringVar(&c.DB.DSN, "db-dsn", "user=docker dbname=docker sslmode=disable", "DSN of the database, better without the password")
+	fs.StringVar(&c.DB.ReplicaDSN, "db-replica-dsn", "", "DSN of a read replica for list queries (empty: read from the primary)")
+	fs.StringVar(&c.DB.Password, "db-password", "", "database password, added to the DSNs")
+	fs.IntVar(&c.DB.MaxOpenConns, "db-max-open-conns", 0, "max open connections (0: unlimited)")
+	fs.IntVar(&c.DB.MaxIdleConns, "db-max-idle-conns", 2, "max idle connections kept in the pool")
+	fs.DurationVar(&c.DB.ConnMaxLifetime, "db-conn-max-lifetime", 0, "how long a connection is reused (0: forever)")
+	fs.DurationVar(&c.DB.StatementTimeout, "db-statement-timeout", 0, "Postgres statement_timeout (0: a second over the longest request deadline, negative: not set)")
+
+	fs.IntVar(&c.CacheSize, "cache-size", 10000, "max entries in each of the user, forum and thread caches (0 disables caching)")
+	fs.DurationVar(&c.CacheTTL, "cache-ttl", time.Second, "how long cached users, forums and threads are served before they are reloaded")
+	fs.DurationVar(&c.IdempotencyWindow, "idempotency-window", 24*time.Hour, "how long responses to requests with Idempotency-Key are replayed (0 disables)")
+	fs.DurationVar(&c.IdempotencyPending, "idempotency-pending", time.Minute, "how long a key stays claimed by a request that never answered")
+
+	fs.StringVar(&c.RatePosts, "rate-posts", "", "post creation requests a client may make, as N/s, N/m or N/h with bursts of N (empty: unlimited)")
+	fs.StringVar(&c.RateThreads, "rate-threads", "", "forum and thread creation requests a client may make, as -rate-posts")
+	fs.StringVar(&c.RateVotes, "rate-votes", "", "votes a client may cast, as -rate-posts")
+	fs.StringVar(&c.RateUsers, "rate-users", "", "users a client may create, as -rate-posts")
+
+	fs.StringVar(&c.RouteTimeouts, "route-timeouts", "", "request deadlines of routes, as pattern=duration pairs separated by ; (0s: no deadline)")
+	fs.StringVar(&c.RouteCache, "route-cache", "", "Cache-Control policies of GET routes, as pattern=policy pairs separated by ;")
+
+	fs.StringVar(&c.LogLevel, "log-level", "info", "minimal log level: debug, info, warn or error")
+	fs.BoolVar(&c.LogJSON, "log-json", false, "write log lines as JSON")
+
+	fs.StringVar(&c.RecordDir, "record-dir", "", "directory for recorded request logs (empty disables recording)")
+	fs.Float64Var(&c.RecordSample, "record-sample", 1, "share of requests to record, from 0 to 1")
+	fs.Int64Var(&c.RecordMaxSize, "record-max-size", 64<<20, "size in bytes after which the request log is rotated")
+	fs.IntVar(&c.RecordMaxFiles, "record-max-files", 10, "number of request log files to keep (0 keeps all)")
+	fs.IntVar(&c.RecordMaxBody, "record-max-body", 64<<10, "size in bytes to which recorded request and response bodies are cut (0 keeps them whole)")
+}
+
+// Default returns the configuration used when nothing is set.
+func Default() *Config {
+	var c Config
+	c.bind(flag.NewFlagSet("", flag.ContinueOnError))
+	return &c
+}
+
+// DSN returns the database DSN with the password added.
+func (c *Config) DSN() string {
+	return withPassword(c.DB.DSN, c.DB.Password)
+}
+
+// ReplicaDSN returns the replica DSN with the password added, or "".
+func (c *Config) ReplicaDSN() string {
+	if c.DB.ReplicaDSN == "" {
+		return ""
+	}
+	return withPassword(c.DB.ReplicaDSN, c.DB.Password)
+}
+
+func withPassword(dsn string, password string) string {
+	if password == "" {
+		return dsn
+	}
+	if isURL(dsn) {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return dsn
+		}
+		user := ""
+		if u.User != nil {
+			user = u.User.Username()
+		}
+		u.User = url.UserPassword(user, password)
+		return u.String()
+	}
+	quoted := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(password)
+	return dsn + " password='" + quoted + "'"
+}
+
+func isURL(dsn string) bool {
+	return strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://")
+}
+
+// Validate returns an error listing every invalid option.
+func (c *Config) Validate() error {
+	var problems []string
+	check := func(ok bool, problem string) {
+		if !ok {
+			problems = append(problems, problem)
+		}
+	}
+	check(c.Mode == "fasthttp" || c.Mode == "swagger", "mode must be fasthttp or swagger")
+	_, _, err := net.SplitHostPort(c.Listen)
+	check(err == nil, "listen must be host:port")
+	check(c.MaxBodySize > 0, "max_body_size must be positive")
+	check(c.RequestTimeout > 0, "request_timeout must be positive")
+	check(c.ShutdownTimeout >= 0, "shutdown_timeout can't be negative")
+	check(c.DrainDelay >= 0, "drain_delay can't be negative")
+	if c.BulkListen != "" {
+		_, _, err := net.SplitHostPort(c.BulkListen)
+		check(err == nil, "bulk.listen must be host:port")
+	}
+	check(c.BulkMaxBodySize > 0, "bulk.max_body_size must be positive")
+
+	check(c.DB.DSN != "", "db.dsn is required")
+	if isURL(c.DB.DSN) {
+		_, err := url.Parse(c.DB.DSN)
+		check(err == nil, "db.dsn is not a valid URL")
+	}
+	if isURL(c.DB.ReplicaDSN) {
+		_, err := url.Parse(c.DB.ReplicaDSN)
+		check(err == nil, "db.replica_dsn is not a valid URL")
+	}
+	check(c.DB.MaxOpenConns >= 0, "db.max_open_conns can't be negative")
+	check(c.DB.MaxIdleConns >= 0, "db.max_idle_conns can't be negative")
+	check(c.DB.MaxOpenConns == 0 || c.DB.MaxIdleConns <= c.DB.MaxOpenConns, "db.max_idle_conns can't exceed db.max_open_conns")
+	check(c.DB.ConnMaxLifetime >= 0, "db.conn_max_lifetime can't be negative")
+
+	check(c.CacheSize >= 0, "cache.size can't be negative")
+	check(c.CacheTTL > 0, "cache.ttl must be positive")
+	check(c.IdempotencyWindow >= 0, "idempotency.window can't be negative")
+	check(c.IdempotencyPending > 0, "idempotency.pending must be positive")
+
+	for key, rate := range map[string]string{"posts": c.RatePosts, "threads": c.RateThreads, "votes": c.RateVotes, "users": c.RateUsers} {
+		_, _, err := ParseRate(rate)
+		check(err == nil, "rate."+key+" must be N/s, N/m or N/h")
+	}
+
+	_, err = ParseRouteTimeouts(c.RouteTimeouts)
+	check(err == nil, "route.timeouts must be pattern=duration pairs with durations not negative")
+	_, err = ParseRoutes(c.RouteCache)
+	check(err == nil, "route.cache must be pattern=policy pairs")
+
+	_, err = logger.ParseLevel(c.LogLevel)
+	check(err == nil, "log.level must be debug, info, warn or error")
+
+	check(c.RecordSample >= 0 && c.RecordSample <= 1, "record.sample must be within [0, 1]")
+	check(c.RecordMaxSize >= 0, "record.max_size can't be negative")
+	check(c.RecordMaxFiles >= 0, "record.max_files can't be negative")
+	check(c.RecordMaxBody >= 0, "record.max_body can't be negative")
+
+	if len(problems) != 0 {
+		return errors.New("invalid config: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+var ratePeriods = map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour}
+
+// ParseRate parses a rate limit written as N/s, N/m or N/h: N requests at
+// once and N each second, minute or hour after. "" is no limit, n = 0.
+func ParseRate(rate string) (n int, per time.Duration, err error) {
+	if rate == "" {
+		return 0, 0, nil
+	}
+	parts := strings.SplitN(rate, "/", 2)
+	if len(parts) == 2 {
+		per = ratePeriods[parts[1]]
+		n, err = strconv.Atoi(parts[0])
+	}
+	if per == 0 || err != nil || n <= 0 {
+		return 0, 0, errors.Errorf("bad rate %q", rate)
+	}
+	return n, per, nil
+}
+
+// ParseRoutes parses per-route settings written as pattern=value pairs
+// separated by semicolons, such as "/metrics=no-store; /healthz=no-store".
+func ParseRoutes(s string) (map[string]string, error) {
+	routes := make(map[string]string)
+	for _, pair := range strings.Split(s, ";") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		i := strings.IndexByte(pair, '=')
+		if i < 0 || !strings.HasPrefix(pair, "/") {
+			return nil, errors.Errorf("bad route setting %q", pair)
+		}
+		routes[strings.TrimSpace(pair[:i])] = strings.TrimSpace(pair[i+1:])
+	}
+	return routes, nil
+}
+
+// ParseRouteTimeouts parses per-route deadlines as ParseRoutes does.
+func ParseRouteTimeouts(s string) (map[string]time.Duration, error) {
+	routes, err := ParseRoutes(s)
+	if err != nil {
+		return nil, err
+	}
+	timeouts := make(map[string]time.Duration, len(routes))
+	for path, value := range routes {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout < 0 {
+			return nil, errors.Errorf("bad timeout %q of route %s", value, path)
+		}
+		timeouts[path] = timeout
+	}
+	return timeouts, nil
+}
@@ -0,0 +1,157 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const envPrefix = "FORUM_"
+
+// sections group options in files: db.dsn is the -db-dsn flag.
+var sections = []string{"bulk", "cache", "db", "idempotency", "log", "rate", "record", "route"}
+
+// flagName returns the flag of a file key or environment variable name.
+func flagName(key string) string {
+	return strings.NewReplacer(".", "-", "_", "-").Replace(strings.ToLower(key))
+}
+
+// keyName returns the file key of a flag.
+func keyName(name string) string {
+	for _, section := range sections {
+		if strings.HasPrefix(name, section+"-") {
+			return section + "." + strings.Replace(name[len(section)+1:], "-", "_", -1)
+		}
+	}
+	return strings.Replace(name, "-", "_", -1)
+}
+
+// Load builds the configuration from the config file, the environment and
+// the flags in args, and returns it with the arguments left after flags.
+// The file is named by the -config flag or FORUM_CONFIG.
+func Load(name string, args []string) (*Config, []string, error) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	var c Config
+	c.bind(fs)
+	path := fs.String("config", os.Getenv(envPrefix+"CONFIG"), "YAML or TOML config file")
+	fs.Parse(args)
+	rest := fs.Args()
+	explicit := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name != "config" {
+			explicit[f.Name] = f.Value.String()
+		}
+	})
+
+	c = Config{}
+	fs = flag.NewFlagSet(name, flag.ContinueOnError)
+	c.bind(fs)
+	if *path != "" {
+		values, err := readFile(*path)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := apply(fs, values, "in "+*path); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := apply(fs, environ(fs), "in environment"); err != nil {
+		return nil, nil, err
+	}
+	if err := apply(fs, explicit, "in flags"); err != nil {
+		return nil, nil, err
+	}
+	return &c, rest, nil
+}
+
+// apply sets options from values keyed by file keys or flag names.
+func apply(fs *flag.FlagSet, values map[string]string, source string) error {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		f := fs.Lookup(flagName(key))
+		if f == nil {
+			return errors.Errorf("unknown option %s %s", key, source)
+		}
+		if err := f.Value.Set(values[key]); err != nil {
+			return errors.Errorf("bad value %q of %s %s", values[key], key, source)
+		}
+	}
+	return nil
+}
+
+// environ returns the options of fs set in the environment. Variables
+// with the prefix that name no option may belong to something else in
+// the same container, so they are only warned about.
+func environ(fs *flag.FlagSet) map[string]string {
+	values := make(map[string]string)
+	for _, kv := range os.Environ() {
+		i := strings.IndexByte(kv, '=')
+		if i < 0 || !strings.HasPrefix(kv, envPrefix) || kv[:i] == envPrefix+"CONFIG" {
+			continue
+		}
+		key := kv[len(envPrefix):i]
+		if fs.Lookup(flagName(key)) == nil {
+			log.Printf("ignoring %s: no such option", kv[:i])
+			continue
+		}
+		values[key] = kv[i+1:]
+	}
+	return values
+}
+
+func readFile(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't read config")
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return parseYAML(data)
+	case ".toml":
+		return parseTOML(data)
+	}
+	return nil, errors.Errorf("config %s must be .yaml, .yml or .toml", path)
+}
+
+func parseYAML(data []byte) (map[string]string, error) {
+	var doc map[interface{}]interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, errors.Wrap(err, "can't parse YAML config")
+	}
+	values := make(map[string]string)
+	if err := flatten("", doc, values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func flatten(prefix string, doc map[interface{}]interface{}, values map[string]string) error {
+	for k, v := range doc {
+		key := prefix + fmt.Sprint(k)
+		switch v := v.(type) {
+		case map[interface{}]interface{}:
+			if err := flatten(key+".", v, values); err != nil {
+				return err
+			}
+		case []interface{}:
+			return errors.Errorf("option %s can't be a list", key)
+		case nil:
+			values[key] = ""
+		default:
+			values[key] = fmt.Sprint(v)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const redacted = "REDACTED"
+
+var dsnPassword = regexp.MustCompile(`(password\s*=\s*)('(\\.|[^'])*'|\S+)`)
+
+// redactDSN hides the password of a key=value or URL DSN.
+func redactDSN(dsn string) string {
+	if isURL(dsn) {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return redacted
+		}
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), redacted)
+		}
+		query := u.Query()
+		if query.Get("password") != "" {
+			query.Set("password", redacted)
+			u.RawQuery = query.Encode()
+		}
+		return u.String()
+	}
+	return dsnPassword.ReplaceAllString(dsn, "${1}"+redacted)
+}
+
+// Print writes c as a TOML config file with secrets redacted.
+func (c *Config) Print(w io.Writer) error {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	var printed Config
+	printed.bind(fs)
+	printed = *c
+
+	tables := make(map[string][]string)
+	var order []string
+	fs.VisitAll(func(f *flag.Flag) {
+		key := keyName(f.Name)
+		table := ""
+		if i := strings.IndexByte(key, '.'); i >= 0 {
+			table, key = key[:i], key[i+1:]
+		}
+		if _, ok := tables[table]; !ok {
+			order = append(order, table)
+		}
+		value := f.Value.String()
+		switch {
+		case secrets[f.Name] && value != "":
+			value = redacted
+		case f.Name == "db-dsn" || f.Name == "db-replica-dsn":
+			value = redactDSN(value)
+		}
+		tables[table] = append(tables[table], key+" = "+tomlLiteral(f.Value, value))
+	})
+	for _, line := range tables[""] {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	for _, table := range order {
+		if table == "" {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "\n[%s]\n", table); err != nil {
+			return err
+		}
+		for _, line := range tables[table] {
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func tomlLiteral(v flag.Value, s string) string {
+	switch v.(flag.Getter).Get().(type) {
+	case bool, int, int64, float64:
+		return s
+	}
+	return strconv.Quote(s)
+}
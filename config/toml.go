@@ -0,0 +1,75 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// parseTOML reads the subset of TOML used by config files: [section]
+// tables and key = value pairs with strings, numbers and booleans.
+func parseTOML(data []byte) (map[string]string, error) {
+	values := make(map[string]string)
+	prefix := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") || strings.HasPrefix(line, "[[") {
+				return nil, errors.Errorf("bad table header on line %d of TOML config", n)
+			}
+			prefix = strings.TrimSpace(line[1:len(line)-1]) + "."
+			continue
+		}
+		i := strings.IndexByte(line, '=')
+		if i < 0 {
+			return nil, errors.Errorf("expected key = value on line %d of TOML config", n)
+		}
+		key := strings.TrimSpace(line[:i])
+		value, err := tomlValue(strings.TrimSpace(line[i+1:]))
+		if key == "" || err != nil {
+			return nil, errors.Errorf("bad value on line %d of TOML config", n)
+		}
+		values[prefix+key] = value
+	}
+	return values, errors.Wrap(scanner.Err(), "can't read TOML config")
+}
+
+func tomlValue(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		return strconv.Unquote(s)
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") || strings.Contains(s[1:len(s)-1], "'") {
+			return "", errors.New("bad literal string")
+		}
+		return s[1 : len(s)-1], nil
+	case strings.HasPrefix(s, "[") || strings.HasPrefix(s, "{"):
+		return "", errors.New("arrays and inline tables are not supported")
+	}
+	return strings.Replace(s, "_", "", -1), nil
+}
+
+// stripComment cuts a # comment that is not inside a string.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote != 0 && c == '\\' && quote == '"':
+			i++
+		case c == quote:
+			quote = 0
+		case quote == 0 && c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
@@ -0,0 +1,250 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"db-forum/models"
+
+	"github.com/pkg/errors"
+)
+
+var exportUsers = `SELECT u.nickname, u.fullname, u.about, u.email FROM users u
+	WHERE EXISTS (SELECT 1 FROM forum_users fu WHERE fu.forum = $1 AND fu.nickname = u.nickname)
+	OR EXISTS (SELECT 1 FROM forum f WHERE f.slug = $1 AND f.author = u.nickname)
+	OR EXISTS (SELECT 1 FROM voice v JOIN thread t ON t.id = v.thread_id WHERE t.forum = $1 AND v.nickname = u.nickname)
+	ORDER BY u.nickname;`
+var exportThreads = `SELECT id, title, author, forum, message, votes, created, slug FROM thread WHERE forum = $1 ORDER BY id;`
+var exportPosts = `SELECT id, parent, author, message, is_edited, forum, thread, created FROM post
+	WHERE forum = $1 ORDER BY thread, id;`
+var exportVotes = `SELECT v.nickname, v.vote, v.thread_id FROM voice v JOIN thread t ON t.id = v.thread_id
+	WHERE t.forum = $1 ORDER BY v.thread_id, v.nickname;`
+
+// ExportForum passes the forum with its users, threads, posts (grouped by
+// thread) and votes to emit, in that order, from a single snapshot.
+func ExportForum(ctx context.Context, slug string, emit func(interface{}) error) error {
+	tx, err := startBulk(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var forum models.Forum
+	if err := tx.QueryRowContext(ctx, getForum, slug).Scan(&forum.Title, &forum.User, &forum.Slug, &forum.Posts, &forum.Threads); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		return errors.Wrap(err, "can't select from forum")
+	}
+	if err := exportRows(ctx, tx, exportUsers, forum.Slug, func(rows *sql.Rows) (interface{}, error) {
+		var user models.User
+		err := rows.Scan(&user.Nickname, &user.Fullname, &user.About, &user.Email)
+		return &user, err
+	}, emit); err != nil {
+		return err
+	}
+	if err := emit(&forum); err != nil {
+		return err
+	}
+	if err := exportRows(ctx, tx, exportThreads, forum.Slug, func(rows *sql.Rows) (interface{}, error) {
+		var thread models.Thread
+		err := rows.Scan(&thread.ID, &thread.Title, &thread.Author, &thread.Forum, &thread.Message, &thread.Votes, &thread.Created, &thread.Slug)
+		return &thread, err
+	}, emit); err != nil {
+		return err
+	}
+	if err := exportRows(ctx, tx, exportPosts, forum.Slug, func(rows *sql.Rows) (interface{}, error) {
+		var post models.Post
+		err := rows.Scan(&post.ID, &post.Parent, &post.Author, &post.Message, &post.IsEdited, &post.Forum, &post.Thread, &post.Created)
+		return &post, err
+	}, emit); err != nil {
+		return err
+	}
+	return exportRows(ctx, tx, exportVotes, forum.Slug, func(rows *sql.Rows) (interface{}, error) {
+		var vote models.Vote
+		err := rows.Scan(&vote.Nickname, &vote.Voice, &vote.ThreadId)
+		return &vote, err
+	}, emit)
+}
+
+func exportRows(ctx context.Context, tx *sql.Tx, query string, slug string, scan func(*sql.Rows) (interface{}, error), emit func(interface{}) error) error {
+	rows, err := tx.QueryContext(ctx, query, slug)
+	if err != nil {
+		return errors.Wrap(err, "can't select rows for export")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		value, err := scan(rows)
+		if err != nil {
+			return errors.Wrap(err, "can't scan rows")
+		}
+		if err := emit(value); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, "can't select rows for export")
+	}
+	return nil
+}
+
+// ForumImport loads one forum with its users, threads, posts and votes in
+// a single transaction. Threads and posts get new ids; references to them
+// use the ids of the source.
+type ForumImport struct {
+	ctx     context.Context
+	tx      *sql.Tx
+	forum   *models.Forum
+	threads map[int32]*models.Thread
+}
+
+func BeginForumImport(ctx context.Context) (*ForumImport, error) {
+	markWrite(ctx)
+	tx, err := startBulk(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &ForumImport{ctx: ctx, tx: tx, threads: make(map[int32]*models.Thread)}, nil
+}
+
+var userExists = `SELECT 1 FROM users WHERE nickname = $1;`
+
+// AddUser creates the user unless a user with the same nickname exists.
+// It returns false for existing users and ErrDuplicate when the email
+// belongs to another user.
+func (im *ForumImport) AddUser(user *models.User) (bool, error) {
+	res, err := im.tx.ExecContext(im.ctx, createUser, user.Nickname, user.Fullname, user.About, user.Email)
+	if err != nil {
+		return false, errors.Wrap(err, "can't insert into users")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, "can't get affected rows")
+	}
+	if ra != 0 {
+		return true, nil
+	}
+	if err := im.checkUser(user.Nickname); err != nil {
+		if err == ErrNotFound {
+			return false, ErrDuplicate
+		}
+		return false, err
+	}
+	return false, nil
+}
+
+func (im *ForumImport) checkUser(nickname string) error {
+	var one int
+	if err := im.tx.QueryRowContext(im.ctx, userExists, nickname).Scan(&one); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		return errors.Wrap(err, "can't select from users")
+	}
+	return nil
+}
+
+var forumExists = `SELECT 1 FROM forum WHERE slug = $1;`
+
+// AddForum creates the forum; it gives ErrDuplicate when the slug is taken.
+func (im *ForumImport) AddForum(forum *models.Forum) error {
+	if im.forum != nil {
+		return ErrConflict
+	}
+	if err := im.checkUser(forum.User); err != nil {
+		return err
+	}
+	var one int
+	err := im.tx.QueryRowContext(im.ctx, forumExists, forum.Slug).Scan(&one)
+	if err == nil {
+		return ErrDuplicate
+	}
+	if err != sql.ErrNoRows {
+		return errors.Wrap(err, "can't select from forum")
+	}
+	if _, err := im.tx.ExecContext(im.ctx, createForum, forum.Title, forum.User, forum.Slug); err != nil {
+		return errors.Wrap(err, "can't insert into forum")
+	}
+	if _, err := im.tx.ExecContext(im.ctx, addForumUser, forum.Slug, forum.User); err != nil {
+		return errors.Wrap(err, "can't insert into forum_users")
+	}
+	im.forum = &models.Forum{Title: forum.Title, User: forum.User, Slug: forum.Slug}
+	return nil
+}
+
+var threadExists = `SELECT 1 FROM thread WHERE slug = $1;`
+
+// AddThread creates the thread in the imported forum.
+func (im *ForumImport) AddThread(thread *models.Thread) error {
+	if im.forum == nil {
+		return ErrConflict
+	}
+	if _, ok := im.threads[thread.ID]; ok {
+		return ErrConflict
+	}
+	if err := im.checkUser(thread.Author); err != nil {
+		return err
+	}
+	if thread.Slug != "" {
+		var one int
+		err := im.tx.QueryRowContext(im.ctx, threadExists, thread.Slug).Scan(&one)
+		if err == nil {
+			return ErrDuplicate
+		}
+		if err != sql.ErrNoRows {
+			return errors.Wrap(err, "can't select from thread")
+		}
+	}
+	newThread := *thread
+	newThread.Forum = im.forum.Slug
+	if err := im.tx.QueryRowContext(im.ctx, createThread, thread.Title, thread.Author, newThread.Forum, thread.Message, thread.Created, thread.Slug).Scan(&newThread.Slug, &newThread.ID); err != nil {
+		return errors.Wrap(err, "can't insert into thread")
+	}
+	if _, err := im.tx.ExecContext(im.ctx, updateForumCount, newThread.Forum); err != nil {
+		return errors.Wrap(err, "can't update forum")
+	}
+	if _, err := im.tx.ExecContext(im.ctx, addForumUser, newThread.Forum, thread.Author); err != nil {
+		return errors.Wrap(err, "can't insert into forum_users")
+	}
+	im.threads[thread.ID] = &newThread
+	return nil
+}
+
+// AddPosts loads posts of the source thread, see ImportPosts. All posts
+// of a thread have to come in one call.
+func (im *ForumImport) AddPosts(thread int32, next func() (*models.Post, error)) (int64, error) {
+	newThread, ok := im.threads[thread]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	return importPosts(im.ctx, im.tx, newThread, next)
+}
+
+// AddVote records the vote in the imported thread.
+func (im *ForumImport) AddVote(vote *models.Vote) error {
+	thread, ok := im.threads[vote.ThreadId]
+	if !ok {
+		return ErrNotFound
+	}
+	if _, err := im.tx.ExecContext(im.ctx, createVoteThread, vote.Nickname, vote.Voice, thread.ID); err != nil {
+		return errors.Wrap(err, "can't insert into voice")
+	}
+	return nil
+}
+
+func (im *ForumImport) Commit() error {
+	for _, thread := range im.threads {
+		if _, err := im.tx.ExecContext(im.ctx, recountThreadVotes, thread.ID); err != nil {
+			return errors.Wrap(err, "can't update thread")
+		}
+	}
+	if err := im.tx.Commit(); err != nil {
+		return errors.Wrap(err, "can't commit transaction")
+	}
+	if im.forum != nil {
+		invalidateForums(im.forum.Slug)
+	}
+	return nil
+}
+
+func (im *ForumImport) Rollback() error {
+	return im.tx.Rollback()
+}
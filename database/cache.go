@@ -0,0 +1,103 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"db-forum/cache"
+)
+
+// Users and forums are keyed by lowercased nickname/slug (both columns are
+// CITEXT). Threads are stored by id, with slugs mapped to ids separately.
+// Votes, counters and profiles also change in other server instances and
+// through the import tools, which can't invalidate these caches, so entries
+// live for the TTL set by SetCacheTTL at most.
+var (
+	userCache   = cache.New(10000)
+	forumCache  = cache.New(1000)
+	threadCache = cache.New(10000)
+)
+
+func init() {
+	SetCacheTTL(time.Second)
+}
+
+func SetCacheSize(size int) {
+	userCache.Resize(size)
+	forumCache.Resize(size)
+	threadCache.Resize(size)
+}
+
+func SetCacheTTL(ttl time.Duration) {
+	userCache.SetTTL(ttl)
+	forumCache.SetTTL(ttl)
+	threadCache.SetTTL(ttl)
+}
+
+func CacheStats() map[string]cache.Stats {
+	return map[string]cache.Stats{
+		"users":   userCache.Stats(),
+		"forums":  forumCache.Stats(),
+		"threads": threadCache.Stats(),
+	}
+}
+
+func purgeCaches() {
+	userCache.Purge()
+	forumCache.Purge()
+	threadCache.Purge()
+}
+
+func invalidateUser(nickname string) {
+	userCache.Remove(strings.ToLower(nickname))
+}
+
+func invalidateForums(slugs ...string) {
+	keys := make([]string, 0, len(slugs))
+	for _, slug := range slugs {
+		keys = append(keys, strings.ToLower(slug))
+	}
+	forumCache.Remove(keys...)
+}
+
+func threadIDKey(id int32) string {
+	return "id:" + strconv.Itoa(int(id))
+}
+
+func threadSlugKey(slug string) string {
+	return "slug:" + strings.ToLower(slug)
+}
+
+func invalidateThreads(ids ...int32) {
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, threadIDKey(id))
+	}
+	threadCache.Remove(keys...)
+}
+
+func cachedThreadByID(id int32) (*ThreadRow, bool) {
+	cached, ok := threadCache.Get(threadIDKey(id))
+	if !ok {
+		return nil, false
+	}
+	thread := *cached.(*ThreadRow)
+	return &thread, true
+}
+
+func cachedThreadBySlug(slug string) (*ThreadRow, bool) {
+	id, ok := threadCache.Get(threadSlugKey(slug))
+	if !ok {
+		return nil, false
+	}
+	return cachedThreadByID(id.(int32))
+}
+
+func cacheThread(epoch uint64, thread *ThreadRow) {
+	cached := *thread
+	threadCache.AddSince(epoch, threadIDKey(thread.ID), &cached)
+	if thread.Slug != "" {
+		threadCache.AddSince(epoch, threadSlugKey(thread.Slug), thread.ID)
+	}
+}
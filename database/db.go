@@ -1,67 +1,132 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"db-forum/models"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
 )
 
 type DB struct {
-	pg *sql.DB
+	pg      *sql.DB
+	replica *sql.DB
 
-	CreateUserStmt        *sql.Stmt
-	GetUserStmt           *sql.Stmt
-	GetUserByUsernameStmt *sql.Stmt
-	UpdateUserStmt        *sql.Stmt
+	CreateUserStmt        *Stmt
+	GetUserStmt           *Stmt
+	GetUserByUsernameStmt *Stmt
+	UpdateUserStmt        *Stmt
 
-	CreateForumStmt             *sql.Stmt
-	GetForumStmt                *sql.Stmt
-	GetForumThreadsStmt         *sql.Stmt
-	GetForumThreadsWithTimeStmt *sql.Stmt
+	CreateForumStmt             *Stmt
+	GetForumStmt                *Stmt
+	GetForumThreadsStmt         *Stmt
+	GetForumThreadsWithTimeStmt *Stmt
 
-	CreateThreadStmt    *sql.Stmt
-	GetThreadStmt       *sql.Stmt
-	GetThreadByIDStmt   *sql.Stmt
-	GetThreadBySlugStmt *sql.Stmt
+	CreateThreadStmt    *Stmt
+	GetThreadStmt       *Stmt
+	GetThreadByIDStmt   *Stmt
+	GetThreadBySlugStmt *Stmt
 
-	CreatePostStmt  *sql.Stmt
-	GetPostByIDStmt *sql.Stmt
+	CreatePostStmt  *Stmt
+	GetPostByIDStmt *Stmt
 
-	GetPrevVoteThreadStmt *sql.Stmt
-	CreatVoteThreadStmt   *sql.Stmt
-	UpdateVoteThreadStmt  *sql.Stmt
-	BigInsert *sql.Stmt
+	GetPrevVoteThreadStmt *Stmt
+	CreatVoteThreadStmt   *Stmt
+	UpdateVoteThreadStmt  *Stmt
+	BigInsert             *Stmt
+
+	stmts []*Stmt
 }
 
 var (
 	db           *DB
 	ErrNotFound  = errors.New("not found")
 	ErrDuplicate = errors.New("duplicate")
+	ErrConflict  = errors.New("conflict")
+	ErrNoAuthor  = errors.New("author not found")
+
+	ErrPreconditionFailed = errors.New("precondition failed")
 )
 
 func InitDB(DSN string) error {
-	var err error
-	var newDB DB
-	newDB.pg, err = sql.Open("postgres", DSN)
-	if err != nil {
-		return errors.Wrap(err, "can't open database")
+	if err := OpenDB(DSN); err != nil {
+		return err
+	}
+	if _, err := db.pg.Exec(clearDB); err != nil {
+		return errors.Wrap(err, "can't clear db")
 	}
-	if err = newDB.pg.Ping(); err != nil {
-		return errors.Wrap(err, "can't connect to database")
+	return nil
+}
+
+// Pool holds the connection pool limits, see the sql.DB setters.
+type Pool struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+var pool = Pool{MaxIdleConns: 2}
+
+// SetPool sets the limits of pools opened afterwards.
+func SetPool(p Pool) {
+	pool = p
+}
+
+// OpenDB connects to the database and prepares statements without
+// touching existing data.
+func OpenDB(DSN string) error {
+	pg, err := openPool(DSN)
+	if err != nil {
+		return err
 	}
-	db = &newDB
+	db = &DB{pg: pg}
 	if err = initStmts(); err != nil {
 		return errors.Wrap(err, "can't prepare statements")
 	}
-	if _, err = db.pg.Exec(clearDB); err != nil {
-		return errors.Wrap(err, "can't clear db")
-	}
 	return nil
 }
 
+func openPool(DSN string) (*sql.DB, error) {
+	pg, err := sql.Open("postgres", DSN)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't open database")
+	}
+	pg.SetMaxOpenConns(pool.MaxOpenConns)
+	pg.SetMaxIdleConns(pool.MaxIdleConns)
+	pg.SetConnMaxLifetime(pool.ConnMaxLifetime)
+	if err = pg.Ping(); err != nil {
+		pg.Close()
+		return nil, errors.Wrap(err, "can't connect to database")
+	}
+	return pg, nil
+}
+
+// WithStatementTimeout sets the Postgres statement_timeout of the sessions
+// opened with DSN unless DSN sets it already.
+func WithStatementTimeout(DSN string, timeout time.Duration) string {
+	if strings.Contains(DSN, "statement_timeout") {
+		return DSN
+	}
+	ms := strconv.FormatInt(int64(timeout/time.Millisecond), 10)
+	if strings.HasPrefix(DSN, "postgres://") || strings.HasPrefix(DSN, "postgresql://") {
+		u, err := url.Parse(DSN)
+		if err != nil {
+			return DSN
+		}
+		query := u.Query()
+		query.Set("statement_timeout", ms)
+		u.RawQuery = query.Encode()
+		return u.String()
+	}
+	return DSN + " statement_timeout=" + ms
+}
+
 func GetDB() *DB {
 	return db
 }
@@ -71,49 +136,194 @@ func GetInstance() *sql.DB {
 }
 
 func initStmts() error {
-	var err error
-	prepare := make(map[string]**sql.Stmt)
-	prepare[createUser] = &db.CreateUserStmt
-	prepare[getUser] = &db.GetUserStmt
-	prepare[updateUser] = &db.UpdateUserStmt
-	prepare[getUserByUsername] = &db.GetUserByUsernameStmt
-
-	prepare[createForum] = &db.CreateForumStmt
-	prepare[getForum] = &db.GetForumStmt
-	prepare[getForumThreadsWithTime] = &db.GetForumThreadsWithTimeStmt
-	prepare[getForumThreads] = &db.GetForumThreadsStmt
-
-	prepare[createThread] = &db.CreateThreadStmt
-	prepare[getThread] = &db.GetThreadStmt
-	prepare[getThreadByID] = &db.GetThreadByIDStmt
-	prepare[getThreadBySlug] = &db.GetThreadBySlugStmt
-
-	prepare[createPost] = &db.CreatePostStmt
-	prepare[getPostByID] = &db.GetPostByIDStmt
-
-	prepare[bigInsert] = &db.BigInsert
-	prepare[updateVoteThread] = &db.UpdateVoteThreadStmt
-	prepare[createVoteThread] = &db.CreatVoteThreadStmt
-	for query, key := range prepare {
-		*key, err = db.pg.Prepare(query)
+	prepare := make(map[string]stmtRef)
+	prepare[createUser] = stmtRef{"create_user", &db.CreateUserStmt}
+	prepare[getUser] = stmtRef{"get_user", &db.GetUserStmt}
+	prepare[updateUser] = stmtRef{"update_user", &db.UpdateUserStmt}
+	prepare[getUserByUsername] = stmtRef{"get_user_by_username", &db.GetUserByUsernameStmt}
+
+	prepare[createForum] = stmtRef{"create_forum", &db.CreateForumStmt}
+	prepare[getForum] = stmtRef{"get_forum", &db.GetForumStmt}
+	prepare[getForumThreadsWithTime] = stmtRef{"get_forum_threads_with_time", &db.GetForumThreadsWithTimeStmt}
+	prepare[getForumThreads] = stmtRef{"get_forum_threads", &db.GetForumThreadsStmt}
+
+	prepare[createThread] = stmtRef{"create_thread", &db.CreateThreadStmt}
+	prepare[getThread] = stmtRef{"get_thread", &db.GetThreadStmt}
+	prepare[getThreadByID] = stmtRef{"get_thread_by_id", &db.GetThreadByIDStmt}
+	prepare[getThreadBySlug] = stmtRef{"get_thread_by_slug", &db.GetThreadBySlugStmt}
+
+	prepare[createPost] = stmtRef{"create_post", &db.CreatePostStmt}
+	prepare[getPostByID] = stmtRef{"get_post_by_id", &db.GetPostByIDStmt}
+
+	prepare[bigInsert] = stmtRef{"big_insert", &db.BigInsert}
+	prepare[updateVoteThread] = stmtRef{"update_vote_thread", &db.UpdateVoteThreadStmt}
+	prepare[createVoteThread] = stmtRef{"create_vote_thread", &db.CreatVoteThreadStmt}
+	for query, ref := range prepare {
+		stmt, err := db.pg.Prepare(query)
 		if err != nil {
 			return errors.Wrap(err, "can't prepare query "+query)
 		}
+		*ref.stmt = &Stmt{Stmt: stmt, name: ref.name}
+		db.stmts = append(db.stmts, *ref.stmt)
+	}
+	return nil
+}
+
+// Close closes the prepared statements and the connection pool.
+func Close() error {
+	if db == nil {
+		return nil
+	}
+	for _, stmt := range db.stmts {
+		stmt.Close()
+	}
+	if db.replica != nil {
+		db.replica.Close()
+	}
+	return errors.Wrap(db.pg.Close(), "can't close database")
+}
+
+// versionMismatch tells why an UPDATE guarded by version matched no row:
+// ErrNotFound when the row selected by exists with key is gone,
+// ErrPreconditionFailed when its version moved on.
+func versionMismatch(ctx context.Context, exists string, key interface{}, version int64) error {
+	if version == 0 {
+		return ErrNotFound
+	}
+	var one int
+	err := db.pg.QueryRowContext(ctx, exists, key).Scan(&one)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return errors.Wrap(err, "can't check row")
+	}
+	return ErrPreconditionFailed
+}
+
+// SchemaVersion is the version of sql/init.sql this code expects.
+const SchemaVersion = 2
+
+var getSchemaVersion = `SELECT version FROM schema_version;`
+
+// requiredColumns are the columns added by migrations in sql/init.sql,
+// as table and column names. Ready checks them directly rather than trust
+// schema_version alone.
+var requiredColumns = [][2]string{
+	{"users", "version"},
+	{"thread", "version"},
+	{"post", "version"},
+	{"thread", "slow_mode"},
+}
+
+var getMissingColumns = `SELECT r.t || '.' || r.c FROM unnest($1::TEXT[], $2::TEXT[]) AS r(t, c)
+	WHERE NOT EXISTS (SELECT 1 FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = r.t AND column_name = r.c);`
+
+// Ready checks that the database is reachable and has the expected schema.
+func Ready(ctx context.Context) error {
+	if err := db.pg.PingContext(ctx); err != nil {
+		return errors.Wrap(err, "can't reach database")
+	}
+	var version int
+	if err := db.pg.QueryRowContext(ctx, getSchemaVersion).Scan(&version); err != nil {
+		return errors.Wrap(err, "can't read schema version")
+	}
+	if version != SchemaVersion {
+		return errors.Errorf("schema version is %d, want %d", version, SchemaVersion)
+	}
+	if err := checkColumns(ctx); err != nil {
+		return err
+	}
+	if db.replica != nil {
+		if err := db.replica.PingContext(ctx); err != nil {
+			return errors.Wrap(err, "can't reach replica")
+		}
+	}
+	return nil
+}
+
+func checkColumns(ctx context.Context) error {
+	tables := make([]string, len(requiredColumns))
+	columns := make([]string, len(requiredColumns))
+	for i, c := range requiredColumns {
+		tables[i], columns[i] = c[0], c[1]
+	}
+	rows, err := db.pg.QueryContext(ctx, getMissingColumns, pq.Array(tables), pq.Array(columns))
+	if err != nil {
+		return errors.Wrap(err, "can't check columns")
+	}
+	defer rows.Close()
+	var missing []string
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return errors.Wrap(err, "can't check columns")
+		}
+		missing = append(missing, column)
+	}
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, "can't check columns")
+	}
+	if len(missing) != 0 {
+		return errors.Errorf("schema lacks columns %s, run sql/init.sql", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+var liftStatementTimeout = `SET LOCAL statement_timeout = 0;`
+
+// startBulk opens a transaction for bulk work such as exports and imports.
+// It is not subject to statement_timeout and is bounded by ctx alone.
+func startBulk(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	tx, err := db.pg.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't start transaction")
+	}
+	if _, err := tx.ExecContext(ctx, liftStatementTimeout); err != nil {
+		tx.Rollback()
+		return nil, errors.Wrap(err, "can't lift statement timeout")
 	}
-	return err
+	return tx, nil
 }
 
-func ClearTable() {
-	db.pg.Exec(clearDB)
+func ClearTable(ctx context.Context) {
+	markWrite(ctx)
+	db.pg.ExecContext(ctx, clearDB)
+	purgeCaches()
 }
 
-var clearDB = `DELETE FROM users; DELETE FROM forum; DELETE FROM thread; DELETE FROM post; DELETE FROM voice;`
+var clearDB = `DELETE FROM users; DELETE FROM forum; DELETE FROM thread; DELETE FROM post; DELETE FROM voice; DELETE FROM forum_users; DELETE FROM idempotency_key;`
 
-func GetStatus() *models.Status {
+func GetStatus(ctx context.Context) *models.Status {
 	var status models.Status
-	db.pg.QueryRow(`SELECT count(*) FROM users;`).Scan(&status.User)
-	db.pg.QueryRow(`SELECT count(*) FROM thread;`).Scan(&status.Thread)
-	db.pg.QueryRow(`SELECT count(*) FROM post;`).Scan(&status.Post)
-	db.pg.QueryRow(`SELECT count(*) FROM forum;`).Scan(&status.Forum)
+	reader(ctx).QueryRowContext(ctx, `SELECT count(*) FROM users;`).Scan(&status.User)
+	reader(ctx).QueryRowContext(ctx, `SELECT count(*) FROM thread;`).Scan(&status.Thread)
+	reader(ctx).QueryRowContext(ctx, `SELECT count(*) FROM post;`).Scan(&status.Post)
+	reader(ctx).QueryRowContext(ctx, `SELECT count(*) FROM forum;`).Scan(&status.Forum)
 	return &status
 }
+
+var estimateRows = `SELECT c.relname, greatest(c.reltuples, 0)::BIGINT FROM pg_class c
+	JOIN pg_namespace n ON n.oid = c.relnamespace
+	WHERE n.nspname = current_schema() AND c.relkind = 'r' AND c.relname IN ('forum', 'post', 'thread', 'users');`
+
+// EstimateRows returns the row counts of the forum, post, thread and users
+// tables as last estimated by VACUUM and ANALYZE, without scanning them.
+func EstimateRows(ctx context.Context) (map[string]int64, error) {
+	rows, err := reader(ctx).QueryContext(ctx, estimateRows)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't select from pg_class")
+	}
+	defer rows.Close()
+	counts := make(map[string]int64)
+	for rows.Next() {
+		var table string
+		var n int64
+		if err := rows.Scan(&table, &n); err != nil {
+			return nil, errors.Wrap(err, "can't scan pg_class")
+		}
+		counts[table] = n
+	}
+	return counts, errors.Wrap(rows.Err(), "can't select from pg_class")
+}
@@ -1,19 +1,22 @@
 package database
 
 import (
+	"context"
 	"db-forum/models"
 
 	"database/sql"
+	"strings"
 
 	"github.com/pkg/errors"
 )
 
 var createForum = `INSERT INTO forum (title, author, slug) VALUES ($1, $2, $3);`
 
-func CreateForum(forum *models.Forum) (*models.Forum, error) {
-	_, err := db.CreateForumStmt.Exec(forum.Title, forum.User, forum.Slug)
+func CreateForum(ctx context.Context, forum *models.Forum) (*models.Forum, error) {
+	markWrite(ctx)
+	_, err := db.CreateForumStmt.ExecContext(ctx, forum.Title, forum.User, forum.Slug)
 	if err != nil {
-		f, err := GetForum(forum.Slug)
+		f, err := GetForum(ctx, forum.Slug)
 		if err != nil {
 			if err == ErrNotFound {
 				return nil, errors.New("can't insert into db")
@@ -27,14 +30,22 @@ func CreateForum(forum *models.Forum) (*models.Forum, error) {
 
 var getForum = `SELECT title, author, slug, posts, threads FROM forum WHERE slug = $1 LIMIT 1;`
 
-func GetForum(slug string) (*models.Forum, error) {
+func GetForum(ctx context.Context, slug string) (*models.Forum, error) {
+	key := strings.ToLower(slug)
+	epoch := forumCache.Epoch()
+	if cached, ok := forumCache.Get(key); ok {
+		forum := *cached.(*models.Forum)
+		return &forum, nil
+	}
 	var forum models.Forum
-	if err := db.GetForumStmt.QueryRow(slug).Scan(&forum.Title, &forum.User, &forum.Slug, &forum.Posts, &forum.Threads); err != nil {
+	if err := db.GetForumStmt.QueryRowContext(ctx, slug).Scan(&forum.Title, &forum.User, &forum.Slug, &forum.Posts, &forum.Threads); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, ErrNotFound
 		}
 		return nil, errors.Wrap(err, "can't select from db")
 	}
+	cached := forum
+	forumCache.AddSince(epoch, key, &cached)
 	return &forum, nil
 }
 
@@ -64,8 +75,21 @@ var getForumThreads = `SELECT id, title, author, forum, message, votes, created,
 //	return &threads, nil
 //}
 
-func GetForumThreads(forum string, since string, order string, limit int) (*[]models.Thread, error) {
+func GetForumThreads(ctx context.Context, forum string, since string, order string, limit int) (*[]models.Thread, error) {
 	threads := make([]models.Thread, 0)
+	err := EachForumThread(ctx, forum, since, order, limit, func(thread *models.Thread) error {
+		threads = append(threads, *thread)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &threads, nil
+}
+
+// EachForumThread calls fn with the threads GetForumThreads returns as they
+// are read. fn must not keep the thread, which is reused for the next row.
+func EachForumThread(ctx context.Context, forum string, since string, order string, limit int, fn func(*models.Thread) error) error {
 	var rows *sql.Rows
 	var err error
 	query := `SELECT id, title, author, forum, message, votes, created, slug FROM thread WHERE forum = $1`
@@ -76,7 +100,7 @@ func GetForumThreads(forum string, since string, order string, limit int) (*[]mo
 		case "ASC":
 			query += " AND created >= $2 ORDER BY created LIMIT $3;"
 		}
-		rows, err = db.pg.Query(query, forum, since, limit)
+		rows, err = reader(ctx).QueryContext(ctx, query, forum, since, limit)
 	} else {
 		switch order {
 		case "DESC":
@@ -84,17 +108,60 @@ func GetForumThreads(forum string, since string, order string, limit int) (*[]mo
 		case "ASC":
 			query += " ORDER BY created LIMIT $2;"
 		}
-		rows, err = db.pg.Query(query, forum, limit)
+		rows, err = reader(ctx).QueryContext(ctx, query, forum, limit)
 	}
 	if err != nil {
-		return nil, errors.Wrap(err, "can't select from thread")
+		return errors.Wrap(err, "can't select from thread")
 	}
+	defer rows.Close()
+	var thread models.Thread
 	for rows.Next() {
-		var thread models.Thread
 		if err := rows.Scan(&thread.ID, &thread.Title, &thread.Author, &thread.Forum, &thread.Message, &thread.Votes, &thread.Created, &thread.Slug); err != nil {
-			return nil, errors.Wrap(err, "can't scan rows")
+			return errors.Wrap(err, "can't scan rows")
+		}
+		if err := fn(&thread); err != nil {
+			return err
 		}
-		threads = append(threads, thread)
 	}
-	return &threads, nil
+	return errors.Wrap(rows.Err(), "can't read threads")
+}
+
+var addForumUser = `INSERT INTO forum_users (forum, nickname) VALUES ($1, $2) ON CONFLICT DO NOTHING;`
+var addForumUsers = `INSERT INTO forum_users (forum, nickname) SELECT $1, unnest($2::CITEXT[]) ON CONFLICT DO NOTHING;`
+var addThreadForumUsers = `INSERT INTO forum_users (forum, nickname) SELECT DISTINCT forum, author FROM post 
+	WHERE thread = $1 AND author IS NOT NULL ON CONFLICT DO NOTHING;`
+var pruneForumUsers = `DELETE FROM forum_users fu WHERE fu.forum = $1 
+	AND NOT EXISTS (SELECT 1 FROM post WHERE post.forum = fu.forum AND post.author = fu.nickname) 
+	AND NOT EXISTS (SELECT 1 FROM thread WHERE thread.forum = fu.forum AND thread.author = fu.nickname);`
+
+// syncForumUsers records the post authors of thread as members of its forum
+// and drops members of from that have nothing left there.
+func syncForumUsers(ctx context.Context, tx *sql.Tx, thread int32, from string) error {
+	if _, err := tx.ExecContext(ctx, addThreadForumUsers, thread); err != nil {
+		return errors.Wrap(err, "can't insert into forum_users")
+	}
+	if _, err := tx.ExecContext(ctx, pruneForumUsers, from); err != nil {
+		return errors.Wrap(err, "can't delete from forum_users")
+	}
+	return nil
+}
+
+var backfillForumUsers = `INSERT INTO forum_users (forum, nickname) 
+	SELECT forum, author FROM post WHERE author IS NOT NULL 
+	UNION SELECT forum, author FROM thread 
+	ON CONFLICT DO NOTHING;`
+
+// BackfillForumUsers fills forum_users, created by sql/init.sql, from
+// existing threads and posts and returns the number of added rows.
+func BackfillForumUsers(ctx context.Context) (int64, error) {
+	markWrite(ctx)
+	res, err := db.pg.ExecContext(ctx, backfillForumUsers)
+	if err != nil {
+		return 0, errors.Wrap(err, "can't insert into forum_users")
+	}
+	added, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "can't get affected rows")
+	}
+	return added, nil
 }
@@ -0,0 +1,88 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type IdempotentResponse struct {
+	Fingerprint string
+	Pending     bool
+	Status      int
+	ContentType string
+	Body        []byte
+}
+
+// A stored response expires after the window, a claim still pending after
+// the pending TTL was left behind by a request that died.
+var expiredIdempotencyKeys = `(created < now() - $1::FLOAT8 * interval '1 second'
+	OR status IS NULL AND created < now() - $2::FLOAT8 * interval '1 second')`
+var expireIdempotencyKey = `DELETE FROM idempotency_key WHERE key = $3 AND ` + expiredIdempotencyKeys + `;`
+var sweepIdempotencyKeys = `DELETE FROM idempotency_key WHERE ` + expiredIdempotencyKeys + `;`
+var claimIdempotencyKey = `INSERT INTO idempotency_key (key, fingerprint) VALUES ($1, $2) ON CONFLICT DO NOTHING;`
+var getIdempotencyKey = `SELECT fingerprint, status, content_type, body FROM idempotency_key WHERE key = $1;`
+var saveIdempotencyKey = `UPDATE idempotency_key SET status = $2, content_type = $3, body = $4 WHERE key = $1;`
+var releaseIdempotencyKey = `DELETE FROM idempotency_key WHERE key = $1;`
+
+// ClaimIdempotencyKey reserves key for the current request. It returns nil
+// when the key is fresh, otherwise the response stored by the first request
+// (Pending is set while that request is still running). Responses expire
+// after window, claims that stay pending after pending.
+func ClaimIdempotencyKey(ctx context.Context, key string, fingerprint string, window time.Duration, pending time.Duration) (*IdempotentResponse, error) {
+	if _, err := db.pg.ExecContext(ctx, expireIdempotencyKey, window.Seconds(), pending.Seconds(), key); err != nil {
+		return nil, errors.Wrap(err, "can't expire idempotency key")
+	}
+	for {
+		res, err := db.pg.ExecContext(ctx, claimIdempotencyKey, key, fingerprint)
+		if err != nil {
+			return nil, errors.Wrap(err, "can't insert idempotency key")
+		}
+		ra, err := res.RowsAffected()
+		if err != nil {
+			return nil, errors.Wrap(err, "can't get affected rows")
+		}
+		if ra == 1 {
+			return nil, nil
+		}
+		var stored IdempotentResponse
+		var status sql.NullInt64
+		var contentType sql.NullString
+		err = db.pg.QueryRowContext(ctx, getIdempotencyKey, key).Scan(&stored.Fingerprint, &status, &contentType, &stored.Body)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "can't select idempotency key")
+		}
+		stored.Pending = !status.Valid
+		stored.Status, stored.ContentType = int(status.Int64), contentType.String
+		return &stored, nil
+	}
+}
+
+func SaveIdempotencyKey(ctx context.Context, key string, status int, contentType string, body []byte) error {
+	if _, err := db.pg.ExecContext(ctx, saveIdempotencyKey, key, status, contentType, body); err != nil {
+		return errors.Wrap(err, "can't update idempotency key")
+	}
+	return nil
+}
+
+func ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	if _, err := db.pg.ExecContext(ctx, releaseIdempotencyKey, key); err != nil {
+		return errors.Wrap(err, "can't delete idempotency key")
+	}
+	return nil
+}
+
+// SweepIdempotencyKeys deletes the keys ClaimIdempotencyKey would expire
+// and returns how many there were.
+func SweepIdempotencyKeys(ctx context.Context, window time.Duration, pending time.Duration) (int64, error) {
+	res, err := db.pg.ExecContext(ctx, sweepIdempotencyKeys, window.Seconds(), pending.Seconds())
+	if err != nil {
+		return 0, errors.Wrap(err, "can't delete expired idempotency keys")
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,69 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"time"
+
+	"db-forum/metrics"
+)
+
+var stmtDuration = metrics.NewHistogramVec("forum_db_statement_duration_seconds",
+	"Execution time of prepared statements.", metrics.DefBuckets, "statement")
+
+func init() {
+	metrics.Default.Register(stmtDuration)
+	metrics.Default.Register(metrics.NewGaugeFunc("forum_db_pool", "database/sql connection pool statistics.",
+		[]string{"pool", "stat"}, func(emit func(float64, ...string)) {
+			if db == nil {
+				return
+			}
+			emitPoolStats(emit, "primary", db.pg)
+			if db.replica != nil {
+				emitPoolStats(emit, "replica", db.replica)
+			}
+		}))
+}
+
+func emitPoolStats(emit func(float64, ...string), pool string, pg *sql.DB) {
+	stats := poolStats(pg.Stats())
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		emit(stats[name], pool, name)
+	}
+}
+
+// Stmt is a prepared statement that records its execution time.
+type Stmt struct {
+	*sql.Stmt
+	name string
+}
+
+type stmtRef struct {
+	name string
+	stmt **Stmt
+}
+
+func (s *Stmt) observe(start time.Time) {
+	stmtDuration.With(s.name).Observe(time.Since(start).Seconds())
+}
+
+func (s *Stmt) ExecContext(ctx context.Context, args ...interface{}) (sql.Result, error) {
+	defer s.observe(time.Now())
+	return s.Stmt.ExecContext(ctx, args...)
+}
+
+func (s *Stmt) QueryContext(ctx context.Context, args ...interface{}) (*sql.Rows, error) {
+	defer s.observe(time.Now())
+	return s.Stmt.QueryContext(ctx, args...)
+}
+
+func (s *Stmt) QueryRowContext(ctx context.Context, args ...interface{}) *sql.Row {
+	defer s.observe(time.Now())
+	return s.Stmt.QueryRowContext(ctx, args...)
+}
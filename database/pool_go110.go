@@ -0,0 +1,12 @@
+//go:build !go1.11
+// +build !go1.11
+
+package database
+
+import "database/sql"
+
+func poolStats(stats sql.DBStats) map[string]float64 {
+	return map[string]float64{
+		"open": float64(stats.OpenConnections),
+	}
+}
@@ -0,0 +1,19 @@
+//go:build go1.11
+// +build go1.11
+
+package database
+
+import "database/sql"
+
+func poolStats(stats sql.DBStats) map[string]float64 {
+	return map[string]float64{
+		"max_open":        float64(stats.MaxOpenConnections),
+		"open":            float64(stats.OpenConnections),
+		"in_use":          float64(stats.InUse),
+		"idle":            float64(stats.Idle),
+		"wait_count":      float64(stats.WaitCount),
+		"wait_seconds":    stats.WaitDuration.Seconds(),
+		"max_idle_closed": float64(stats.MaxIdleClosed),
+		"max_life_closed": float64(stats.MaxLifetimeClosed),
+	}
+}
@@ -1,12 +1,15 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"db-forum/logger"
 	"db-forum/models"
 	"fmt"
-	"log"
+	"math"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/asaskevich/govalidator"
 	"github.com/lib/pq"
@@ -19,9 +22,10 @@ VALUES ($1, $2, $3, $4, $5) RETURNING id, created;`
 var updatePostPath = `UPDATE post SET root = $2, path = $3 WHERE id = $1;`
 var updateForumPostsCount = `UPDATE forum SET posts = posts + $2 WHERE slug = $1; `
 
-func CreatePost(post *models.Post) (*models.Post, error) {
+func CreatePost(ctx context.Context, post *models.Post) (*models.Post, error) {
+	markWrite(ctx)
 	newPost := *post
-	if err := db.CreatePostStmt.QueryRow(post.Parent, post.Author, post.Message, post.Forum, post.Thread).Scan(&newPost.ID, &newPost.Created); err != nil {
+	if err := db.CreatePostStmt.QueryRowContext(ctx, post.Parent, post.Author, post.Message, post.Forum, post.Thread).Scan(&newPost.ID, &newPost.Created); err != nil {
 		return nil, errors.Wrap(err, "can't insert into post")
 	}
 	return &newPost, nil
@@ -29,24 +33,87 @@ func CreatePost(post *models.Post) (*models.Post, error) {
 
 var bigInsert = `INSERT INTO post (parent, message, thread, author, forum) values ($1, $2, $3, $4, $5),($6, $7, $8, $9, $10),($11, $12, $13, $14, $15),($16, $17, $18, $19, $20),($21, $22, $23, $24, $25),($26, $27, $28, $29, $30),($31, $32, $33, $34, $35),($36, $37, $38, $39, $40),($41, $42, $43, $44, $45),($46, $47, $48, $49, $50),($51, $52, $53, $54, $55),($56, $57, $58, $59, $60),($61, $62, $63, $64, $65),($66, $67, $68, $69, $70),($71, $72, $73, $74, $75),($76, $77, $78, $79, $80),($81, $82, $83, $84, $85),($86, $87, $88, $89, $90),($91, $92, $93, $94, $95),($96, $97, $98, $99, $100),($101, $102, $103, $104, $105),($106, $107, $108, $109, $110),($111, $112, $113, $114, $115),($116, $117, $118, $119, $120),($121, $122, $123, $124, $125),($126, $127, $128, $129, $130),($131, $132, $133, $134, $135),($136, $137, $138, $139, $140),($141, $142, $143, $144, $145),($146, $147, $148, $149, $150),($151, $152, $153, $154, $155),($156, $157, $158, $159, $160),($161, $162, $163, $164, $165),($166, $167, $168, $169, $170),($171, $172, $173, $174, $175),($176, $177, $178, $179, $180),($181, $182, $183, $184, $185),($186, $187, $188, $189, $190),($191, $192, $193, $194, $195),($196, $197, $198, $199, $200),($201, $202, $203, $204, $205),($206, $207, $208, $209, $210),($211, $212, $213, $214, $215),($216, $217, $218, $219, $220),($221, $222, $223, $224, $225),($226, $227, $228, $229, $230),($231, $232, $233, $234, $235),($236, $237, $238, $239, $240),($241, $242, $243, $244, $245),($246, $247, $248, $249, $250),($251, $252, $253, $254, $255),($256, $257, $258, $259, $260),($261, $262, $263, $264, $265),($266, $267, $268, $269, $270),($271, $272, $273, $274, $275),($276, $277, $278, $279, $280),($281, $282, $283, $284, $285),($286, $287, $288, $289, $290),($291, $292, $293, $294, $295),($296, $297, $298, $299, $300),($301, $302, $303, $304, $305),($306, $307, $308, $309, $310),($311, $312, $313, $314, $315),($316, $317, $318, $319, $320),($321, $322, $323, $324, $325),($326, $327, $328, $329, $330),($331, $332, $333, $334, $335),($336, $337, $338, $339, $340),($341, $342, $343, $344, $345),($346, $347, $348, $349, $350),($351, $352, $353, $354, $355),($356, $357, $358, $359, $360),($361, $362, $363, $364, $365),($366, $367, $368, $369, $370),($371, $372, $373, $374, $375),($376, $377, $378, $379, $380),($381, $382, $383, $384, $385),($386, $387, $388, $389, $390),($391, $392, $393, $394, $395),($396, $397, $398, $399, $400),($401, $402, $403, $404, $405),($406, $407, $408, $409, $410),($411, $412, $413, $414, $415),($416, $417, $418, $419, $420),($421, $422, $423, $424, $425),($426, $427, $428, $429, $430),($431, $432, $433, $434, $435),($436, $437, $438, $439, $440),($441, $442, $443, $444, $445),($446, $447, $448, $449, $450),($451, $452, $453, $454, $455),($456, $457, $458, $459, $460),($461, $462, $463, $464, $465),($466, $467, $468, $469, $470),($471, $472, $473, $474, $475),($476, $477, $478, $479, $480),($481, $482, $483, $484, $485),($486, $487, $488, $489, $490),($491, $492, $493, $494, $495),($496, $497, $498, $499, $500) returning id, is_edited, created`
 
+var getSlowMode = `SELECT slow_mode FROM thread WHERE id = $1;`
+var lockThread = `SELECT slow_mode FROM thread WHERE id = $1 FOR UPDATE;`
 
+var slowModeWait = `SELECT coalesce(extract(epoch FROM max(created) + $3 * interval '1 second' - now()), 0)
+FROM post WHERE thread = $1 AND author = ANY($2::citext[]);`
 
-var getPath = `SELECT path FROM post WHERE id = $1 AND thread = $2;`
+// SlowModeError is returned by CreatePosts when an author replies to a
+// thread in slow mode sooner than it allows.
+type SlowModeError struct {
+	Wait time.Duration
+}
 
-func CreatePosts(posts *[]models.Post, threadSlug string) (*[]models.Post, error) {
-	tx, err := db.pg.Begin()
-	if err != nil {
-		return nil, errors.Wrap(err, "can't start transaction")
+func (e *SlowModeError) Error() string {
+	return fmt.Sprintf("slow mode: retry in %s", e.Wait)
+}
+
+// checkSlowMode returns a SlowModeError if an author of posts replied to
+// thread within its slow mode interval or has more than one post in posts.
+// In slow mode the thread stays locked until tx ends, so two replies of one
+// author can't both pass; threads without it are not locked.
+func checkSlowMode(ctx context.Context, tx *sql.Tx, thread *models.Thread, posts []models.Post) error {
+	var slowMode int32
+	if err := tx.QueryRowContext(ctx, getSlowMode, thread.ID).Scan(&slowMode); err != nil {
+		return errors.Wrap(err, "can't select slow mode")
+	}
+	if slowMode <= 0 {
+		return nil
+	}
+	if err := tx.QueryRowContext(ctx, lockThread, thread.ID).Scan(&slowMode); err != nil {
+		return errors.Wrap(err, "can't lock thread")
+	}
+	if slowMode <= 0 {
+		return nil
+	}
+	if repeatsAuthor(posts) {
+		return &SlowModeError{Wait: time.Duration(slowMode) * time.Second}
+	}
+	authors := make([]string, 0, len(posts))
+	for _, post := range posts {
+		authors = append(authors, post.Author)
+	}
+	var wait float64
+	if err := tx.QueryRowContext(ctx, slowModeWait, thread.ID, pq.Array(authors), slowMode).Scan(&wait); err != nil {
+		return errors.Wrap(err, "can't select last post")
 	}
+	if wait > 0 {
+		return &SlowModeError{Wait: time.Duration(wait * float64(time.Second))}
+	}
+	return nil
+}
+
+// repeatsAuthor reports whether an author has more than one of posts.
+// Nicknames are compared case-insensitively, as the author column is citext.
+func repeatsAuthor(posts []models.Post) bool {
+	authors := make(map[string]bool, len(posts))
+	for _, post := range posts {
+		author := strings.ToLower(post.Author)
+		if authors[author] {
+			return true
+		}
+		authors[author] = true
+	}
+	return false
+}
+
+var getPath = `SELECT path FROM post WHERE id = $1 AND thread = $2;`
+
+// CreatePosts adds posts to the thread threadSlug. The thread and authors
+// are looked up before the transaction starts, so that it holds a single
+// connection of the pool.
+func CreatePosts(ctx context.Context, posts *[]models.Post, threadSlug string) (*[]models.Post, error) {
+	markWrite(ctx)
 	resPosts := make([]models.Post, 0)
 	var thread *models.Thread
+	var err error
 	if govalidator.IsNumeric(threadSlug) {
-		thread, err = GetThreadByID(threadSlug)
+		thread, err = GetThreadByID(ctx, threadSlug)
 	} else {
-		thread, err = GetThreadBySlug(threadSlug)
+		thread, err = GetThreadBySlug(ctx, threadSlug)
 	}
 	if err != nil {
-		tx.Rollback()
 		return nil, err
 	}
 
@@ -63,14 +130,26 @@ func CreatePosts(posts *[]models.Post, threadSlug string) (*[]models.Post, error
 
 	if len(*posts) < 100 {
 		for i, post := range *posts {
-			author, err := GetUserByUsername(post.Author)
+			author, err := GetUserByUsername(ctx, post.Author)
 			if err != nil || author == nil {
-				return nil, ErrNotFound
+				return nil, ErrNoAuthor
 			}
 			(*posts)[i].Author = author.Nickname
 		}
 	}
 
+	tx, err := db.pg.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't start transaction")
+	}
+
+	// thread may come from the cache, so its SlowMode can be stale:
+	// checkSlowMode reads the setting from the row instead.
+	if err := checkSlowMode(ctx, tx, thread, *posts); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
 	if len(*posts) == 100 {
 		for _, post := range *posts {
 			if post.Parent != 0 {
@@ -89,7 +168,11 @@ func CreatePosts(posts *[]models.Post, threadSlug string) (*[]models.Post, error
 	}
 
 	if len(parents) != 0 {
-		rows, err := tx.Query(fmt.Sprint(`select thread from post where id in (`, strings.Join(parents, ","), ")"))
+		rows, err := tx.QueryContext(ctx, fmt.Sprint(`select thread from post where id in (`, strings.Join(parents, ","), ")"))
+		if err != nil {
+			tx.Rollback()
+			return nil, errors.Wrap(err, "can't select parent posts")
+		}
 		hasP := false
 
 		for rows.Next() {
@@ -98,27 +181,39 @@ func CreatePosts(posts *[]models.Post, threadSlug string) (*[]models.Post, error
 			var tId int32
 			err = rows.Scan(&tId)
 			if err != nil {
-				log.Println(err)
+				logger.FromContext(ctx).Error("can't scan parent thread", "error", err)
 			}
 
 			if tId != thread.ID {
+				rows.Close()
+				tx.Rollback()
 				return nil, ErrDuplicate
 			}
 		}
+		rows.Close()
 
 		if !hasP {
+			tx.Rollback()
 			return nil, ErrDuplicate
 		}
 
 	}
 
 	query += strings.Join(queryValues, ",") + queryEnd
-	
+
 	var rows *sql.Rows
 	if len(*posts) == 100 {
-		rows, err = tx.Stmt(db.BigInsert).Query(args...)
+		rows, err = tx.Stmt(db.BigInsert.Stmt).QueryContext(ctx, args...)
 	} else {
-		rows, err = tx.Query(query, args...)
+		rows, err = tx.QueryContext(ctx, query, args...)
+	}
+	if err != nil {
+		tx.Rollback()
+		if _, ok := err.(*pq.Error); ok {
+			logger.FromContext(ctx).Warn("can't insert posts", "error", err)
+			return nil, ErrDuplicate
+		}
+		return nil, errors.Wrap(err, "can't insert into post")
 	}
 
 	var par []string
@@ -134,7 +229,7 @@ func CreatePosts(posts *[]models.Post, threadSlug string) (*[]models.Post, error
 				nopar = append(nopar, strconv.Itoa(int(post.ID)))
 			}
 
-			a["'"+post.Author+"'"] = true
+			a[post.Author] = true
 			(*posts)[i].Forum = thread.Forum
 			(*posts)[i].Thread = thread.ID
 		}
@@ -147,26 +242,31 @@ func CreatePosts(posts *[]models.Post, threadSlug string) (*[]models.Post, error
 	}
 
 	if err := rows.Err(); err != nil {
+		tx.Rollback()
 		if err == sql.ErrNoRows {
 			return nil, ErrNotFound
 		}
 
-		log.Println("error on main query")
-		log.Println(err)
+		logger.FromContext(ctx).Warn("can't insert posts", "error", err)
 		return nil, ErrDuplicate
 	}
-	tx.Exec(updateForumPostsCount, thread.Forum, len(*posts))
+	tx.ExecContext(ctx, updateForumPostsCount, thread.Forum, len(*posts))
+	if _, err := tx.ExecContext(ctx, addForumUsers, thread.Forum, pq.Array(auth)); err != nil {
+		tx.Rollback()
+		return nil, errors.Wrap(err, "can't insert into forum_users")
+	}
 
 	err = tx.Commit()
 	if err != nil {
-		log.Println(err)
+		logger.FromContext(ctx).Error("can't commit posts", "error", err)
 	}
+	invalidateForums(thread.Forum)
 
 	for _, post := range *posts {
 		var root int64
 		sqlPath := make([]sql.NullInt64, 0)
 		if post.Parent != 0 {
-			if err = db.pg.QueryRow(getPath, post.Parent, thread.ID).Scan(pq.Array(&sqlPath)); err != nil {
+			if err = db.pg.QueryRowContext(ctx, getPath, post.Parent, thread.ID).Scan(pq.Array(&sqlPath)); err != nil {
 				tx.Rollback()
 				if err == sql.ErrNoRows {
 					return nil, ErrDuplicate
@@ -182,7 +282,7 @@ func CreatePosts(posts *[]models.Post, threadSlug string) (*[]models.Post, error
 		if err != nil {
 			return nil, errors.Wrap(err, "can't prepare post path")
 		}
-		if _, err = updateStmt.Exec(post.ID, root, pq.Array(sqlPath)); err != nil {
+		if _, err = updateStmt.ExecContext(ctx, post.ID, root, pq.Array(sqlPath)); err != nil {
 			return nil, errors.Wrap(err, "can't update post path")
 		}
 	}
@@ -190,12 +290,12 @@ func CreatePosts(posts *[]models.Post, threadSlug string) (*[]models.Post, error
 	return posts, nil
 }
 
-var getPostByID = `SELECT id, parent, author, message, is_edited, forum, thread, created 
+var getPostByID = `SELECT id, parent, author, message, is_edited, forum, thread, created, root, path, version 
 FROM post WHERE id = $1;`
 
-func GetPostByID(id int64) (*models.Post, error) {
-	var post models.Post
-	if err := db.GetPostByIDStmt.QueryRow(id).Scan(&post.ID, &post.Parent, &post.Author, &post.Message, &post.IsEdited, &post.Forum, &post.Thread, &post.Created); err != nil {
+func GetPostByID(ctx context.Context, id int64) (*PostRow, error) {
+	var post PostRow
+	if err := db.GetPostByIDStmt.QueryRowContext(ctx, id).Scan(&post.ID, &post.Parent, &post.Author, &post.Message, &post.IsEdited, &post.Forum, &post.Thread, &post.Created, &post.Root, pq.Array(&post.Path), &post.Version); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, ErrNotFound
 		}
@@ -204,8 +304,103 @@ func GetPostByID(id int64) (*models.Post, error) {
 	return &post, nil
 }
 
-func GetPostsFlat(thread int32, limit string, since string, desc string) (*[]models.Post, error) {
+var getPostAncestors = `SELECT id, parent, author, message, is_edited, forum, thread, created 
+FROM post WHERE id = ANY($1) ORDER BY array_length(path, 1);`
+
+func GetPostAncestors(ctx context.Context, post *PostRow, limit int) (*[]models.Post, error) {
+	posts := make([]models.Post, 0)
+	if len(post.Path) < 2 {
+		return &posts, nil
+	}
+	ids := post.Path[:len(post.Path)-1]
+	if limit > 0 && limit < len(ids) {
+		ids = ids[len(ids)-limit:]
+	}
+	rows, err := db.pg.QueryContext(ctx, getPostAncestors, pq.Array(ids))
+	if err != nil {
+		return nil, errors.Wrap(err, "can't select from posts")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p models.Post
+		if err := rows.Scan(&p.ID, &p.Parent, &p.Author, &p.Message, &p.IsEdited, &p.Forum, &p.Thread, &p.Created); err != nil {
+			return nil, errors.Wrap(err, "can't scan rows")
+		}
+		posts = append(posts, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "rows error")
+	}
+	return &posts, nil
+}
+
+var getPostDescendants = `SELECT id, parent, author, message, forum, thread, created,
+	CASE WHEN array_length(path, 1) = $3
+		THEN (SELECT count(*) FROM post c WHERE c.root = p.root AND c.path[1:$3] = p.path) - 1
+		ELSE 0 END
+FROM post p WHERE root = $1 AND path[1:$2] = $4 AND id <> $5 AND array_length(path, 1) <= $3 
+ORDER BY path LIMIT $6;`
+
+func GetPostDescendants(ctx context.Context, post *PostRow, limit int, depth int) (*[]models.PostTree, error) {
+	posts := make([]models.PostTree, 0)
+	maxDepth := math.MaxInt32
+	if depth > 0 && depth < maxDepth-len(post.Path) {
+		maxDepth = len(post.Path) + depth
+	}
+	rows, err := db.pg.QueryContext(ctx, getPostDescendants, post.Root, len(post.Path), maxDepth, pq.Array(post.Path), post.ID, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't select from posts")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p models.PostTree
+		if err := rows.Scan(&p.ID, &p.Parent, &p.Author, &p.Message, &p.Forum, &p.Thread, &p.Created, &p.Collapsed); err != nil {
+			return nil, errors.Wrap(err, "can't scan rows")
+		}
+		posts = append(posts, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "rows error")
+	}
+	return &posts, nil
+}
+
+// scanPosts calls fn with each post of rows and closes them. fn must not
+// keep the post, which is reused for the next row.
+func scanPosts(rows *sql.Rows, fn func(*models.Post) error) error {
+	defer rows.Close()
+	var post models.Post
+	for rows.Next() {
+		if err := rows.Scan(&post.ID, &post.Parent, &post.Author, &post.Message, &post.Forum, &post.Thread, &post.Created); err != nil {
+			return errors.Wrap(err, "can't scan rows")
+		}
+		if err := fn(&post); err != nil {
+			return err
+		}
+	}
+	return errors.Wrap(rows.Err(), "can't read posts")
+}
+
+func collectPosts(each func(fn func(*models.Post) error) error) (*[]models.Post, error) {
 	posts := make([]models.Post, 0)
+	err := each(func(post *models.Post) error {
+		posts = append(posts, *post)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &posts, nil
+}
+
+func GetPostsFlat(ctx context.Context, thread int32, limit string, since string, desc string) (*[]models.Post, error) {
+	return collectPosts(func(fn func(*models.Post) error) error {
+		return EachPostFlat(ctx, thread, limit, since, desc, fn)
+	})
+}
+
+// EachPostFlat calls fn with the posts GetPostsFlat returns as they are read.
+func EachPostFlat(ctx context.Context, thread int32, limit string, since string, desc string, fn func(*models.Post) error) error {
 	getPostsFlat := `SELECT id, parent, author, message, forum, thread, created FROM post WHERE thread = $1`
 	var rows *sql.Rows
 	var err error
@@ -215,33 +410,29 @@ func GetPostsFlat(thread int32, limit string, since string, desc string) (*[]mod
 		} else {
 			getPostsFlat += " AND id > $2 ORDER BY id ASC LIMIT $3;"
 		}
-		rows, err = db.pg.Query(getPostsFlat, thread, since, limit)
+		rows, err = reader(ctx).QueryContext(ctx, getPostsFlat, thread, since, limit)
 	} else {
 		if desc == "true" {
 			getPostsFlat += " ORDER BY id DESC LIMIT $2;"
 		} else {
 			getPostsFlat += " ORDER BY id LIMIT $2;"
 		}
-		rows, err = db.pg.Query(getPostsFlat, thread, limit)
+		rows, err = reader(ctx).QueryContext(ctx, getPostsFlat, thread, limit)
 	}
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return &posts, nil
-		}
-		return nil, errors.Wrap(err, "can't select from posts")
+		return errors.Wrap(err, "can't select from posts")
 	}
-	for rows.Next() {
-		var post models.Post
-		if err := rows.Scan(&post.ID, &post.Parent, &post.Author, &post.Message, &post.Forum, &post.Thread, &post.Created); err != nil {
-			return nil, errors.Wrap(err, "can't scan rows")
-		}
-		posts = append(posts, post)
-	}
-	return &posts, nil
+	return scanPosts(rows, fn)
 }
 
-func GetPostsTree(thread int32, limit string, since string, desc string) (*[]models.Post, error) {
-	posts := make([]models.Post, 0)
+func GetPostsTree(ctx context.Context, thread int32, limit string, since string, desc string) (*[]models.Post, error) {
+	return collectPosts(func(fn func(*models.Post) error) error {
+		return EachPostTree(ctx, thread, limit, since, desc, fn)
+	})
+}
+
+// EachPostTree calls fn with the posts GetPostsTree returns as they are read.
+func EachPostTree(ctx context.Context, thread int32, limit string, since string, desc string, fn func(*models.Post) error) error {
 	getPostTree := `SELECT id, parent, author, message, forum, thread, created FROM post WHERE thread = $1 `
 	var rows *sql.Rows
 	var err error
@@ -251,7 +442,7 @@ func GetPostsTree(thread int32, limit string, since string, desc string) (*[]mod
 		} else {
 			getPostTree += ` AND path > (SELECT path FROM post WHERE id = $2 ) ORDER BY path LIMIT $3;`
 		}
-		rows, err = db.pg.Query(getPostTree, thread, since, limit)
+		rows, err = reader(ctx).QueryContext(ctx, getPostTree, thread, since, limit)
 	} else {
 		since = "0"
 		if desc == "true" {
@@ -259,26 +450,70 @@ func GetPostsTree(thread int32, limit string, since string, desc string) (*[]mod
 		} else {
 			getPostTree += ` ORDER BY path LIMIT $2;`
 		}
-		rows, err = db.pg.Query(getPostTree, thread, limit)
+		rows, err = reader(ctx).QueryContext(ctx, getPostTree, thread, limit)
 	}
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return &posts, nil
+		return errors.Wrap(err, "can't select from posts")
+	}
+	return scanPosts(rows, fn)
+}
+
+// GetPostsTreeNodes returns the trees of the first limit root posts of the
+// thread, down to depth levels. Trees are whole up to depth, so every post
+// but a root has its parent in the result and collapsed counts the replies
+// below depth only. since continues after the root of that post.
+func GetPostsTreeNodes(ctx context.Context, thread int32, limit string, since string, desc string, depth int) (*[]models.PostTree, error) {
+	posts := make([]models.PostTree, 0)
+	if depth <= 0 {
+		depth = math.MaxInt32
+	}
+	getPostTreeNodes := `SELECT id, parent, author, message, forum, thread, created,
+		CASE WHEN array_length(path, 1) = $2
+			THEN (SELECT count(*) FROM post c WHERE c.root = p.root AND c.path[1:$2] = p.path) - 1
+			ELSE 0 END
+		FROM post p WHERE array_length(path, 1) <= $2 AND root IN (SELECT id FROM post WHERE thread = $1 AND parent = 0`
+	var rows *sql.Rows
+	var err error
+	if since != "" {
+		if desc == "true" {
+			getPostTreeNodes += ` AND id < (SELECT root FROM post WHERE id = $3) ORDER BY id DESC LIMIT $4) ORDER BY root DESC, path;`
+		} else {
+			getPostTreeNodes += ` AND id > (SELECT root FROM post WHERE id = $3) ORDER BY id LIMIT $4) ORDER BY path;`
+		}
+		rows, err = reader(ctx).QueryContext(ctx, getPostTreeNodes, thread, depth, since, limit)
+	} else {
+		if desc == "true" {
+			getPostTreeNodes += ` ORDER BY id DESC LIMIT $3) ORDER BY root DESC, path;`
+		} else {
+			getPostTreeNodes += ` ORDER BY id LIMIT $3) ORDER BY path;`
 		}
+		rows, err = reader(ctx).QueryContext(ctx, getPostTreeNodes, thread, depth, limit)
+	}
+	if err != nil {
 		return nil, errors.Wrap(err, "can't select from posts")
 	}
+	defer rows.Close()
 	for rows.Next() {
-		var post models.Post
-		if err := rows.Scan(&post.ID, &post.Parent, &post.Author, &post.Message, &post.Forum, &post.Thread, &post.Created); err != nil {
+		var post models.PostTree
+		if err := rows.Scan(&post.ID, &post.Parent, &post.Author, &post.Message, &post.Forum, &post.Thread, &post.Created, &post.Collapsed); err != nil {
 			return nil, errors.Wrap(err, "can't scan rows")
 		}
 		posts = append(posts, post)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "rows error")
+	}
 	return &posts, nil
 }
 
-func GetPostsParentTree(thread int32, limit string, since string, desc string) (*[]models.Post, error) {
-	posts := make([]models.Post, 0)
+func GetPostsParentTree(ctx context.Context, thread int32, limit string, since string, desc string) (*[]models.Post, error) {
+	return collectPosts(func(fn func(*models.Post) error) error {
+		return EachPostParentTree(ctx, thread, limit, since, desc, fn)
+	})
+}
+
+// EachPostParentTree calls fn with the posts GetPostsParentTree returns as they are read.
+func EachPostParentTree(ctx context.Context, thread int32, limit string, since string, desc string, fn func(*models.Post) error) error {
 	getPostParentTree := `SELECT id, parent, author, message, forum, thread, created FROM post WHERE root IN (SELECT id FROM post WHERE thread = $1 AND parent = 0 `
 	var rows *sql.Rows
 	var err error
@@ -288,7 +523,7 @@ func GetPostsParentTree(thread int32, limit string, since string, desc string) (
 		} else {
 			getPostParentTree += ` AND path > (SELECT path FROM post WHERE id = $2 ) ORDER BY id LIMIT $3) ORDER BY path;`
 		}
-		rows, err = db.pg.Query(getPostParentTree, thread, since, limit)
+		rows, err = reader(ctx).QueryContext(ctx, getPostParentTree, thread, since, limit)
 	} else {
 		since = "0"
 		if desc == "true" {
@@ -296,29 +531,25 @@ func GetPostsParentTree(thread int32, limit string, since string, desc string) (
 		} else {
 			getPostParentTree += `ORDER BY id LIMIT $2) ORDER BY path;`
 		}
-		rows, err = db.pg.Query(getPostParentTree, thread, limit)
+		rows, err = reader(ctx).QueryContext(ctx, getPostParentTree, thread, limit)
 	}
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return &posts, nil
-		}
-		return nil, errors.Wrap(err, "can't select from posts")
-	}
-	for rows.Next() {
-		var post models.Post
-		if err := rows.Scan(&post.ID, &post.Parent, &post.Author, &post.Message, &post.Forum, &post.Thread, &post.Created); err != nil {
-			return nil, errors.Wrap(err, "can't scan rows")
-		}
-		posts = append(posts, post)
+		return errors.Wrap(err, "can't select from posts")
 	}
-	return &posts, nil
+	return scanPosts(rows, fn)
 }
 
-var updatePost = `UPDATE post SET message = coalesce(coalesce(nullif($2, ''), message)), is_edited = $3 WHERE id = $1 RETURNING message, author, is_edited, thread, created, forum;`
+var updatePost = `UPDATE post SET message = coalesce(coalesce(nullif($2, ''), message)), is_edited = $3, version = version + 1 
+WHERE id = $1 AND ($4 = 0 OR version = $4) RETURNING message, author, is_edited, thread, created, forum, version;`
+
+var postExists = `SELECT 1 FROM post WHERE id = $1;`
 
-func UpdatePost(post *models.Post) (*models.Post, error) {
+// UpdatePost applies the update only while post.Version matches the stored
+// version; zero Version updates unconditionally.
+func UpdatePost(ctx context.Context, post *PostRow) (*PostRow, error) {
+	markWrite(ctx)
 	newPost := *post
-	oldPost, err := GetPostByID(post.ID)
+	oldPost, err := GetPostByID(ctx, post.ID)
 	if err != nil {
 		return nil, err
 	}
@@ -334,11 +565,104 @@ func UpdatePost(post *models.Post) (*models.Post, error) {
 		}
 	}
 
-	if err := db.pg.QueryRow(updatePost, post.ID, post.Message, post.IsEdited).Scan(&newPost.Message, &newPost.Author, &newPost.IsEdited, &newPost.Thread, &newPost.Created, &newPost.Forum); err != nil {
+	if err := db.pg.QueryRowContext(ctx, updatePost, post.ID, post.Message, post.IsEdited, post.Version).Scan(&newPost.Message, &newPost.Author, &newPost.IsEdited, &newPost.Thread, &newPost.Created, &newPost.Forum, &newPost.Version); err != nil {
 		if err == sql.ErrNoRows {
-			return nil, ErrNotFound
+			return nil, versionMismatch(ctx, postExists, post.ID, post.Version)
 		}
 		return nil, errors.Wrap(err, "can't update post")
 	}
 	return &newPost, nil
 }
+
+var getPostForUpdate = `SELECT id, parent, author, message, is_edited, forum, thread, created, root, path 
+FROM post WHERE id = $1 FOR UPDATE;`
+
+func lockPost(ctx context.Context, tx *sql.Tx, id int64) (*PostRow, error) {
+	var post PostRow
+	if err := tx.QueryRowContext(ctx, getPostForUpdate, id).Scan(&post.ID, &post.Parent, &post.Author, &post.Message, &post.IsEdited, &post.Forum, &post.Thread, &post.Created, &post.Root, pq.Array(&post.Path)); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "can't select from post")
+	}
+	return &post, nil
+}
+
+var moveSubtree = `UPDATE post SET path = $3::INTEGER[] || path[$2:array_length(path, 1)], root = $4, thread = $5, forum = $6,
+	parent = CASE WHEN id = $7 THEN $8 ELSE parent END, version = version + 1
+	WHERE root = $1 AND path[1:$2] = $9::INTEGER[];`
+
+// relocate rewrites path, root, thread and forum of the post subtree so it
+// hangs under parent in thread (or becomes a root when parent is nil).
+func relocate(ctx context.Context, tx *sql.Tx, post *PostRow, thread *models.Thread, parent *PostRow) error {
+	prefix := make([]int64, 0)
+	root, parentID := post.ID, int64(0)
+	if parent != nil {
+		prefix, root, parentID = parent.Path, parent.Root, parent.ID
+	}
+	res, err := tx.ExecContext(ctx, moveSubtree, post.Root, len(post.Path), pq.Array(prefix), root, thread.ID, thread.Forum, post.ID, parentID, pq.Array(post.Path))
+	if err != nil {
+		return errors.Wrap(err, "can't move posts")
+	}
+	moved, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "can't get affected rows")
+	}
+	if post.Forum != thread.Forum {
+		if _, err := tx.ExecContext(ctx, updateForumPostsCount, post.Forum, -moved); err != nil {
+			return errors.Wrap(err, "can't update forum")
+		}
+		if _, err := tx.ExecContext(ctx, updateForumPostsCount, thread.Forum, moved); err != nil {
+			return errors.Wrap(err, "can't update forum")
+		}
+		if err := syncForumUsers(ctx, tx, thread.ID, post.Forum); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isAncestor(post *PostRow, of *PostRow) bool {
+	for _, id := range of.Path {
+		if id == post.ID {
+			return true
+		}
+	}
+	return false
+}
+
+func MovePost(ctx context.Context, id int64, thread *models.Thread, parentID int64) (*PostRow, error) {
+	markWrite(ctx)
+	tx, err := db.pg.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't start transaction")
+	}
+	defer tx.Rollback()
+	post, err := lockPost(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if thread == nil {
+		thread = &models.Thread{ID: post.Thread, Forum: post.Forum}
+	}
+	var parent *PostRow
+	if parentID != 0 {
+		if parent, err = lockPost(ctx, tx, parentID); err != nil {
+			if err == ErrNotFound {
+				return nil, ErrConflict
+			}
+			return nil, err
+		}
+		if parent.Thread != thread.ID || isAncestor(post, parent) {
+			return nil, ErrConflict
+		}
+	}
+	if err := relocate(ctx, tx, post, thread, parent); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "can't commit transaction")
+	}
+	invalidateForums(post.Forum, thread.Forum)
+	return GetPostByID(ctx, id)
+}
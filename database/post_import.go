@@ -0,0 +1,183 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"db-forum/models"
+	"io"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+var dropPostImport = `DROP TABLE IF EXISTS pg_temp.post_import;`
+var createPostImport = `CREATE TEMP TABLE post_import (
+	seq        BIGINT NOT NULL,
+	src_id     BIGINT,
+	src_parent BIGINT NOT NULL,
+	author     CITEXT NOT NULL,
+	message    TEXT NOT NULL,
+	is_edited  BOOLEAN NOT NULL,
+	created    TIMESTAMP WITH TIME ZONE,
+	id         INTEGER,
+	parent     INTEGER,
+	parent_path INTEGER[]
+) ON COMMIT DROP;`
+
+var indexPostImport = `CREATE INDEX ON post_import (src_id); CREATE INDEX ON post_import (parent); ANALYZE post_import;`
+
+var checkImportAuthors = `SELECT i.author FROM post_import i
+	WHERE NOT EXISTS (SELECT 1 FROM users u WHERE u.nickname = i.author) LIMIT 1;`
+var fixImportAuthors = `UPDATE post_import i SET author = u.nickname FROM users u WHERE u.nickname = i.author;`
+var checkImportIDs = `SELECT src_id FROM post_import WHERE src_id IS NOT NULL GROUP BY src_id HAVING count(*) > 1 LIMIT 1;`
+var assignImportIDs = `UPDATE post_import i SET id = n.id FROM (
+	SELECT seq, nextval(pg_get_serial_sequence('post', 'id')) AS id FROM (SELECT seq FROM post_import ORDER BY seq) s
+) n WHERE i.seq = n.seq;`
+var linkImportParents = `UPDATE post_import i SET parent = p.id FROM post_import p
+	WHERE i.src_parent <> 0 AND p.src_id = i.src_parent;`
+var linkThreadParents = `UPDATE post_import i SET parent = p.id, parent_path = p.path FROM post p
+	WHERE i.parent IS NULL AND i.src_parent <> 0 AND p.id = i.src_parent AND p.thread = $1;`
+var checkImportParents = `SELECT src_parent FROM post_import WHERE src_parent <> 0 AND parent IS NULL LIMIT 1;`
+var insertImportPosts = `WITH RECURSIVE tree AS (
+	SELECT id, coalesce(parent_path, ARRAY[]::INTEGER[]) || id AS path FROM post_import
+	WHERE parent IS NULL OR parent_path IS NOT NULL
+	UNION ALL
+	SELECT i.id, t.path || i.id FROM post_import i JOIN tree t ON i.parent = t.id AND i.parent_path IS NULL
+)
+INSERT INTO post (id, parent, author, message, is_edited, forum, thread, created, path, root)
+SELECT i.id, coalesce(i.parent, 0), i.author, i.message, i.is_edited, $2::CITEXT, $1::INTEGER, coalesce(i.created, now()), t.path, t.path[1]
+FROM post_import i JOIN tree t ON t.id = i.id ORDER BY i.seq;`
+var addImportForumUsers = `INSERT INTO forum_users (forum, nickname) SELECT DISTINCT $1::CITEXT, author FROM post_import
+	ON CONFLICT DO NOTHING;`
+
+// ImportPosts loads posts returned by next into thread until next returns
+// io.EOF. Post ID and Parent are ids of the source system: a parent is
+// looked up among the imported posts first and then among the posts of
+// thread. Unknown authors give ErrNotFound, unknown parents, repeated ids
+// and cycles give ErrConflict.
+func ImportPosts(ctx context.Context, thread *models.Thread, next func() (*models.Post, error)) (int64, error) {
+	markWrite(ctx)
+	tx, err := startBulk(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	imported, err := importPosts(ctx, tx, thread, next)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, errors.Wrap(err, "can't commit transaction")
+	}
+	invalidateForums(thread.Forum)
+	return imported, nil
+}
+
+func importPosts(ctx context.Context, tx *sql.Tx, thread *models.Thread, next func() (*models.Post, error)) (int64, error) {
+	if _, err := tx.ExecContext(ctx, dropPostImport); err != nil {
+		return 0, errors.Wrap(err, "can't drop staging table")
+	}
+	if _, err := tx.ExecContext(ctx, createPostImport); err != nil {
+		return 0, errors.Wrap(err, "can't create staging table")
+	}
+	staged, err := stagePosts(ctx, tx, next)
+	if err != nil {
+		return 0, err
+	}
+	if staged == 0 {
+		return 0, nil
+	}
+	if _, err := tx.ExecContext(ctx, indexPostImport); err != nil {
+		return 0, errors.Wrap(err, "can't index staging table")
+	}
+	if found, err := stagedExists(ctx, tx, checkImportAuthors); found || err != nil {
+		if err != nil {
+			return 0, err
+		}
+		return 0, ErrNotFound
+	}
+	if found, err := stagedExists(ctx, tx, checkImportIDs); found || err != nil {
+		if err != nil {
+			return 0, err
+		}
+		return 0, ErrConflict
+	}
+	for _, query := range []string{fixImportAuthors, assignImportIDs, linkImportParents} {
+		if _, err := tx.ExecContext(ctx, query); err != nil {
+			return 0, errors.Wrap(err, "can't update staging table")
+		}
+	}
+	if _, err := tx.ExecContext(ctx, linkThreadParents, thread.ID); err != nil {
+		return 0, errors.Wrap(err, "can't update staging table")
+	}
+	if found, err := stagedExists(ctx, tx, checkImportParents); found || err != nil {
+		if err != nil {
+			return 0, err
+		}
+		return 0, ErrConflict
+	}
+	res, err := tx.ExecContext(ctx, insertImportPosts, thread.ID, thread.Forum)
+	if err != nil {
+		return 0, errors.Wrap(err, "can't insert into post")
+	}
+	imported, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "can't get affected rows")
+	}
+	if imported != staged {
+		return 0, ErrConflict
+	}
+	if _, err := tx.ExecContext(ctx, updateForumPostsCount, thread.Forum, imported); err != nil {
+		return 0, errors.Wrap(err, "can't update forum")
+	}
+	if _, err := tx.ExecContext(ctx, addImportForumUsers, thread.Forum); err != nil {
+		return 0, errors.Wrap(err, "can't insert into forum_users")
+	}
+	return imported, nil
+}
+
+func stagedExists(ctx context.Context, tx *sql.Tx, query string) (bool, error) {
+	var value interface{}
+	if err := tx.QueryRowContext(ctx, query).Scan(&value); err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, errors.Wrap(err, "can't check staged posts")
+	}
+	return true, nil
+}
+
+// stagePosts copies posts into the post_import staging table.
+func stagePosts(ctx context.Context, tx *sql.Tx, next func() (*models.Post, error)) (int64, error) {
+	stmt, err := tx.Prepare(pq.CopyIn("post_import", "seq", "src_id", "src_parent", "author", "message", "is_edited", "created"))
+	if err != nil {
+		return 0, errors.Wrap(err, "can't start copy")
+	}
+	defer stmt.Close()
+	var seq int64
+	for {
+		post, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		var srcID, created interface{}
+		if post.ID != 0 {
+			srcID = post.ID
+		}
+		if post.Created != nil {
+			created = time.Time(*post.Created)
+		}
+		seq++
+		if _, err := stmt.ExecContext(ctx, seq, srcID, post.Parent, post.Author, post.Message, post.IsEdited, created); err != nil {
+			return 0, errors.Wrap(err, "can't copy post")
+		}
+	}
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		return 0, errors.Wrap(err, "can't finish copy")
+	}
+	return seq, nil
+}
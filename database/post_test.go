@@ -0,0 +1,44 @@
+package database
+
+import (
+	"context"
+	"testing"
+
+	"db-forum/models"
+)
+
+func TestRepeatsAuthor(t *testing.T) {
+	tests := []struct {
+		authors []string
+		want    bool
+	}{
+		{nil, false},
+		{[]string{"j.sparrow"}, false},
+		{[]string{"j.sparrow", "d.jones"}, false},
+		{[]string{"j.sparrow", "d.jones", "j.sparrow"}, true},
+		{[]string{"j.sparrow", "J.Sparrow"}, true},
+	}
+	for _, tt := range tests {
+		posts := make([]models.Post, len(tt.authors))
+		for i, author := range tt.authors {
+			posts[i].Author = author
+		}
+		if got := repeatsAuthor(posts); got != tt.want {
+			t.Errorf("repeatsAuthor(%q) = %v, want %v", tt.authors, got, tt.want)
+		}
+	}
+}
+
+// TestCreatePostsEmptyBatch checks that an empty batch is answered
+// without opening a transaction: db has no pool to open it on.
+func TestCreatePostsEmptyBatch(t *testing.T) {
+	defer func(old *DB) { db = old }(db)
+	db = &DB{}
+	cacheThread(threadCache.Epoch(), &ThreadRow{Thread: models.Thread{ID: 4242, Forum: "f"}})
+	defer invalidateThreads(4242)
+
+	posts, err := CreatePosts(context.Background(), &[]models.Post{}, "4242")
+	if err != nil || len(*posts) != 0 {
+		t.Errorf("CreatePosts of no posts = %v, %v", posts, err)
+	}
+}
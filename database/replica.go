@@ -0,0 +1,117 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// session remembers whether a request wrote to the primary, after which
+// its reads stay on the primary so they see the write despite replica lag.
+// seen is the WAL position of the client's last write in earlier requests:
+// its reads stay on the primary until the replica has replayed that far.
+type session struct {
+	wrote  int32
+	seen   uint64
+	caught int32
+}
+
+type sessionKey struct{}
+
+// NewSession returns a context whose reads are routed to the replica
+// until a write is made with it. seen is a position returned by
+// WritePosition for an earlier request of the same client, or "".
+func NewSession(ctx context.Context, seen string) context.Context {
+	lsn, _ := ParseLSN(seen)
+	return context.WithValue(ctx, sessionKey{}, &session{seen: lsn})
+}
+
+func markWrite(ctx context.Context) {
+	if s, ok := ctx.Value(sessionKey{}).(*session); ok {
+		atomic.StoreInt32(&s.wrote, 1)
+	}
+}
+
+// replayed is the last WAL position the replica was seen to have replayed.
+var replayed uint64
+
+// replayPosition asks the replica how far it has replayed the WAL.
+var replayPosition = func(ctx context.Context) (uint64, error) {
+	var lsn string
+	if err := db.replica.QueryRowContext(ctx, `SELECT pg_last_wal_replay_lsn()::text;`).Scan(&lsn); err != nil {
+		return 0, errors.Wrap(err, "can't get replay position")
+	}
+	return ParseLSN(lsn)
+}
+
+// caughtUp reports whether the replica has replayed the writes s has seen,
+// asking it only while the last known position is behind.
+func (s *session) caughtUp(ctx context.Context) bool {
+	if s.seen == 0 || atomic.LoadInt32(&s.caught) != 0 || atomic.LoadUint64(&replayed) >= s.seen {
+		return true
+	}
+	lsn, err := replayPosition(ctx)
+	if err != nil {
+		return false
+	}
+	for old := atomic.LoadUint64(&replayed); lsn > old && !atomic.CompareAndSwapUint64(&replayed, old, lsn); {
+		old = atomic.LoadUint64(&replayed)
+	}
+	if lsn < s.seen {
+		return false
+	}
+	atomic.StoreInt32(&s.caught, 1)
+	return true
+}
+
+// reader returns the pool for read-only queries: the replica if there is
+// one, ctx is a session that has not written yet and the replica has
+// caught up with the writes its client made before.
+func reader(ctx context.Context) *sql.DB {
+	if db.replica == nil {
+		return db.pg
+	}
+	s, ok := ctx.Value(sessionKey{}).(*session)
+	if !ok || atomic.LoadInt32(&s.wrote) != 0 || !s.caughtUp(ctx) {
+		return db.pg
+	}
+	return db.replica
+}
+
+// WritePosition returns the WAL position of the primary after the writes
+// made with the session ctx, for the client to pass to NewSession in its
+// next requests. It is "" without a replica or writes.
+func WritePosition(ctx context.Context) string {
+	s, ok := ctx.Value(sessionKey{}).(*session)
+	if !ok || atomic.LoadInt32(&s.wrote) == 0 || db.replica == nil {
+		return ""
+	}
+	var lsn string
+	if err := db.pg.QueryRowContext(ctx, `SELECT pg_current_wal_lsn()::text;`).Scan(&lsn); err != nil {
+		return ""
+	}
+	return lsn
+}
+
+// ParseLSN parses a WAL position written as Postgres does, such as
+// 16/B374D848.
+func ParseLSN(s string) (uint64, error) {
+	var hi, lo uint32
+	if n, err := fmt.Sscanf(s, "%X/%X", &hi, &lo); n != 2 || err != nil || fmt.Sprintf("%X/%X", hi, lo) != s {
+		return 0, errors.Errorf("bad WAL position %q", s)
+	}
+	return uint64(hi)<<32 | uint64(lo), nil
+}
+
+// OpenReplica connects to a read replica of the database opened by OpenDB.
+func OpenReplica(DSN string) error {
+	replica, err := openPool(DSN)
+	if err != nil {
+		return errors.Wrap(err, "replica")
+	}
+	db.replica = replica
+	return nil
+}
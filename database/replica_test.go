@@ -0,0 +1,98 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestParseLSN(t *testing.T) {
+	tests := []struct {
+		s    string
+		want uint64
+		ok   bool
+	}{
+		{"0/0", 0, true},
+		{"0/16B3748", 0x16B3748, true},
+		{"16/B374D848", 0x16<<32 | 0xB374D848, true},
+		{"", 0, false},
+		{"16", 0, false},
+		{"16/b374d848", 0, false},
+		{"16/B374D848x", 0, false},
+		{"/1", 0, false},
+		{"1/100000000", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseLSN(tt.s)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ParseLSN(%q) = %X, %v, want %X, ok %v", tt.s, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+// TestReader checks that a client that wrote in an earlier request reads
+// from the primary until the replica has replayed its write.
+func TestReader(t *testing.T) {
+	primary, replica := &sql.DB{}, &sql.DB{}
+	defer func(old *DB, position func(context.Context) (uint64, error)) {
+		db, replayPosition, replayed = old, position, 0
+	}(db, replayPosition)
+	db = &DB{pg: primary, replica: replica}
+	replayed = 0
+	replay, asked := uint64(0), 0
+	var replayErr error
+	replayPosition = func(context.Context) (uint64, error) {
+		asked++
+		return replay, replayErr
+	}
+
+	if got := reader(context.Background()); got != primary {
+		t.Error("reads without a session go to the replica")
+	}
+	fresh := NewSession(context.Background(), "")
+	if got := reader(fresh); got != replica {
+		t.Error("reads of a new client go to the primary")
+	}
+	markWrite(fresh)
+	if got := reader(fresh); got != primary {
+		t.Error("reads after a write in the same request go to the replica")
+	}
+	if asked != 0 {
+		t.Errorf("replica asked %d times without a write cookie", asked)
+	}
+
+	seen := NewSession(context.Background(), "0/200")
+	replay = 0x100
+	if got := reader(seen); got != primary {
+		t.Error("reads go to a replica behind the client's last write")
+	}
+	replayErr = errors.New("replica down")
+	replay = 0x300
+	if got := reader(seen); got != primary {
+		t.Error("reads go to a replica whose position is unknown")
+	}
+	replayErr = nil
+	if got := reader(seen); got != replica {
+		t.Error("reads stay on the primary after the replica caught up")
+	}
+	if replayed != 0x300 {
+		t.Errorf("replayed = %X, want 300", replayed)
+	}
+
+	asked = 0
+	if got := reader(NewSession(context.Background(), "0/250")); got != replica {
+		t.Error("reads of a write the replica is known to have replayed go to the primary")
+	}
+	if got := reader(NewSession(context.Background(), "bad")); got != replica {
+		t.Error("reads with a bad write cookie go to the primary")
+	}
+	if asked != 0 {
+		t.Errorf("replica asked %d times about replayed writes", asked)
+	}
+
+	db.replica = nil
+	if got := reader(NewSession(context.Background(), "0/900")); got != primary {
+		t.Error("reads without a replica don't go to the primary")
+	}
+}
@@ -0,0 +1,28 @@
+package database
+
+import "db-forum/models"
+
+// The models are generated from swagger.yaml and carry only what clients
+// see. The rows below add the columns the server keeps to itself; they
+// marshal and validate as the model they embed.
+
+// UserRow is a user with the version of its row, sent as its ETag.
+type UserRow struct {
+	models.User
+	Version int64
+}
+
+// ThreadRow is a thread with the version of its row, sent as its ETag.
+type ThreadRow struct {
+	models.Thread
+	Version int64
+}
+
+// PostRow is a post with its place in the tree of its thread and the
+// version of its row, sent as its ETag.
+type PostRow struct {
+	models.Post
+	Root    int64
+	Path    []int64
+	Version int64
+}
@@ -0,0 +1,39 @@
+package database
+
+import (
+	"encoding/json"
+	"testing"
+
+	"db-forum/models"
+
+	"github.com/mailru/easyjson"
+)
+
+// TestRowsMarshalAsModels checks that the server-side columns of rows stay
+// out of response bodies.
+func TestRowsMarshalAsModels(t *testing.T) {
+	post := PostRow{Post: models.Post{ID: 3, Author: "j.sparrow", Message: "m"}, Root: 1, Path: []int64{1, 3}, Version: 7}
+	thread := ThreadRow{Thread: models.Thread{ID: 4, Title: "t", SlowMode: 30}, Version: 8}
+	user := UserRow{User: models.User{Nickname: "j.sparrow", Email: "j@example.com"}, Version: 9}
+	tests := []struct {
+		row   interface{}
+		model easyjson.Marshaler
+	}{
+		{&post, post.Post},
+		{&thread, thread.Thread},
+		{&user, user.User},
+	}
+	for _, tt := range tests {
+		want, err := easyjson.Marshal(tt.model)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := json.Marshal(tt.row)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != string(want) {
+			t.Errorf("%T marshals as %s, want %s", tt.row, got, want)
+		}
+	}
+}
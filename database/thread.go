@@ -1,10 +1,13 @@
 package database
 
 import (
+	"context"
 	"db-forum/models"
 
 	"database/sql"
+	"strconv"
 
+	"github.com/asaskevich/govalidator"
 	"github.com/pkg/errors"
 )
 
@@ -12,15 +15,16 @@ var createThread = `INSERT INTO thread (title, author, forum, message, created,
 
 var updateForumCount = `UPDATE forum SET threads = threads + 1 WHERE slug = $1;`
 
-func CreateThread(thread *models.Thread) (*models.Thread, error) {
+func CreateThread(ctx context.Context, thread *models.Thread) (*models.Thread, error) {
+	markWrite(ctx)
 	var slug string
 	var id int32
-	tx, err := db.pg.Begin()
+	tx, err := db.pg.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, errors.Wrap(err, "can't start transaction")
 	}
-	if err := db.CreateThreadStmt.QueryRow(thread.Title, thread.Author, thread.Forum, thread.Message, thread.Created, thread.Slug).Scan(&slug, &id); err != nil {
-		existThread, error := GetThreadBySlug(thread.Slug)
+	if err := db.CreateThreadStmt.QueryRowContext(ctx, thread.Title, thread.Author, thread.Forum, thread.Message, thread.Created, thread.Slug).Scan(&slug, &id); err != nil {
+		existThread, error := GetThreadBySlug(ctx, thread.Slug)
 		if error == ErrNotFound {
 			tx.Rollback()
 			return nil, errors.Wrap(err, "can't insert into db")
@@ -33,107 +37,291 @@ func CreateThread(thread *models.Thread) (*models.Thread, error) {
 		tx.Rollback()
 		return nil, errors.Wrap(err, "can't prepare query")
 	}
-	if _, err := updateForumCountStmt.Exec(thread.Forum); err != nil {
+	if _, err := updateForumCountStmt.ExecContext(ctx, thread.Forum); err != nil {
 		tx.Rollback()
 		return nil, errors.Wrap(err, "can't exec query")
 	}
+	if _, err := tx.ExecContext(ctx, addForumUser, thread.Forum, thread.Author); err != nil {
+		tx.Rollback()
+		return nil, errors.Wrap(err, "can't insert into forum_users")
+	}
 	tx.Commit()
+	invalidateForums(thread.Forum)
 	thread.Slug = slug
 	thread.ID = id
 	return thread, nil
 }
 
-var getThreadByID = `SELECT id, title, author, forum, message, votes, created, slug FROM thread WHERE id = $1;`
+var getThreadByID = `SELECT id, title, author, forum, message, votes, created, slug, version, slow_mode FROM thread WHERE id = $1;`
 
-func GetThreadByID(id string) (*models.Thread, error) {
-	var thread models.Thread
-	if err := db.pg.QueryRow(getThreadByID, id).Scan(&thread.ID, &thread.Title, &thread.Author, &thread.Forum, &thread.Message, &thread.Votes, &thread.Created, &thread.Slug); err != nil {
-		if err == sql.ErrNoRows {
-			return nil, ErrNotFound
-		}
-		return nil, errors.Wrap(err, "can't select from thread")
+func GetThreadByID(ctx context.Context, id string) (*models.Thread, error) {
+	n, err := strconv.ParseInt(id, 10, 32)
+	if err != nil {
+		return nil, ErrNotFound
 	}
-	return &thread, nil
+	return GetThreadByIDint32(ctx, int32(n))
+}
+
+func GetThreadByIDint32(ctx context.Context, id int32) (*models.Thread, error) {
+	return threadOf(threadRowByID(ctx, id))
 }
 
-func GetThreadByIDint32(id int32) (*models.Thread, error) {
-	var thread models.Thread
-	if err := db.pg.QueryRow(getThreadByID, id).Scan(&thread.ID, &thread.Title, &thread.Author, &thread.Forum, &thread.Message, &thread.Votes, &thread.Created, &thread.Slug); err != nil {
+func threadRowByID(ctx context.Context, id int32) (*ThreadRow, error) {
+	epoch := threadCache.Epoch()
+	if thread, ok := cachedThreadByID(id); ok {
+		return thread, nil
+	}
+	var thread ThreadRow
+	if err := db.pg.QueryRowContext(ctx, getThreadByID, id).Scan(&thread.ID, &thread.Title, &thread.Author, &thread.Forum, &thread.Message, &thread.Votes, &thread.Created, &thread.Slug, &thread.Version, &thread.SlowMode); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, ErrNotFound
 		}
 		return nil, errors.Wrap(err, "can't select from thread")
 	}
+	cacheThread(epoch, &thread)
 	return &thread, nil
 }
 
-var getThreadBySlug = `SELECT id, title, author, forum, message, votes, created, slug FROM thread WHERE slug = $1;`
+var getThreadBySlug = `SELECT id, title, author, forum, message, votes, created, slug, version, slow_mode FROM thread WHERE slug = $1;`
+
+func GetThreadBySlug(ctx context.Context, slug string) (*models.Thread, error) {
+	return threadOf(threadRowBySlug(ctx, slug))
+}
 
-func GetThreadBySlug(slug string) (*models.Thread, error) {
-	var thread models.Thread
-	if err := db.GetThreadBySlugStmt.QueryRow(slug).Scan(&thread.ID, &thread.Title, &thread.Author, &thread.Forum, &thread.Message, &thread.Votes, &thread.Created, &thread.Slug); err != nil {
+func threadRowBySlug(ctx context.Context, slug string) (*ThreadRow, error) {
+	epoch := threadCache.Epoch()
+	if thread, ok := cachedThreadBySlug(slug); ok {
+		return thread, nil
+	}
+	var thread ThreadRow
+	if err := db.GetThreadBySlugStmt.QueryRowContext(ctx, slug).Scan(&thread.ID, &thread.Title, &thread.Author, &thread.Forum, &thread.Message, &thread.Votes, &thread.Created, &thread.Slug, &thread.Version, &thread.SlowMode); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, ErrNotFound
 		}
 		return nil, errors.Wrap(err, "can't select from thread")
 	}
+	cacheThread(epoch, &thread)
 	return &thread, nil
 }
 
-var getThread = `SELECT id, title, author, forum, message, votes, created, slug FROM thread WHERE id = $1 OR slug = $2;`
+var getThread = `SELECT id, title, author, forum, message, votes, created, slug, version, slow_mode FROM thread WHERE id = $1 OR slug = $2;`
 
-func GetThread(id string, slug string) (*models.Thread, error) {
-	var thread models.Thread
-	if err := db.GetThreadStmt.QueryRow(id, slug).Scan(&thread.ID, &thread.Title, &thread.Author, &thread.Forum, &thread.Message, &thread.Votes, &thread.Created, &thread.Slug); err != nil {
+func GetThread(ctx context.Context, id string, slug string) (*models.Thread, error) {
+	return threadOf(threadRow(ctx, id, slug))
+}
+
+func threadRow(ctx context.Context, id string, slug string) (*ThreadRow, error) {
+	epoch := threadCache.Epoch()
+	if n, err := strconv.ParseInt(id, 10, 32); err == nil {
+		if thread, ok := cachedThreadByID(int32(n)); ok {
+			return thread, nil
+		}
+	}
+	if thread, ok := cachedThreadBySlug(slug); ok {
+		return thread, nil
+	}
+	var thread ThreadRow
+	if err := db.GetThreadStmt.QueryRowContext(ctx, id, slug).Scan(&thread.ID, &thread.Title, &thread.Author, &thread.Forum, &thread.Message, &thread.Votes, &thread.Created, &thread.Slug, &thread.Version, &thread.SlowMode); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, ErrNotFound
 		}
 		return nil, errors.Wrap(err, "can't select from thread")
 	}
+	cacheThread(epoch, &thread)
 	return &thread, nil
 }
 
+// GetThreadRow is GetThreadBySlugOrID with the version of the thread.
+func GetThreadRow(ctx context.Context, slugOrID string) (*ThreadRow, error) {
+	if govalidator.IsNumeric(slugOrID) {
+		return threadRow(ctx, slugOrID, slugOrID)
+	}
+	return threadRowBySlug(ctx, slugOrID)
+}
+
+func threadOf(row *ThreadRow, err error) (*models.Thread, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &row.Thread, nil
+}
+
 var createVoteThread = `INSERT INTO voice (nickname, vote, thread_id) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING;`
 var updateVoteByID = `UPDATE voice SET prev_vote = vote, vote = $1 WHERE thread_id = $2 AND nickname = $3 RETURNING (vote - prev_vote);`
-var updateVoteThread = `UPDATE thread SET votes = votes + $1 WHERE id = $2 RETURNING votes;`
+var updateVoteThread = `UPDATE thread SET votes = votes + $1, version = version + 1 WHERE id = $2 RETURNING votes;`
 
-func VoteThread(vote *models.Vote) (newVote int32, err error) {
-	tx, err := db.pg.Begin()
+func VoteThread(ctx context.Context, vote *models.Vote) (newVote int32, err error) {
+	markWrite(ctx)
+	tx, err := db.pg.BeginTx(ctx, nil)
 	if err != nil {
 		return 0, errors.Wrap(err, "can't start tx")
 	}
 	var diff int32
-	if err := db.pg.QueryRow(updateVoteByID, vote.Voice, vote.ThreadId, vote.Nickname).Scan(&diff); err != nil {
+	if err := db.pg.QueryRowContext(ctx, updateVoteByID, vote.Voice, vote.ThreadId, vote.Nickname).Scan(&diff); err != nil {
 		if err != sql.ErrNoRows {
 			tx.Rollback()
 			return 0, errors.Wrap(err, "can't update voice")
 		}
-		if _, err := db.CreatVoteThreadStmt.Exec(vote.Nickname, vote.Voice, vote.ThreadId); err != nil {
+		if _, err := db.CreatVoteThreadStmt.ExecContext(ctx, vote.Nickname, vote.Voice, vote.ThreadId); err != nil {
 			tx.Rollback()
 			return 0, errors.Wrap(err, "can't insert into voice")
 		}
 		diff = vote.Voice
 	}
-	if err := db.UpdateVoteThreadStmt.QueryRow(diff, vote.ThreadId).Scan(&newVote); err != nil {
+	if err := db.UpdateVoteThreadStmt.QueryRowContext(ctx, diff, vote.ThreadId).Scan(&newVote); err != nil {
 		tx.Rollback()
 		return 0, errors.Wrap(err, "can't update thread")
 	}
 	tx.Commit()
+	invalidateThreads(vote.ThreadId)
 	return newVote, nil
 }
 
+var threadIDExists = `SELECT 1 FROM thread WHERE id = $1;`
+
 var updateThread = `UPDATE thread SET title = coalesce(coalesce(nullif($2, ''), title)),
-			message = coalesce(coalesce(nullif($3, ''), message))
-			WHERE id = $1 RETURNING title, message;`
+			message = coalesce(coalesce(nullif($3, ''), message)),
+			slow_mode = coalesce($5, slow_mode),
+			version = version + 1
+			WHERE id = $1 AND ($4 = 0 OR version = $4) RETURNING title, message, version, slow_mode;`
 
-func UpdateThread(thread *models.Thread) (*models.Thread, error) {
+// UpdateThread applies the update only while thread.Version matches the
+// stored version; zero Version updates unconditionally. Nil slowMode keeps
+// the slow mode of the thread.
+func UpdateThread(ctx context.Context, thread *ThreadRow, slowMode *int32) (*ThreadRow, error) {
+	markWrite(ctx)
 	newThread := *thread
 	updateThreadStmt, err := db.pg.Prepare(updateThread)
 	if err != nil {
 		return nil, errors.Wrap(err, "can't prepare query")
 	}
-	if err := updateThreadStmt.QueryRow(thread.ID, thread.Title, thread.Message).Scan(&newThread.Title, &newThread.Message); err != nil {
+	if err := updateThreadStmt.QueryRowContext(ctx, thread.ID, thread.Title, thread.Message, thread.Version, slowMode).Scan(&newThread.Title, &newThread.Message, &newThread.Version, &newThread.SlowMode); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, versionMismatch(ctx, threadIDExists, thread.ID, thread.Version)
+		}
 		return nil, errors.Wrap(err, "can't update thread")
 	}
+	invalidateThreads(thread.ID)
+	return &newThread, nil
+}
+
+func GetThreadBySlugOrID(ctx context.Context, slugOrID string) (*models.Thread, error) {
+	if govalidator.IsNumeric(slugOrID) {
+		return GetThread(ctx, slugOrID, slugOrID)
+	}
+	return GetThreadBySlug(ctx, slugOrID)
+}
+
+var splitThread = `INSERT INTO thread (title, author, forum, message, slug) VALUES ($1, $2, $3, $4, $5) 
+RETURNING id, title, author, forum, message, votes, created, slug;`
+
+func SplitThread(ctx context.Context, thread *models.Thread, split *models.ThreadSplit) (*models.Thread, error) {
+	markWrite(ctx)
+	if split.Slug != "" {
+		existThread, err := GetThreadBySlug(ctx, split.Slug)
+		if err == nil {
+			return existThread, ErrDuplicate
+		}
+		if err != ErrNotFound {
+			return nil, err
+		}
+	}
+	tx, err := db.pg.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't start transaction")
+	}
+	defer tx.Rollback()
+	post, err := lockPost(ctx, tx, split.Post)
+	if err != nil {
+		return nil, err
+	}
+	if post.Thread != thread.ID {
+		return nil, ErrConflict
+	}
+	author, message := split.Author, split.Message
+	if author == "" {
+		author = post.Author
+	}
+	if message == "" {
+		message = post.Message
+	}
+	var newThread models.Thread
+	if err := tx.QueryRowContext(ctx, splitThread, split.Title, author, thread.Forum, message, split.Slug).Scan(&newThread.ID, &newThread.Title, &newThread.Author, &newThread.Forum, &newThread.Message, &newThread.Votes, &newThread.Created, &newThread.Slug); err != nil {
+		return nil, errors.Wrap(err, "can't insert into thread")
+	}
+	if _, err := tx.ExecContext(ctx, updateForumCount, thread.Forum); err != nil {
+		return nil, errors.Wrap(err, "can't update forum")
+	}
+	if _, err := tx.ExecContext(ctx, addForumUser, thread.Forum, author); err != nil {
+		return nil, errors.Wrap(err, "can't insert into forum_users")
+	}
+	if err := relocate(ctx, tx, post, &newThread, nil); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "can't commit transaction")
+	}
+	invalidateForums(thread.Forum)
 	return &newThread, nil
 }
+
+var mergeThreadPosts = `UPDATE post SET thread = $2, forum = $3, version = version + 1 WHERE thread = $1;`
+var mergeThreadVoices = `UPDATE voice SET thread_id = $2 WHERE thread_id = $1 
+	AND nickname NOT IN (SELECT nickname FROM voice WHERE thread_id = $2);`
+var deleteThreadVoices = `DELETE FROM voice WHERE thread_id = $1;`
+var recountThreadVotes = `UPDATE thread SET votes = (SELECT coalesce(sum(vote), 0) FROM voice WHERE thread_id = $1), 
+	version = version + 1 WHERE id = $1;`
+var deleteThread = `DELETE FROM thread WHERE id = $1;`
+var updateForumThreadsCount = `UPDATE forum SET threads = threads + $2 WHERE slug = $1;`
+
+func MergeThreads(ctx context.Context, source *models.Thread, target *models.Thread) (*models.Thread, error) {
+	markWrite(ctx)
+	if source.ID == target.ID {
+		return nil, ErrConflict
+	}
+	tx, err := db.pg.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't start transaction")
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, mergeThreadPosts, source.ID, target.ID, target.Forum)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't move posts")
+	}
+	moved, err := res.RowsAffected()
+	if err != nil {
+		return nil, errors.Wrap(err, "can't get affected rows")
+	}
+	if _, err := tx.ExecContext(ctx, mergeThreadVoices, source.ID, target.ID); err != nil {
+		return nil, errors.Wrap(err, "can't move voices")
+	}
+	if _, err := tx.ExecContext(ctx, deleteThreadVoices, source.ID); err != nil {
+		return nil, errors.Wrap(err, "can't delete voices")
+	}
+	if _, err := tx.ExecContext(ctx, recountThreadVotes, target.ID); err != nil {
+		return nil, errors.Wrap(err, "can't update thread")
+	}
+	if _, err := tx.ExecContext(ctx, deleteThread, source.ID); err != nil {
+		return nil, errors.Wrap(err, "can't delete thread")
+	}
+	if _, err := tx.ExecContext(ctx, updateForumThreadsCount, source.Forum, -1); err != nil {
+		return nil, errors.Wrap(err, "can't update forum")
+	}
+	if source.Forum != target.Forum {
+		if _, err := tx.ExecContext(ctx, updateForumPostsCount, source.Forum, -moved); err != nil {
+			return nil, errors.Wrap(err, "can't update forum")
+		}
+		if _, err := tx.ExecContext(ctx, updateForumPostsCount, target.Forum, moved); err != nil {
+			return nil, errors.Wrap(err, "can't update forum")
+		}
+	}
+	if err := syncForumUsers(ctx, tx, target.ID, source.Forum); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "can't commit transaction")
+	}
+	invalidateThreads(source.ID, target.ID)
+	invalidateForums(source.Forum, target.Forum)
+	return GetThreadByIDint32(ctx, target.ID)
+}
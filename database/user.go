@@ -1,21 +1,24 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"db-forum/models"
+	"strings"
 
 	"github.com/pkg/errors"
 )
 
 var createUser = `INSERT INTO users (nickname, fullname, about, email) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING;`
 
-func CreateUser(user *models.User) (*[]models.User, error) {
+func CreateUser(ctx context.Context, user *models.User) (*[]models.User, error) {
+	markWrite(ctx)
 	var users []models.User
-	tx, err := db.pg.Begin()
+	tx, err := db.pg.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, errors.Wrap(err, "can't prepare tx")
 	}
-	res, err := db.CreateUserStmt.Exec(user.Nickname, user.Fullname, user.About, user.Email)
+	res, err := db.CreateUserStmt.ExecContext(ctx, user.Nickname, user.Fullname, user.About, user.Email)
 	if err != nil {
 		tx.Rollback()
 		return nil, errors.Wrap(err, "can't insert into users")
@@ -26,7 +29,7 @@ func CreateUser(user *models.User) (*[]models.User, error) {
 		return nil, errors.Wrap(err, "can't get affected rows")
 	}
 	if ra == 0 {
-		usr, err := GetUser(user.Nickname, user.Email)
+		usr, err := GetUser(ctx, user.Nickname, user.Email)
 		if err != nil {
 			if err == ErrNotFound {
 				tx.Rollback()
@@ -43,23 +46,41 @@ func CreateUser(user *models.User) (*[]models.User, error) {
 	return &users, nil
 }
 
-var getUserByUsername = `SELECT nickname, fullname, about, email FROM users WHERE nickname = $1 LIMIT 1;`
+var getUserByUsername = `SELECT nickname, fullname, about, email, version FROM users WHERE nickname = $1 LIMIT 1;`
 
-func GetUserByUsername(nickname string) (*models.User, error) {
-	var user models.User
-	if err := db.GetUserByUsernameStmt.QueryRow(nickname).Scan(&user.Nickname, &user.Fullname, &user.About, &user.Email); err != nil {
+func GetUserByUsername(ctx context.Context, nickname string) (*models.User, error) {
+	user, err := GetUserRow(ctx, nickname)
+	if err != nil {
+		return nil, err
+	}
+	return &user.User, nil
+}
+
+// GetUserRow is GetUserByUsername with the version of the user.
+func GetUserRow(ctx context.Context, nickname string) (*UserRow, error) {
+	key := strings.ToLower(nickname)
+	epoch := userCache.Epoch()
+	if cached, ok := userCache.Get(key); ok {
+		user := *cached.(*UserRow)
+		return &user, nil
+	}
+	var user UserRow
+	if err := db.GetUserByUsernameStmt.QueryRowContext(ctx, nickname).Scan(&user.Nickname, &user.Fullname, &user.About, &user.Email, &user.Version); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, ErrNotFound
 		}
+		return nil, errors.Wrap(err, "can't select from users")
 	}
+	cached := user
+	userCache.AddSince(epoch, key, &cached)
 	return &user, nil
 }
 
 var getUser = `SELECT nickname, fullname, about, email FROM users WHERE nickname = $1 OR email = $2;`
 
-func GetUser(nickname string, email string) (*[]models.User, error) {
+func GetUser(ctx context.Context, nickname string, email string) (*[]models.User, error) {
 	var users []models.User
-	rows, err := db.GetUserStmt.Query(nickname, email)
+	rows, err := db.GetUserStmt.QueryContext(ctx, nickname, email)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, ErrNotFound
@@ -82,49 +103,55 @@ func GetUser(nickname string, email string) (*[]models.User, error) {
 
 var updateUser = `UPDATE users SET fullname = coalesce(coalesce(nullif($2, ''), fullname)), 
 			email = coalesce(coalesce(nullif($3, ''), email)), 
-			about = coalesce(coalesce(nullif($4, ''), about)) WHERE nickname = $1 RETURNING fullname, email, about;`
+			about = coalesce(coalesce(nullif($4, ''), about)),
+			version = version + 1
+			WHERE nickname = $1 AND ($5 = 0 OR version = $5) RETURNING fullname, email, about, version;`
 
-func UpdateUser(user *models.User) (*[]models.User, error) {
-	var users []models.User
-	var newUser models.User
-	err := db.UpdateUserStmt.QueryRow(user.Nickname, user.Fullname, user.Email, user.About).Scan(&newUser.Fullname, &newUser.Email, &newUser.About)
+// UpdateUser applies the update only while user.Version matches the stored
+// version; zero Version updates unconditionally.
+func UpdateUser(ctx context.Context, user *UserRow) (*UserRow, error) {
+	markWrite(ctx)
+	var newUser UserRow
+	err := db.UpdateUserStmt.QueryRowContext(ctx, user.Nickname, user.Fullname, user.Email, user.About, user.Version).Scan(&newUser.Fullname, &newUser.Email, &newUser.About, &newUser.Version)
+	if err == sql.ErrNoRows {
+		return nil, versionMismatch(ctx, userExists, user.Nickname, user.Version)
+	}
 	if err != nil {
-		usr, err := GetUser(user.Nickname, user.Email)
-		if err != nil {
+		if _, err := GetUser(ctx, user.Nickname, user.Email); err != nil {
 			if err == ErrNotFound {
 				return nil, ErrNotFound
 			}
 			return nil, errors.Wrap(err, "can't get from users")
 		}
-		return usr, ErrDuplicate
+		return nil, ErrDuplicate
 	}
+	invalidateUser(user.Nickname)
 	newUser.Nickname = user.Nickname
-	users = append(users, newUser)
-	return &users, nil
+	return &newUser, nil
 }
 
-var getForumUsers = `SELECT nickname, fullname, about, email FROM users WHERE ( nickname IN (SELECT author FROM post WHERE forum = $1) 
-					OR nickname IN (SELECT author FROM thread WHERE forum = $1) ) `
+var getForumUsers = `SELECT u.nickname, u.fullname, u.about, u.email FROM forum_users fu 
+					JOIN users u ON u.nickname = fu.nickname WHERE fu.forum = $1 `
 
-func GetForumUsers(slug string, limit string, since string, desc string) ([]models.User, error) {
+func GetForumUsers(ctx context.Context, slug string, limit string, since string, desc string) ([]models.User, error) {
 	query := getForumUsers
 	users := make([]models.User, 0)
 	var rows *sql.Rows
 	var err error
 	if since != "" {
 		if desc == "true" {
-			query += "AND nickname < $2 ORDER BY nickname DESC LIMIT $3;"
+			query += "AND fu.nickname < $2 ORDER BY fu.nickname DESC LIMIT $3;"
 		} else {
-			query += "AND nickname > $2 ORDER BY nickname LIMIT $3;"
+			query += "AND fu.nickname > $2 ORDER BY fu.nickname LIMIT $3;"
 		}
-		rows, err = db.pg.Query(query, slug, since, limit)
+		rows, err = reader(ctx).QueryContext(ctx, query, slug, since, limit)
 	} else {
 		if desc == "true" {
-			query += "ORDER BY nickname DESC LIMIT $2;"
+			query += "ORDER BY fu.nickname DESC LIMIT $2;"
 		} else {
-			query += "ORDER BY nickname LIMIT $2;"
+			query += "ORDER BY fu.nickname LIMIT $2;"
 		}
-		rows, err = db.pg.Query(query, slug, limit)
+		rows, err = reader(ctx).QueryContext(ctx, query, slug, limit)
 	}
 	if err != nil {
 		return users, errors.Wrap(err, "can't select users from forum")
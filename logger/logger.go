@@ -0,0 +1,208 @@
+// Package logger writes leveled log lines as text or JSON, carrying fields
+// such as the request id from a context.
+package logger
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type Level int
+
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < Debug || l > Error {
+		return "unknown"
+	}
+	return levelNames[l]
+}
+
+func ParseLevel(name string) (Level, error) {
+	for i, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return Level(i), nil
+		}
+	}
+	return Info, errors.Errorf("unknown log level %q", name)
+}
+
+type output struct {
+	mu    sync.Mutex
+	w     io.Writer
+	level Level
+	json  bool
+}
+
+// Logger writes to a shared output; With returns loggers with more fields.
+type Logger struct {
+	out    *output
+	fields []interface{}
+}
+
+func New(w io.Writer, level Level, json bool) *Logger {
+	return &Logger{out: &output{w: w, level: level, json: json}}
+}
+
+var std = New(os.Stderr, Info, false)
+
+func Default() *Logger {
+	return std
+}
+
+// Configure changes the output format and level of the default logger and
+// of all loggers derived from it.
+func Configure(level Level, json bool) {
+	std.out.mu.Lock()
+	std.out.level, std.out.json = level, json
+	std.out.mu.Unlock()
+}
+
+// With returns a logger adding key/value pairs to every line.
+func (l *Logger) With(kv ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(kv))
+	fields = append(fields, l.fields...)
+	fields = append(fields, kv...)
+	return &Logger{out: l.out, fields: fields}
+}
+
+func (l *Logger) Debug(msg string, kv ...interface{}) { l.log(Debug, msg, kv) }
+func (l *Logger) Info(msg string, kv ...interface{})  { l.log(Info, msg, kv) }
+func (l *Logger) Warn(msg string, kv ...interface{})  { l.log(Warn, msg, kv) }
+func (l *Logger) Error(msg string, kv ...interface{}) { l.log(Error, msg, kv) }
+
+func (l *Logger) log(level Level, msg string, kv []interface{}) {
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	if level < l.out.level {
+		return
+	}
+	fields := append(append(make([]interface{}, 0, len(l.fields)+len(kv)), l.fields...), kv...)
+	var buf bytes.Buffer
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	if l.out.json {
+		writeJSON(&buf, now, level, msg, fields)
+	} else {
+		writeText(&buf, now, level, msg, fields)
+	}
+	l.out.w.Write(buf.Bytes())
+}
+
+func value(v interface{}) interface{} {
+	switch v := v.(type) {
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return v
+}
+
+func writeJSON(buf *bytes.Buffer, now string, level Level, msg string, fields []interface{}) {
+	keys := []string{"time", "level", "msg"}
+	values := map[string]interface{}{"time": now, "level": level.String(), "msg": msg}
+	for i := 0; i+1 < len(fields); i += 2 {
+		key := fmt.Sprint(fields[i])
+		if _, ok := values[key]; !ok {
+			keys = append(keys, key)
+		}
+		values[key] = value(fields[i+1])
+	}
+	buf.WriteByte('{')
+	for i, key := range keys {
+		if i != 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		v, err := json.Marshal(values[key])
+		if err != nil {
+			v, _ = json.Marshal(fmt.Sprint(values[key]))
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteString("}\n")
+}
+
+func writeText(buf *bytes.Buffer, now string, level Level, msg string, fields []interface{}) {
+	fmt.Fprintf(buf, "%s %-5s %s", now, strings.ToUpper(level.String()), msg)
+	for i := 0; i+1 < len(fields); i += 2 {
+		s := fmt.Sprint(value(fields[i+1]))
+		if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+			s = fmt.Sprintf("%q", s)
+		}
+		fmt.Fprintf(buf, " %v=%s", fields[i], s)
+	}
+	buf.WriteByte('\n')
+}
+
+type contextKey int
+
+const (
+	loggerKey contextKey = iota
+	requestIDKey
+)
+
+// NewContext returns ctx carrying the request id; loggers taken from it
+// add the request_id field.
+func NewContext(ctx context.Context, requestID string) context.Context {
+	ctx = context.WithValue(ctx, requestIDKey, requestID)
+	return context.WithValue(ctx, loggerKey, std.With("request_id", requestID))
+}
+
+const maxRequestIDLength = 128
+
+// RequestIDOrNew returns id if it is a usable request id, such as a
+// client's X-Request-ID, and a new random one otherwise.
+func RequestIDOrNew(id string) string {
+	if validRequestID(id) {
+		return id
+	}
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// FromContext returns the logger of ctx or the default one.
+func FromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(loggerKey).(*Logger); ok {
+		return l
+	}
+	return std
+}
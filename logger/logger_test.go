@@ -0,0 +1,91 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestParseLevel(t *testing.T) {
+	for _, name := range []string{"debug", "INFO", "Warn", "error"} {
+		level, err := ParseLevel(name)
+		if err != nil || level.String() != strings.ToLower(name) {
+			t.Errorf("ParseLevel(%q) = %v, %v", name, level, err)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("ParseLevel accepted verbose")
+	}
+}
+
+func TestLevelFilter(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf, Warn, false)
+	l.Debug("d")
+	l.Info("i")
+	l.Warn("w")
+	l.Error("e")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], "WARN  w") || !strings.Contains(lines[1], "ERROR e") {
+		t.Errorf("lines %q", lines)
+	}
+}
+
+func TestText(t *testing.T) {
+	var buf bytes.Buffer
+	New(&buf, Debug, false).With("request_id", "r1").Info("done", "path", "/a b", "n", 3, "empty", "", "error", errors.New("bad"))
+	line := buf.String()
+	line = line[strings.IndexByte(line, ' ')+1:]
+	if want := "INFO  done request_id=r1 path=\"/a b\" n=3 empty=\"\" error=bad\n"; line != want {
+		t.Errorf("line %q, want %q", line, want)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var buf bytes.Buffer
+	New(&buf, Debug, true).With("request_id", "r1").Error("failed", "error", errors.New("bad"), "msg", "shadowed", "odd")
+	line := buf.String()
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &fields); err != nil {
+		t.Fatalf("%q is not JSON: %v", line, err)
+	}
+	if fields["level"] != "error" || fields["request_id"] != "r1" || fields["error"] != "bad" || fields["msg"] != "shadowed" {
+		t.Errorf("fields %v", fields)
+	}
+	if _, ok := fields["odd"]; ok {
+		t.Error("key without value logged")
+	}
+	if !strings.HasPrefix(line, `{"time":`) {
+		t.Errorf("line %q doesn't start with time", line)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	if id := RequestIDOrNew("abc-123"); id != "abc-123" {
+		t.Errorf("valid id replaced by %q", id)
+	}
+	for _, bad := range []string{"", "has space", "new\nline", strings.Repeat("x", maxRequestIDLength+1), "тест"} {
+		id := RequestIDOrNew(bad)
+		if id == bad || len(id) != 32 {
+			t.Errorf("RequestIDOrNew(%q) = %q", bad, id)
+		}
+	}
+	if a, b := RequestIDOrNew(""), RequestIDOrNew(""); a == b {
+		t.Error("generated ids repeat")
+	}
+
+	ctx := NewContext(context.Background(), "r1")
+	if RequestID(ctx) != "r1" || RequestID(context.Background()) != "" {
+		t.Error("RequestID doesn't read the context")
+	}
+	if FromContext(context.Background()) != Default() {
+		t.Error("FromContext without logger isn't the default")
+	}
+	if l := FromContext(ctx); len(l.fields) != 2 || l.fields[1] != "r1" {
+		t.Errorf("logger of context has fields %v", l.fields)
+	}
+}
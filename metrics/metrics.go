@@ -0,0 +1,246 @@
+// Package metrics keeps counters and histograms and writes them in the
+// Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Collector writes its samples, preceded by HELP and TYPE lines.
+type Collector interface {
+	Collect(w io.Writer)
+}
+
+type Registry struct {
+	mu         sync.Mutex
+	collectors []Collector
+}
+
+// Default is the registry served on /metrics.
+var Default = &Registry{}
+
+func (r *Registry) Register(c Collector) {
+	r.mu.Lock()
+	r.collectors = append(r.collectors, c)
+	r.mu.Unlock()
+}
+
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	collectors := append([]Collector(nil), r.collectors...)
+	r.mu.Unlock()
+	buf := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.Collect(buf)
+	}
+	return buf.Flush()
+}
+
+func writeHeader(w io.Writer, name string, help string, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelString formats {name="value",...}; extra is appended verbatim.
+func labelString(names []string, values []string, extra string) string {
+	if len(names) == 0 && extra == "" {
+		return ""
+	}
+	parts := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		parts = append(parts, name+`="`+labelEscaper.Replace(values[i])+`"`)
+	}
+	if extra != "" {
+		parts = append(parts, extra)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// series maps label values to one metric of a vector.
+type series struct {
+	mu     sync.RWMutex
+	labels []string
+	items  map[string]interface{}
+	values map[string][]string
+}
+
+func newSeries(labels []string) series {
+	return series{labels: labels, items: make(map[string]interface{}), values: make(map[string][]string)}
+}
+
+func (s *series) get(values []string, create func() interface{}) interface{} {
+	if len(values) != len(s.labels) {
+		panic(fmt.Sprintf("metrics: got %d label values, want %d", len(values), len(s.labels)))
+	}
+	key := strings.Join(values, "\xff")
+	s.mu.RLock()
+	item, ok := s.items[key]
+	s.mu.RUnlock()
+	if ok {
+		return item
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if item, ok = s.items[key]; !ok {
+		item = create()
+		s.items[key] = item
+		s.values[key] = append([]string(nil), values...)
+	}
+	return item
+}
+
+// each visits the series sorted by label values.
+func (s *series) each(fn func(values []string, item interface{})) {
+	s.mu.RLock()
+	keys := make([]string, 0, len(s.items))
+	for key := range s.items {
+		keys = append(keys, key)
+	}
+	s.mu.RUnlock()
+	sort.Strings(keys)
+	for _, key := range keys {
+		s.mu.RLock()
+		item, values := s.items[key], s.values[key]
+		s.mu.RUnlock()
+		fn(values, item)
+	}
+}
+
+type Counter struct {
+	value uint64
+}
+
+func (c *Counter) Inc() {
+	atomic.AddUint64(&c.value, 1)
+}
+
+func (c *Counter) Add(n uint64) {
+	atomic.AddUint64(&c.value, n)
+}
+
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.value)
+}
+
+type CounterVec struct {
+	name string
+	help string
+	series
+}
+
+func NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	return &CounterVec{name: name, help: help, series: newSeries(labels)}
+}
+
+func (v *CounterVec) With(values ...string) *Counter {
+	return v.get(values, func() interface{} { return &Counter{} }).(*Counter)
+}
+
+func (v *CounterVec) Collect(w io.Writer) {
+	writeHeader(w, v.name, v.help, "counter")
+	v.each(func(values []string, item interface{}) {
+		fmt.Fprintf(w, "%s%s %d\n", v.name, labelString(v.labels, values, ""), item.(*Counter).Value())
+	})
+}
+
+// DefBuckets are latency buckets in seconds.
+var DefBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	h.mu.Lock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += v
+	h.mu.Unlock()
+}
+
+type HistogramVec struct {
+	name    string
+	help    string
+	buckets []float64
+	series
+}
+
+func NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{name: name, help: help, buckets: buckets, series: newSeries(labels)}
+}
+
+func (v *HistogramVec) With(values ...string) *Histogram {
+	return v.get(values, func() interface{} {
+		return &Histogram{buckets: v.buckets, counts: make([]uint64, len(v.buckets))}
+	}).(*Histogram)
+}
+
+func (v *HistogramVec) Collect(w io.Writer) {
+	writeHeader(w, v.name, v.help, "histogram")
+	v.each(func(values []string, item interface{}) {
+		h := item.(*Histogram)
+		h.mu.Lock()
+		counts, count, sum := append([]uint64(nil), h.counts...), h.count, h.sum
+		h.mu.Unlock()
+		var cumulative uint64
+		for i, le := range v.buckets {
+			cumulative += counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, labelString(v.labels, values, `le="`+formatFloat(le)+`"`), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, labelString(v.labels, values, `le="+Inf"`), count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", v.name, labelString(v.labels, values, ""), formatFloat(sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", v.name, labelString(v.labels, values, ""), count)
+	})
+}
+
+// Func reads its samples at collection time, for values owned by other
+// packages such as pool or cache statistics.
+type Func struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+	fn     func(emit func(value float64, labelValues ...string))
+}
+
+// NewGaugeFunc and NewCounterFunc create a Func of the given type.
+func NewGaugeFunc(name string, help string, labels []string, fn func(emit func(value float64, labelValues ...string))) *Func {
+	return &Func{name: name, help: help, typ: "gauge", labels: labels, fn: fn}
+}
+
+func NewCounterFunc(name string, help string, labels []string, fn func(emit func(value float64, labelValues ...string))) *Func {
+	return &Func{name: name, help: help, typ: "counter", labels: labels, fn: fn}
+}
+
+func (f *Func) Collect(w io.Writer) {
+	writeHeader(w, f.name, f.help, f.typ)
+	f.fn(func(value float64, labelValues ...string) {
+		fmt.Fprintf(w, "%s%s %s\n", f.name, labelString(f.labels, labelValues, ""), formatFloat(value))
+	})
+}
@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestExposition(t *testing.T) {
+	requests := NewCounterVec("requests_total", "Requests.", "route", "code")
+	requests.With("/b", "200").Add(2)
+	requests.With("/a", "404").Inc()
+	requests.With("/a", "404").Inc()
+	latency := NewHistogramVec("latency_seconds", "Latency.", []float64{0.1, 1}, "route")
+	h := latency.With("/a")
+	h.Observe(0.05)
+	h.Observe(0.1)
+	h.Observe(0.5)
+	h.Observe(3)
+	gauge := NewGaugeFunc("entries", "Entries.", []string{"cache"}, func(emit func(float64, ...string)) {
+		emit(3, `we"ird\`)
+		emit(1.5, "line\nbreak")
+	})
+
+	r := &Registry{}
+	r.Register(requests)
+	r.Register(latency)
+	r.Register(gauge)
+	var buf bytes.Buffer
+	if err := r.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP requests_total Requests.
+# TYPE requests_total counter
+requests_total{route="/a",code="404"} 2
+requests_total{route="/b",code="200"} 2
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/a",le="0.1"} 2
+latency_seconds_bucket{route="/a",le="1"} 3
+latency_seconds_bucket{route="/a",le="+Inf"} 4
+latency_seconds_sum{route="/a"} 3.65
+latency_seconds_count{route="/a"} 4
+# HELP entries Entries.
+# TYPE entries gauge
+entries{cache="we\"ird\\"} 3
+entries{cache="line\nbreak"} 1.5
+`
+	if got := buf.String(); got != want {
+		t.Errorf("exposition:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestWrongLabelCount(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("With accepted a wrong number of label values")
+		}
+	}()
+	NewCounterVec("c", "C.", "a", "b").With("x")
+}
+
+func TestFormatFloat(t *testing.T) {
+	tests := map[float64]string{0: "0", 0.25: "0.25", 1e21: "1e+21"}
+	for v, want := range tests {
+		if got := formatFloat(v); got != want {
+			t.Errorf("formatFloat(%v) = %s, want %s", v, got, want)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package models
+
+// ArchiveSummary Количество загруженных из архива объектов.
+//
+// swagger:model ArchiveSummary
+type ArchiveSummary struct {
+
+	// Форум, в который загружен архив.
+	Forum string `json:"forum"`
+
+	// Количество загруженных сообщений.
+	Posts int64 `json:"posts"`
+
+	// Количество загруженных ветвей обсуждения.
+	Threads int64 `json:"threads"`
+
+	// Количество новых пользователей.
+	Users int64 `json:"users"`
+
+	// Количество загруженных голосов.
+	Votes int64 `json:"votes"`
+}
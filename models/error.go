@@ -9,9 +9,29 @@ package models
 // swagger:model Error
 type Error struct {
 
+	// Машиночитаемый код ошибки из каталога, например user_not_found.
+	// Read Only: true
+	Code string `json:"code,omitempty"`
+
+	// HTTP статус ответа.
+	// Read Only: true
+	Status int32 `json:"status,omitempty"`
+
 	// Текстовое описание ошибки.
 	// В процессе проверки API никаких проверок на содерижимое данного описание не делается.
 	//
 	// Read Only: true
 	Message string `json:"message,omitempty"`
+
+	// Идентификатор запроса (X-Request-ID), в котором произошла ошибка.
+	// Read Only: true
+	RequestID string `json:"requestId,omitempty"`
+
+	// Параметр или поле запроса, к которому относится ошибка.
+	// Read Only: true
+	Field string `json:"field,omitempty"`
+
+	// Ошибки проверки запроса по параметрам и полям.
+	// Read Only: true
+	Fields []*FieldError `json:"fields,omitempty"`
 }
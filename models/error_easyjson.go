@@ -36,8 +36,49 @@ func easyjsonE34310f8DecodeDbForumModels(in *jlexer.Lexer, out *Error) {
 			continue
 		}
 		switch key {
+		case "code":
+			out.Code = string(in.String())
+		case "status":
+			out.Status = int32(in.Int32())
 		case "message":
 			out.Message = string(in.String())
+		case "requestId":
+			out.RequestID = string(in.String())
+		case "field":
+			out.Field = string(in.String())
+		case "fields":
+			if in.IsNull() {
+				in.Skip()
+				out.Fields = nil
+			} else {
+				in.Delim('[')
+				if out.Fields == nil {
+					if !in.IsDelim(']') {
+						out.Fields = make([]*FieldError, 0, 8)
+					} else {
+						out.Fields = []*FieldError{}
+					}
+				} else {
+					out.Fields = (out.Fields)[:0]
+				}
+				for !in.IsDelim(']') {
+					var v1 *FieldError
+					if in.IsNull() {
+						in.Skip()
+						v1 = nil
+					} else {
+						if v1 == nil {
+							v1 = new(FieldError)
+						}
+						if data := in.Raw(); in.Ok() {
+							in.AddError((*v1).UnmarshalJSON(data))
+						}
+					}
+					out.Fields = append(out.Fields, v1)
+					in.WantComma()
+				}
+				in.Delim(']')
+			}
 		default:
 			in.SkipRecursive()
 		}
@@ -52,6 +93,26 @@ func easyjsonE34310f8EncodeDbForumModels(out *jwriter.Writer, in Error) {
 	out.RawByte('{')
 	first := true
 	_ = first
+	if in.Code != "" {
+		const prefix string = ",\"code\":"
+		if first {
+			first = false
+			out.RawString(prefix[1:])
+		} else {
+			out.RawString(prefix)
+		}
+		out.String(string(in.Code))
+	}
+	if in.Status != 0 {
+		const prefix string = ",\"status\":"
+		if first {
+			first = false
+			out.RawString(prefix[1:])
+		} else {
+			out.RawString(prefix)
+		}
+		out.Int32(int32(in.Status))
+	}
 	if in.Message != "" {
 		const prefix string = ",\"message\":"
 		if first {
@@ -62,6 +123,49 @@ func easyjsonE34310f8EncodeDbForumModels(out *jwriter.Writer, in Error) {
 		}
 		out.String(string(in.Message))
 	}
+	if in.RequestID != "" {
+		const prefix string = ",\"requestId\":"
+		if first {
+			first = false
+			out.RawString(prefix[1:])
+		} else {
+			out.RawString(prefix)
+		}
+		out.String(string(in.RequestID))
+	}
+	if in.Field != "" {
+		const prefix string = ",\"field\":"
+		if first {
+			first = false
+			out.RawString(prefix[1:])
+		} else {
+			out.RawString(prefix)
+		}
+		out.String(string(in.Field))
+	}
+	if len(in.Fields) != 0 {
+		const prefix string = ",\"fields\":"
+		if first {
+			first = false
+			out.RawString(prefix[1:])
+		} else {
+			out.RawString(prefix)
+		}
+		{
+			out.RawByte('[')
+			for v2, v3 := range in.Fields {
+				if v2 > 0 {
+					out.RawByte(',')
+				}
+				if v3 == nil {
+					out.RawString("null")
+				} else {
+					out.Raw((*v3).MarshalJSON())
+				}
+			}
+			out.RawByte(']')
+		}
+	}
 	out.RawByte('}')
 }
 
@@ -0,0 +1,16 @@
+package models
+
+// FieldError Ошибка проверки одного параметра или поля запроса.
+//
+// swagger:model FieldError
+type FieldError struct {
+
+	// Параметр или поле тела запроса.
+	Field string `json:"field,omitempty"`
+
+	// Часть запроса: path, query или body.
+	In string `json:"in,omitempty"`
+
+	// Описание ошибки.
+	Message string `json:"message,omitempty"`
+}
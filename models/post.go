@@ -27,6 +27,10 @@ type Post struct {
 	// Read Only: true
 	Forum string `json:"forum,omitempty"`
 
+	// HTML-представление сообщения (только при запросе с render=html).
+	// Read Only: true
+	HTML string `json:"html,omitempty"`
+
 	// Идентификатор данного сообщения.
 	// Read Only: true
 	ID int64 `json:"id,omitempty"`
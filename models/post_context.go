@@ -0,0 +1,16 @@
+package models
+
+// PostContext Сообщение вместе с цепочкой предков и ограниченным поддеревом ответов.
+//
+// swagger:model PostContext
+type PostContext struct {
+
+	// Предки сообщения, начиная с корневого сообщения обсуждения.
+	Ancestors []Post `json:"ancestors"`
+
+	// Ответы на сообщение, вложенные по уровням.
+	Descendants []*PostTree `json:"descendants"`
+
+	// post
+	Post *Post `json:"post"`
+}
@@ -0,0 +1,208 @@
+// Code generated by easyjson for marshaling/unmarshaling. DO NOT EDIT.
+
+package models
+
+import (
+	json "encoding/json"
+	easyjson "github.com/mailru/easyjson"
+	jlexer "github.com/mailru/easyjson/jlexer"
+	jwriter "github.com/mailru/easyjson/jwriter"
+)
+
+// suppress unused package warning
+var (
+	_ *json.RawMessage
+	_ *jlexer.Lexer
+	_ *jwriter.Writer
+	_ easyjson.Marshaler
+)
+
+func easyjson4793a5d6DecodeDbForumModels(in *jlexer.Lexer, out *PostContext) {
+	isTopLevel := in.IsStart()
+	if in.IsNull() {
+		if isTopLevel {
+			in.Consumed()
+		}
+		in.Skip()
+		return
+	}
+	in.Delim('{')
+	for !in.IsDelim('}') {
+		key := in.UnsafeString()
+		in.WantColon()
+		if in.IsNull() {
+			in.Skip()
+			in.WantComma()
+			continue
+		}
+		switch key {
+		case "ancestors":
+			if in.IsNull() {
+				in.Skip()
+				out.Ancestors = nil
+			} else {
+				in.Delim('[')
+				if out.Ancestors == nil {
+					if !in.IsDelim(']') {
+						out.Ancestors = make([]Post, 0, 1)
+					} else {
+						out.Ancestors = []Post{}
+					}
+				} else {
+					out.Ancestors = (out.Ancestors)[:0]
+				}
+				for !in.IsDelim(']') {
+					var v1 Post
+					if data := in.Raw(); in.Ok() {
+						in.AddError((v1).UnmarshalJSON(data))
+					}
+					out.Ancestors = append(out.Ancestors, v1)
+					in.WantComma()
+				}
+				in.Delim(']')
+			}
+		case "descendants":
+			if in.IsNull() {
+				in.Skip()
+				out.Descendants = nil
+			} else {
+				in.Delim('[')
+				if out.Descendants == nil {
+					if !in.IsDelim(']') {
+						out.Descendants = make([]*PostTree, 0, 8)
+					} else {
+						out.Descendants = []*PostTree{}
+					}
+				} else {
+					out.Descendants = (out.Descendants)[:0]
+				}
+				for !in.IsDelim(']') {
+					var v2 *PostTree
+					if in.IsNull() {
+						in.Skip()
+						v2 = nil
+					} else {
+						if v2 == nil {
+							v2 = new(PostTree)
+						}
+						if data := in.Raw(); in.Ok() {
+							in.AddError((*v2).UnmarshalJSON(data))
+						}
+					}
+					out.Descendants = append(out.Descendants, v2)
+					in.WantComma()
+				}
+				in.Delim(']')
+			}
+		case "post":
+			if in.IsNull() {
+				in.Skip()
+				out.Post = nil
+			} else {
+				if out.Post == nil {
+					out.Post = new(Post)
+				}
+				if data := in.Raw(); in.Ok() {
+					in.AddError((*out.Post).UnmarshalJSON(data))
+				}
+			}
+		default:
+			in.SkipRecursive()
+		}
+		in.WantComma()
+	}
+	in.Delim('}')
+	if isTopLevel {
+		in.Consumed()
+	}
+}
+func easyjson4793a5d6EncodeDbForumModels(out *jwriter.Writer, in PostContext) {
+	out.RawByte('{')
+	first := true
+	_ = first
+	{
+		const prefix string = ",\"ancestors\":"
+		if first {
+			first = false
+			out.RawString(prefix[1:])
+		} else {
+			out.RawString(prefix)
+		}
+		if in.Ancestors == nil && (out.Flags&jwriter.NilSliceAsEmpty) == 0 {
+			out.RawString("null")
+		} else {
+			out.RawByte('[')
+			for v3, v4 := range in.Ancestors {
+				if v3 > 0 {
+					out.RawByte(',')
+				}
+				out.Raw((v4).MarshalJSON())
+			}
+			out.RawByte(']')
+		}
+	}
+	{
+		const prefix string = ",\"descendants\":"
+		if first {
+			first = false
+			out.RawString(prefix[1:])
+		} else {
+			out.RawString(prefix)
+		}
+		if in.Descendants == nil && (out.Flags&jwriter.NilSliceAsEmpty) == 0 {
+			out.RawString("null")
+		} else {
+			out.RawByte('[')
+			for v5, v6 := range in.Descendants {
+				if v5 > 0 {
+					out.RawByte(',')
+				}
+				if v6 == nil {
+					out.RawString("null")
+				} else {
+					out.Raw((*v6).MarshalJSON())
+				}
+			}
+			out.RawByte(']')
+		}
+	}
+	{
+		const prefix string = ",\"post\":"
+		if first {
+			first = false
+			out.RawString(prefix[1:])
+		} else {
+			out.RawString(prefix)
+		}
+		if in.Post == nil {
+			out.RawString("null")
+		} else {
+			out.Raw((*in.Post).MarshalJSON())
+		}
+	}
+	out.RawByte('}')
+}
+
+// MarshalJSON supports json.Marshaler interface
+func (v PostContext) MarshalJSON() ([]byte, error) {
+	w := jwriter.Writer{}
+	easyjson4793a5d6EncodeDbForumModels(&w, v)
+	return w.Buffer.BuildBytes(), w.Error
+}
+
+// MarshalEasyJSON supports easyjson.Marshaler interface
+func (v PostContext) MarshalEasyJSON(w *jwriter.Writer) {
+	easyjson4793a5d6EncodeDbForumModels(w, v)
+}
+
+// UnmarshalJSON supports json.Unmarshaler interface
+func (v *PostContext) UnmarshalJSON(data []byte) error {
+	r := jlexer.Lexer{Data: data}
+	easyjson4793a5d6DecodeDbForumModels(&r, v)
+	return r.Error()
+}
+
+// UnmarshalEasyJSON supports easyjson.Unmarshaler interface
+func (v *PostContext) UnmarshalEasyJSON(l *jlexer.Lexer) {
+	easyjson4793a5d6DecodeDbForumModels(l, v)
+}
@@ -53,6 +53,8 @@ func easyjson5a72dc82DecodeDbForumModels(in *jlexer.Lexer, out *Post) {
 			}
 		case "forum":
 			out.Forum = string(in.String())
+		case "html":
+			out.HTML = string(in.String())
 		case "id":
 			out.ID = int64(in.Int64())
 		case "isEdited":
@@ -107,6 +109,16 @@ func easyjson5a72dc82EncodeDbForumModels(out *jwriter.Writer, in Post) {
 		}
 		out.String(string(in.Forum))
 	}
+	if in.HTML != "" {
+		const prefix string = ",\"html\":"
+		if first {
+			first = false
+			out.RawString(prefix[1:])
+		} else {
+			out.RawString(prefix)
+		}
+		out.String(string(in.HTML))
+	}
 	if in.ID != 0 {
 		const prefix string = ",\"id\":"
 		if first {
@@ -196,10 +196,14 @@ func easyjson95e8944cDecodeDbForumModels2(in *jlexer.Lexer, out *Thread) {
 			}
 		case "forum":
 			out.Forum = string(in.String())
+		case "html":
+			out.HTML = string(in.String())
 		case "id":
 			out.ID = int32(in.Int32())
 		case "message":
 			out.Message = string(in.String())
+		case "slowMode":
+			out.SlowMode = int32(in.Int32())
 		case "slug":
 			out.Slug = string(in.String())
 		case "title":
@@ -250,6 +254,16 @@ func easyjson95e8944cEncodeDbForumModels2(out *jwriter.Writer, in Thread) {
 		}
 		out.String(string(in.Forum))
 	}
+	if in.HTML != "" {
+		const prefix string = ",\"html\":"
+		if first {
+			first = false
+			out.RawString(prefix[1:])
+		} else {
+			out.RawString(prefix)
+		}
+		out.String(string(in.HTML))
+	}
 	if in.ID != 0 {
 		const prefix string = ",\"id\":"
 		if first {
@@ -270,6 +284,16 @@ func easyjson95e8944cEncodeDbForumModels2(out *jwriter.Writer, in Thread) {
 		}
 		out.String(string(in.Message))
 	}
+	if in.SlowMode != 0 {
+		const prefix string = ",\"slowMode\":"
+		if first {
+			first = false
+			out.RawString(prefix[1:])
+		} else {
+			out.RawString(prefix)
+		}
+		out.Int32(int32(in.SlowMode))
+	}
 	if in.Slug != "" {
 		const prefix string = ",\"slug\":"
 		if first {
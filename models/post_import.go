@@ -0,0 +1,10 @@
+package models
+
+// PostImport Результат массовой загрузки сообщений в ветку обсуждения.
+//
+// swagger:model PostImport
+type PostImport struct {
+
+	// Количество загруженных сообщений.
+	Imported int64 `json:"imported"`
+}
@@ -0,0 +1,14 @@
+package models
+
+// PostMove Перенос сообщения вместе со всеми ответами.
+//
+// swagger:model PostMove
+type PostMove struct {
+
+	// Новый родитель сообщения (0 - сделать сообщение корневым).
+	Parent int64 `json:"parent,omitempty"`
+
+	// Ветка обсуждения (slug или id), в которую переносится сообщение.
+	// Пустое значение оставляет сообщение в текущей ветке.
+	Thread string `json:"thread,omitempty"`
+}
@@ -0,0 +1,14 @@
+package models
+
+// PostTree Сообщение вместе с вложенными ответами.
+//
+// swagger:model PostTree
+type PostTree struct {
+	Post
+
+	// Ответы на данное сообщение в порядке сортировки.
+	Children []*PostTree `json:"children"`
+
+	// Кол-во ответов, скрытых ограничением глубины.
+	Collapsed int64 `json:"collapsed,omitempty"`
+}
@@ -26,6 +26,10 @@ type Thread struct {
 	// Read Only: true
 	Forum string `json:"forum,omitempty"`
 
+	// HTML-представление описания ветки (только при запросе с render=html).
+	// Read Only: true
+	HTML string `json:"html,omitempty"`
+
 	// Идентификатор ветки обсуждения.
 	// Read Only: true
 	ID int32 `json:"id,omitempty"`
@@ -34,6 +38,12 @@ type Thread struct {
 	// Required: true
 	Message string `json:"message"`
 
+	// Минимальный интервал в секундах между сообщениями одного пользователя в ветке.
+	// 0 — без ограничений.
+	//
+	// Read Only: true
+	SlowMode int32 `json:"slowMode,omitempty"`
+
 	// Человекопонятный URL (https://ru.wikipedia.org/wiki/%D0%A1%D0%B5%D0%BC%D0%B0%D0%BD%D1%82%D0%B8%D1%87%D0%B5%D1%81%D0%BA%D0%B8%D0%B9_URL).
 	// В данной структуре slug опционален и не может быть числом.
 	//
@@ -53,10 +53,14 @@ func easyjson2d00218DecodeDbForumModels(in *jlexer.Lexer, out *Thread) {
 			}
 		case "forum":
 			out.Forum = string(in.String())
+		case "html":
+			out.HTML = string(in.String())
 		case "id":
 			out.ID = int32(in.Int32())
 		case "message":
 			out.Message = string(in.String())
+		case "slowMode":
+			out.SlowMode = int32(in.Int32())
 		case "slug":
 			out.Slug = string(in.String())
 		case "title":
@@ -107,6 +111,16 @@ func easyjson2d00218EncodeDbForumModels(out *jwriter.Writer, in Thread) {
 		}
 		out.String(string(in.Forum))
 	}
+	if in.HTML != "" {
+		const prefix string = ",\"html\":"
+		if first {
+			first = false
+			out.RawString(prefix[1:])
+		} else {
+			out.RawString(prefix)
+		}
+		out.String(string(in.HTML))
+	}
 	if in.ID != 0 {
 		const prefix string = ",\"id\":"
 		if first {
@@ -127,6 +141,16 @@ func easyjson2d00218EncodeDbForumModels(out *jwriter.Writer, in Thread) {
 		}
 		out.String(string(in.Message))
 	}
+	if in.SlowMode != 0 {
+		const prefix string = ",\"slowMode\":"
+		if first {
+			first = false
+			out.RawString(prefix[1:])
+		} else {
+			out.RawString(prefix)
+		}
+		out.Int32(int32(in.SlowMode))
+	}
 	if in.Slug != "" {
 		const prefix string = ",\"slug\":"
 		if first {
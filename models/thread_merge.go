@@ -0,0 +1,11 @@
+package models
+
+// ThreadMerge Слияние ветки обсуждения с другой веткой.
+//
+// swagger:model ThreadMerge
+type ThreadMerge struct {
+
+	// Ветка обсуждения (slug или id), в которую переносятся все сообщения.
+	// Required: true
+	Thread string `json:"thread"`
+}
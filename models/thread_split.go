@@ -0,0 +1,24 @@
+package models
+
+// ThreadSplit Выделение поддерева сообщений в новую ветку обсуждения.
+//
+// swagger:model ThreadSplit
+type ThreadSplit struct {
+
+	// Автор новой ветки (по умолчанию - автор выделяемого сообщения).
+	Author string `json:"author,omitempty"`
+
+	// Описание новой ветки (по умолчанию - текст выделяемого сообщения).
+	Message string `json:"message,omitempty"`
+
+	// Сообщение, которое станет корнем новой ветки.
+	// Required: true
+	Post int64 `json:"post"`
+
+	// Человекопонятный URL новой ветки.
+	Slug string `json:"slug,omitempty"`
+
+	// Заголовок новой ветки.
+	// Required: true
+	Title string `json:"title"`
+}
@@ -14,6 +14,12 @@ type ThreadUpdate struct {
 	// Описание ветки обсуждения.
 	Message string `json:"message,omitempty"`
 
+	// Минимальный интервал в секундах между сообщениями одного пользователя в ветке.
+	// 0 отключает медленный режим.
+	//
+	// Minimum: 0
+	SlowMode *int32 `json:"slowMode,omitempty"`
+
 	// Заголовок ветки обсуждения.
 	Title string `json:"title,omitempty"`
 }
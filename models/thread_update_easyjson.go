@@ -38,6 +38,16 @@ func easyjson7949aa42DecodeDbForumModels(in *jlexer.Lexer, out *ThreadUpdate) {
 		switch key {
 		case "message":
 			out.Message = string(in.String())
+		case "slowMode":
+			if in.IsNull() {
+				in.Skip()
+				out.SlowMode = nil
+			} else {
+				if out.SlowMode == nil {
+					out.SlowMode = new(int32)
+				}
+				*out.SlowMode = int32(in.Int32())
+			}
 		case "title":
 			out.Title = string(in.String())
 		default:
@@ -64,6 +74,16 @@ func easyjson7949aa42EncodeDbForumModels(out *jwriter.Writer, in ThreadUpdate) {
 		}
 		out.String(string(in.Message))
 	}
+	if in.SlowMode != nil {
+		const prefix string = ",\"slowMode\":"
+		if first {
+			first = false
+			out.RawString(prefix[1:])
+		} else {
+			out.RawString(prefix)
+		}
+		out.Int32(int32(*in.SlowMode))
+	}
 	if in.Title != "" {
 		const prefix string = ",\"title\":"
 		if first {
@@ -0,0 +1,177 @@
+package models
+
+// The Validate methods the go-swagger server needs are written here by
+// hand, and validate_test.go checks them against swagger.yaml. Models
+// regenerated with go-swagger come with their own, which replace these.
+
+import (
+	"strconv"
+
+	strfmt "github.com/go-openapi/strfmt"
+
+	"github.com/go-openapi/errors"
+	"github.com/go-openapi/validate"
+)
+
+// slugPattern is the pattern of forum and thread slugs in the spec.
+const slugPattern = `^(\d|\w|-|_)*(\w|-|_)(\d|\w|-|_)*$`
+
+var voices = []interface{}{int32(-1), int32(1)}
+
+// Posts is the list of posts in request and response bodies.
+type Posts []*Post
+
+// Threads is the list of threads in response bodies.
+type Threads []*Thread
+
+// Users is the list of users in response bodies.
+type Users []*User
+
+// Validate validates this forum
+func (m *Forum) Validate(formats strfmt.Registry) error {
+	return composite(
+		first(validate.RequiredString("slug", "body", m.Slug), validate.Pattern("slug", "body", m.Slug, slugPattern)),
+		validate.RequiredString("title", "body", m.Title),
+		validate.RequiredString("user", "body", m.User),
+	)
+}
+
+// Validate validates this post
+func (m *Post) Validate(formats strfmt.Registry) error {
+	return composite(
+		validate.RequiredString("author", "body", m.Author),
+		dateTime("created", m.Created, formats),
+		validate.RequiredString("message", "body", m.Message),
+	)
+}
+
+// Validate validates this post update
+func (m *PostUpdate) Validate(formats strfmt.Registry) error {
+	return nil
+}
+
+// Validate validates this thread
+func (m *Thread) Validate(formats strfmt.Registry) error {
+	var slug *errors.Validation
+	if m.Slug != "" {
+		slug = validate.Pattern("slug", "body", m.Slug, slugPattern)
+	}
+	return composite(
+		validate.RequiredString("author", "body", m.Author),
+		dateTime("created", m.Created, formats),
+		validate.RequiredString("message", "body", m.Message),
+		slug,
+		validate.RequiredString("title", "body", m.Title),
+	)
+}
+
+// Validate validates this thread update
+func (m *ThreadUpdate) Validate(formats strfmt.Registry) error {
+	if m.SlowMode == nil {
+		return nil
+	}
+	return composite(validate.MinimumInt("slowMode", "body", int64(*m.SlowMode), 0, false))
+}
+
+// Validate validates this user
+func (m *User) Validate(formats strfmt.Registry) error {
+	return composite(
+		first(validate.RequiredString("email", "body", m.Email), validate.FormatOf("email", "body", "email", m.Email, formats)),
+		validate.RequiredString("fullname", "body", m.Fullname),
+	)
+}
+
+// Validate validates this user update
+func (m *UserUpdate) Validate(formats strfmt.Registry) error {
+	if m.Email == "" {
+		return nil
+	}
+	return composite(validate.FormatOf("email", "body", "email", m.Email.String(), formats))
+}
+
+// Validate validates this vote
+func (m *Vote) Validate(formats strfmt.Registry) error {
+	return composite(
+		validate.RequiredString("nickname", "body", m.Nickname),
+		first(validate.Required("voice", "body", m.Voice), validate.Enum("voice", "body", m.Voice, voices)),
+	)
+}
+
+// Validate validates this posts
+func (m Posts) Validate(formats strfmt.Registry) error {
+	for i, post := range m {
+		if post == nil {
+			continue
+		}
+		if err := post.Validate(formats); err != nil {
+			return itemError(i, err)
+		}
+	}
+	return nil
+}
+
+// Validate validates this threads
+func (m Threads) Validate(formats strfmt.Registry) error {
+	for i, thread := range m {
+		if thread == nil {
+			continue
+		}
+		if err := thread.Validate(formats); err != nil {
+			return itemError(i, err)
+		}
+	}
+	return nil
+}
+
+// Validate validates this users
+func (m Users) Validate(formats strfmt.Registry) error {
+	for i, user := range m {
+		if user == nil {
+			continue
+		}
+		if err := user.Validate(formats); err != nil {
+			return itemError(i, err)
+		}
+	}
+	return nil
+}
+
+// composite returns the errors of the checks that failed as one error.
+func composite(checks ...*errors.Validation) error {
+	var res []error
+	for _, err := range checks {
+		if err != nil {
+			res = append(res, err)
+		}
+	}
+	if len(res) > 0 {
+		return errors.CompositeValidationError(res...)
+	}
+	return nil
+}
+
+// first returns the first error of checks of one field, as the later ones
+// only make sense if the earlier passed.
+func first(checks ...*errors.Validation) *errors.Validation {
+	for _, err := range checks {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func dateTime(name string, value *strfmt.DateTime, formats strfmt.Registry) *errors.Validation {
+	if value == nil {
+		return nil
+	}
+	return validate.FormatOf(name, "body", "date-time", value.String(), formats)
+}
+
+// itemError names the field of err after the item index of a list.
+func itemError(i int, err error) error {
+	if ve, ok := err.(*errors.Validation); ok {
+		return ve.ValidateName(strconv.Itoa(i))
+	}
+	return err
+}
@@ -0,0 +1,126 @@
+package models
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	strfmt "github.com/go-openapi/strfmt"
+	yaml "gopkg.in/yaml.v2"
+)
+
+type property struct {
+	Format  string        `yaml:"format"`
+	Pattern string        `yaml:"pattern"`
+	Enum    []interface{} `yaml:"enum"`
+	Minimum *int64        `yaml:"minimum"`
+}
+
+type definition struct {
+	Required   []string            `yaml:"required"`
+	Properties map[string]property `yaml:"properties"`
+}
+
+type validatable interface {
+	Validate(formats strfmt.Registry) error
+}
+
+// TestValidateMatchesSpec checks that the hand-written Validate methods
+// enforce what swagger.yaml requires of each definition: required
+// properties, patterns, email formats, enums and minimums.
+func TestValidateMatchesSpec(t *testing.T) {
+	data, err := ioutil.ReadFile("../swagger.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var spec struct {
+		Definitions map[string]definition `yaml:"definitions"`
+	}
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		t.Fatal(err)
+	}
+	models := map[string]func() validatable{
+		"Forum":        func() validatable { return &Forum{} },
+		"Post":         func() validatable { return &Post{} },
+		"PostUpdate":   func() validatable { return &PostUpdate{} },
+		"Thread":       func() validatable { return &Thread{} },
+		"ThreadUpdate": func() validatable { return &ThreadUpdate{} },
+		"User":         func() validatable { return &User{} },
+		"UserUpdate":   func() validatable { return &UserUpdate{} },
+		"Vote":         func() validatable { return &Vote{} },
+	}
+	for name, model := range models {
+		def, ok := spec.Definitions[name]
+		if !ok {
+			t.Errorf("%s is not defined in the spec", name)
+			continue
+		}
+		validateErr := func(body string) string {
+			m := model()
+			if err := json.Unmarshal([]byte(body), m); err != nil {
+				t.Fatalf("%s: can't decode %s: %v", name, body, err)
+			}
+			if err := m.Validate(strfmt.Default); err != nil {
+				return err.Error()
+			}
+			return ""
+		}
+
+		got := validateErr("{}")
+		required := make(map[string]bool)
+		for _, prop := range def.Required {
+			required[prop] = true
+			if !strings.Contains(got, prop+" in body is required") {
+				t.Errorf("%s: empty body passes required %s: %q", name, prop, got)
+			}
+		}
+		for prop := range def.Properties {
+			if !required[prop] && strings.Contains(got, prop+" in body") {
+				t.Errorf("%s: empty body fails optional %s: %q", name, prop, got)
+			}
+		}
+
+		for prop, p := range def.Properties {
+			var bad string
+			switch {
+			case p.Pattern != "":
+				bad = `"not a slug"`
+			case p.Format == "email":
+				bad = `"not an email"`
+			case p.Enum != nil:
+				bad = "2"
+			case p.Minimum != nil:
+				bad = "-1"
+			default:
+				continue
+			}
+			if got := validateErr(`{"` + prop + `": ` + bad + `}`); !strings.Contains(got, prop+" in body") {
+				t.Errorf("%s: %s = %s passes: %q", name, prop, bad, got)
+			}
+		}
+	}
+}
+
+func TestValidateAcceptsValid(t *testing.T) {
+	slowMode := int32(0)
+	valid := []validatable{
+		&Forum{Slug: "pirate-stories", Title: "Pirate stories", User: "j.sparrow"},
+		&Post{Author: "j.sparrow", Message: "We should be afraid of the Kraken."},
+		&Thread{Author: "j.sparrow", Message: "An urgent need to reveal the hiding place", Title: "Davy Jones cache", Slug: "jones"},
+		&Thread{Author: "j.sparrow", Message: "No slug", Title: "Untitled"},
+		&ThreadUpdate{SlowMode: &slowMode},
+		&User{Email: "captain@example.com", Fullname: "Captain Jack Sparrow"},
+		&UserUpdate{},
+		&Vote{Nickname: "j.sparrow", Voice: -1},
+		Posts{{Author: "a", Message: "b"}, nil},
+	}
+	for _, m := range valid {
+		if err := m.Validate(strfmt.Default); err != nil {
+			t.Errorf("%#v: %v", m, err)
+		}
+	}
+	if err := (Posts{{Author: "a", Message: "b"}, {Author: "a"}}).Validate(strfmt.Default); err == nil {
+		t.Error("Posts with an invalid post pass")
+	}
+}
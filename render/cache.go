@@ -0,0 +1,32 @@
+package render
+
+import (
+	"crypto/sha256"
+
+	"db-forum/cache"
+)
+
+// rendered caches HTML by the SHA-256 of its source. Edited messages get
+// new keys, so entries never go stale and need no invalidation, also when
+// another instance made the edit.
+var rendered = cache.New(10000)
+
+// HTML returns Markdown(src) from the cache, rendering it on a miss.
+func HTML(src string) string {
+	sum := sha256.Sum256([]byte(src))
+	key := string(sum[:])
+	if res, ok := rendered.Get(key); ok {
+		return res.(string)
+	}
+	res := Markdown(src)
+	rendered.Add(key, res)
+	return res
+}
+
+func Reset() {
+	rendered.Purge()
+}
+
+func CacheStats() cache.Stats {
+	return rendered.Stats()
+}
@@ -0,0 +1,121 @@
+package render
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	strongRe = regexp.MustCompile(`\*\*([^*]+)\*\*`)
+	emRe     = regexp.MustCompile(`\*([^*\s][^*]*)\*`)
+	langRe   = regexp.MustCompile(`^[\w+-]+$`)
+	// URL | @mention | #post-id, not the tail of an escaped &#39;
+	linkRe = regexp.MustCompile(`https?://[^\s<]+|(^|[^\w&@/])@(\w+(?:\.\w+)*)|(^|[^\w&/;])#(\d+)\b`)
+)
+
+// Markdown renders a safe subset of Markdown: paragraphs, `> ` quotes,
+// fenced and inline code, **strong**, *em*, links, @mentions and #post-id
+// references. Source text is always escaped, so raw HTML never passes through.
+func Markdown(src string) string {
+	var buf strings.Builder
+	renderBlocks(&buf, strings.Split(strings.Replace(src, "\r\n", "\n", -1), "\n"))
+	return buf.String()
+}
+
+func renderBlocks(buf *strings.Builder, lines []string) {
+	para := make([]string, 0)
+	flush := func() {
+		if len(para) == 0 {
+			return
+		}
+		buf.WriteString("<p>")
+		buf.WriteString(strings.Replace(inline(strings.Join(para, "\n")), "\n", "<br>\n", -1))
+		buf.WriteString("</p>\n")
+		para = para[:0]
+	}
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "```"):
+			flush()
+			lang := strings.TrimSpace(trimmed[3:])
+			code := make([]string, 0)
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), "```"); i++ {
+				code = append(code, lines[i])
+			}
+			if langRe.MatchString(lang) {
+				buf.WriteString(`<pre><code class="language-` + lang + `">`)
+			} else {
+				buf.WriteString("<pre><code>")
+			}
+			buf.WriteString(html.EscapeString(strings.Join(code, "\n")))
+			buf.WriteString("</code></pre>\n")
+		case strings.HasPrefix(line, ">"):
+			flush()
+			quote := make([]string, 0)
+			for ; i < len(lines) && strings.HasPrefix(lines[i], ">"); i++ {
+				quote = append(quote, strings.TrimPrefix(lines[i][1:], " "))
+			}
+			i--
+			buf.WriteString("<blockquote>\n")
+			renderBlocks(buf, quote)
+			buf.WriteString("</blockquote>\n")
+		case trimmed == "":
+			flush()
+		default:
+			para = append(para, line)
+		}
+	}
+	flush()
+}
+
+func inline(text string) string {
+	var buf strings.Builder
+	parts := strings.Split(text, "`")
+	for i, part := range parts {
+		switch {
+		case i%2 == 0:
+			buf.WriteString(decorate(part))
+		case i < len(parts)-1:
+			buf.WriteString("<code>" + html.EscapeString(part) + "</code>")
+		default:
+			buf.WriteString("`" + decorate(part))
+		}
+	}
+	return buf.String()
+}
+
+func decorate(text string) string {
+	text = html.EscapeString(text)
+	text = strongRe.ReplaceAllString(text, "<strong>$1</strong>")
+	text = emRe.ReplaceAllString(text, "<em>$1</em>")
+	return linkify(text)
+}
+
+func linkify(text string) string {
+	var buf strings.Builder
+	last := 0
+	for _, m := range linkRe.FindAllStringSubmatchIndex(text, -1) {
+		switch {
+		case m[4] >= 0:
+			nickname := text[m[4]:m[5]]
+			buf.WriteString(text[last:m[3]])
+			fmt.Fprintf(&buf, `<a href="/api/user/%s/profile" class="mention">@%s</a>`, nickname, nickname)
+		case m[8] >= 0:
+			id := text[m[8]:m[9]]
+			buf.WriteString(text[last:m[7]])
+			fmt.Fprintf(&buf, `<a href="/api/post/%s/details" class="post-ref">#%s</a>`, id, id)
+		default:
+			url := strings.TrimRight(text[m[0]:m[1]], ".,;:!?)")
+			buf.WriteString(text[last:m[0]])
+			fmt.Fprintf(&buf, `<a href="%s" rel="nofollow">%s</a>`, url, url)
+			buf.WriteString(text[m[0]+len(url) : m[1]])
+		}
+		last = m[1]
+	}
+	buf.WriteString(text[last:])
+	return buf.String()
+}
@@ -0,0 +1,103 @@
+package render
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var (
+	tagRe     = regexp.MustCompile(`<[^>]*>`)
+	handlerRe = regexp.MustCompile(`(?i)[\s"/]on\w+\s*=`)
+)
+
+func TestMarkdown(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"paragraphs", "one\ntwo\n\nthree", "<p>one<br>\ntwo</p>\n<p>three</p>\n"},
+		{"crlf", "one\r\ntwo", "<p>one<br>\ntwo</p>\n"},
+		{"strong and em", "**bold** and *it*", "<p><strong>bold</strong> and <em>it</em></p>\n"},
+		{"inline code", "run `a < b` now", "<p>run <code>a &lt; b</code> now</p>\n"},
+		{"unclosed backtick", "a ` b", "<p>a ` b</p>\n"},
+		{"fenced code", "```go\nx := <-ch\n```", "<pre><code class=\"language-go\">x := &lt;-ch</code></pre>\n"},
+		{"fenced code bad lang", "```\"><script>\nx\n```", "<pre><code>x</code></pre>\n"},
+		{"quote", "> quoted\n> **line**\nafter", "<blockquote>\n<p>quoted<br>\n<strong>line</strong></p>\n</blockquote>\n<p>after</p>\n"},
+		{"nested quote", ">> deep", "<blockquote>\n<blockquote>\n<p>deep</p>\n</blockquote>\n</blockquote>\n"},
+		{"link", "see https://example.com/a?b=c.", "<p>see <a href=\"https://example.com/a?b=c\" rel=\"nofollow\">https://example.com/a?b=c</a>.</p>\n"},
+		{"mention", "hi @j.sparrow!", "<p>hi <a href=\"/api/user/j.sparrow/profile\" class=\"mention\">@j.sparrow</a>!</p>\n"},
+		{"email is not a mention", "mail a@b.com", "<p>mail a@b.com</p>\n"},
+		{"post ref", "see #42", "<p>see <a href=\"/api/post/42/details\" class=\"post-ref\">#42</a></p>\n"},
+		{"entity is not a post ref", "a &#39; b", "<p>a &amp;#39; b</p>\n"},
+		{"apostrophe", "it's #7", "<p>it&#39;s <a href=\"/api/post/7/details\" class=\"post-ref\">#7</a></p>\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Markdown(tt.src); got != tt.want {
+				t.Errorf("Markdown(%q) =\n%q\nwant\n%q", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestMarkdownSanitizes feeds HTML injection attempts and checks that no
+// markup but the renderer's own comes out.
+func TestMarkdownSanitizes(t *testing.T) {
+	attacks := []string{
+		"<script>alert(1)</script>",
+		"<img src=x onerror=alert(1)>",
+		"**<b onclick=alert(1)>**",
+		"*<i>*",
+		"`</code><script>`",
+		"> <iframe src=//evil>",
+		"```\n</code></pre><script>\n```",
+		"```js\"onmouseover=\"alert(1)\nx\n```",
+		"javascript:alert(1)",
+		"[x](javascript:alert(1))",
+		"https://example.com/\"onmouseover=\"alert(1)",
+		"https://example.com/<script>",
+		"@<script>",
+		"#1<script>",
+	}
+	allowed := []string{"<p>", "</p>", "<br>", "<strong>", "</strong>", "<em>", "</em>",
+		"<code>", "</code>", "<pre>", "</pre>", "<blockquote>", "</blockquote>", "</a>",
+		`<code class="language-`, `<a href="`}
+	for _, src := range attacks {
+		out := Markdown(src)
+		rest := out
+		for _, tag := range allowed {
+			rest = strings.Replace(rest, tag, "", -1)
+		}
+		if strings.Contains(rest, "<") {
+			t.Errorf("Markdown(%q) = %q lets markup through", src, out)
+		}
+		if strings.Contains(out, `href="javascript`) {
+			t.Errorf("Markdown(%q) = %q links to javascript:", src, out)
+		}
+		for _, tag := range tagRe.FindAllString(out, -1) {
+			if handlerRe.MatchString(tag) {
+				t.Errorf("Markdown(%q) = %q has an event handler in %s", src, out, tag)
+			}
+			if strings.Count(tag, `"`)%2 != 0 {
+				t.Errorf("Markdown(%q) = %q breaks out of an attribute in %s", src, out, tag)
+			}
+		}
+	}
+}
+
+func TestHTMLCachesBySource(t *testing.T) {
+	Reset()
+	first := HTML("**a**")
+	before := CacheStats()
+	if again := HTML("**a**"); again != first {
+		t.Errorf("cached HTML = %q, want %q", again, first)
+	}
+	if got := CacheStats().Hits; got != before.Hits+1 {
+		t.Errorf("hits = %d, want %d", got, before.Hits+1)
+	}
+	if edited := HTML("**b**"); edited != "<p><strong>b</strong></p>\n" {
+		t.Errorf("HTML of edited source = %q", edited)
+	}
+}
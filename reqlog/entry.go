@@ -0,0 +1,80 @@
+// Package reqlog defines the NDJSON request log written by the recording
+// middleware and replayed by forum-bench.
+package reqlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Entry is one recorded request with the response it got. Lines without a
+// method (such as backlog items sharing the request_id/body keys) are not
+// requests and are skipped by Reader.
+type Entry struct {
+	ID       string    `json:"request_id,omitempty"`
+	Time     time.Time `json:"time"`
+	Method   string    `json:"method"`
+	Path     string    `json:"path"`
+	Query    string    `json:"query,omitempty"`
+	Body     string    `json:"body,omitempty"`
+	Status   int       `json:"status,omitempty"`
+	Response string    `json:"response,omitempty"`
+	Latency  float64   `json:"latency_ms,omitempty"`
+
+	// BodyTruncated and ResponseTruncated tell that the recorder cut the
+	// body or the response to its max size.
+	BodyTruncated     bool `json:"body_truncated,omitempty"`
+	ResponseTruncated bool `json:"response_truncated,omitempty"`
+}
+
+// URI returns the path with the query string.
+func (e *Entry) URI() string {
+	if e.Query == "" {
+		return e.Path
+	}
+	return e.Path + "?" + e.Query
+}
+
+type Reader struct {
+	scanner *bufio.Scanner
+	line    int
+
+	// Skipped counts lines that are not requests.
+	Skipped int
+}
+
+const maxLine = 64 << 20
+
+func NewReader(r io.Reader) *Reader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), maxLine)
+	return &Reader{scanner: scanner}
+}
+
+// Next returns the next request entry or io.EOF.
+func (r *Reader) Next() (*Entry, error) {
+	for r.scanner.Scan() {
+		r.line++
+		line := r.scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return nil, errors.Wrapf(err, "line %d", r.line)
+		}
+		if entry.Method == "" || entry.Path == "" {
+			r.Skipped++
+			continue
+		}
+		return &entry, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "can't read request log")
+	}
+	return nil, io.EOF
+}
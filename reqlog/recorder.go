@@ -0,0 +1,157 @@
+package reqlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"db-forum/logger"
+
+	"github.com/pkg/errors"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	filePrefix = "requests-"
+	fileSuffix = ".jsonl"
+)
+
+type RecorderConfig struct {
+	// Dir receives requests-<time>.jsonl files.
+	Dir string
+	// Sample is the share of recorded requests, from 0 to 1.
+	Sample float64
+	// MaxFileSize rotates the current file once it grows past this size.
+	MaxFileSize int64
+	// MaxFiles is the number of files kept, 0 keeps all of them.
+	MaxFiles int
+	// MaxBodySize cuts longer request and response bodies, 0 keeps them whole.
+	MaxBodySize int
+}
+
+// Recorder writes sampled requests to rotating NDJSON files in the
+// format read by Reader. Entries are written by a background goroutine;
+// when it falls behind, entries are dropped rather than slowing requests.
+type Recorder struct {
+	config  RecorderConfig
+	entries chan *Entry
+	dropped uint64
+
+	file *os.File
+	buf  *bufio.Writer
+	size int64
+}
+
+func NewRecorder(config RecorderConfig) (*Recorder, error) {
+	if err := os.MkdirAll(config.Dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "can't create record dir")
+	}
+	rec := &Recorder{config: config, entries: make(chan *Entry, 4096)}
+	if err := rec.rotate(); err != nil {
+		return nil, err
+	}
+	go rec.run()
+	return rec, nil
+}
+
+// Dropped returns the number of entries lost because the writer was busy.
+func (rec *Recorder) Dropped() uint64 {
+	return atomic.LoadUint64(&rec.dropped)
+}
+
+func (rec *Recorder) Handler(handler fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if rec.config.Sample < 1 && rand.Float64() >= rec.config.Sample {
+			handler(ctx)
+			return
+		}
+		start := time.Now()
+		handler(ctx)
+		entry := &Entry{
+			ID:      string(ctx.Response.Header.Peek("X-Request-ID")),
+			Time:    start.UTC(),
+			Method:  string(ctx.Method()),
+			Path:    string(ctx.Path()),
+			Query:   RedactQuery(string(ctx.QueryArgs().QueryString())),
+			Status:  ctx.Response.StatusCode(),
+			Latency: float64(time.Since(start)) / float64(time.Millisecond),
+		}
+		entry.Body, entry.BodyTruncated = redactBody(ctx.PostBody(), rec.config.MaxBodySize)
+		if !ctx.Response.IsBodyStream() {
+			entry.Response, entry.ResponseTruncated = redactBody(ctx.Response.Body(), rec.config.MaxBodySize)
+		}
+		select {
+		case rec.entries <- entry:
+		default:
+			atomic.AddUint64(&rec.dropped, 1)
+		}
+	}
+}
+
+func (rec *Recorder) run() {
+	for entry := range rec.entries {
+		if err := rec.write(entry); err != nil {
+			logger.Default().Error(err.Error())
+		}
+		if len(rec.entries) == 0 {
+			if err := rec.buf.Flush(); err != nil {
+				logger.Default().Error("can't flush request log", "error", err)
+			}
+		}
+	}
+}
+
+func (rec *Recorder) write(entry *Entry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Wrap(err, "can't encode request log entry")
+	}
+	line = append(line, '\n')
+	if rec.config.MaxFileSize > 0 && rec.size > 0 && rec.size+int64(len(line)) > rec.config.MaxFileSize {
+		if err := rec.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := rec.buf.Write(line)
+	rec.size += int64(n)
+	return errors.Wrap(err, "can't write request log")
+}
+
+func (rec *Recorder) rotate() error {
+	if rec.file != nil {
+		if err := rec.buf.Flush(); err != nil {
+			return errors.Wrap(err, "can't flush request log")
+		}
+		rec.file.Close()
+	}
+	name := filepath.Join(rec.config.Dir, filePrefix+time.Now().UTC().Format("20060102-150405.000000")+fileSuffix)
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Wrap(err, "can't open request log")
+	}
+	rec.file, rec.buf, rec.size = file, bufio.NewWriterSize(file, 64<<10), 0
+	rec.prune()
+	return nil
+}
+
+func (rec *Recorder) prune() {
+	if rec.config.MaxFiles <= 0 {
+		return
+	}
+	names, err := filepath.Glob(filepath.Join(rec.config.Dir, filePrefix+"*"+fileSuffix))
+	if err != nil {
+		return
+	}
+	sort.Strings(names)
+	for len(names) > rec.config.MaxFiles {
+		if err := os.Remove(names[0]); err != nil {
+			logger.Default().Warn("can't remove old request log", "error", err)
+		}
+		names = names[1:]
+	}
+}
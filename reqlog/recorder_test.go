@@ -0,0 +1,64 @@
+package reqlog
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestRecorderCutsBodies(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reqlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rec, err := NewRecorder(RecorderConfig{Dir: dir, Sample: 1, MaxBodySize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := rec.Handler(func(ctx *fasthttp.RequestCtx) {
+		ctx.SetStatusCode(201)
+		ctx.SetBodyString(`{"message":"` + strings.Repeat("r", 100) + `"}`)
+	})
+	var ctx fasthttp.RequestCtx
+	ctx.Request.Header.SetMethod("POST")
+	ctx.Request.SetRequestURI("/api/forum/create")
+	ctx.Request.SetBodyString(`{"slug":"` + strings.Repeat("s", 100) + `"}`)
+	handler(&ctx)
+
+	var entry *Entry
+	for deadline := time.Now().Add(2 * time.Second); entry == nil && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		names, _ := filepath.Glob(filepath.Join(dir, filePrefix+"*"))
+		for _, name := range names {
+			f, err := os.Open(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e, err := NewReader(f).Next()
+			f.Close()
+			if err != nil && err != io.EOF {
+				t.Fatal(err)
+			}
+			entry = e
+		}
+	}
+	if entry == nil {
+		t.Fatal("no entry recorded")
+	}
+	if entry.Body != `{"slug":"` || !entry.BodyTruncated {
+		t.Errorf("body %q, truncated %v", entry.Body, entry.BodyTruncated)
+	}
+	if entry.Response != `{"message"` || !entry.ResponseTruncated {
+		t.Errorf("response %q, truncated %v", entry.Response, entry.ResponseTruncated)
+	}
+	if entry.Method != "POST" || entry.Path != "/api/forum/create" || entry.Status != 201 {
+		t.Errorf("entry %+v", entry)
+	}
+}
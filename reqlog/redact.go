@@ -0,0 +1,59 @@
+package reqlog
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var (
+	emailPattern  = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	secretPattern = regexp.MustCompile(`(?i)("(?:token|access_token|refresh_token|api_key|apikey|secret|password)"\s*:\s*)"(?:[^"\\]|\\.)*"`)
+	queryPattern  = regexp.MustCompile(`(?i)((?:^|&)(?:token|access_token|api_key|apikey|secret|password)=)[^&]*`)
+	bearerPattern = regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9\-._~+/]+=*`)
+
+	openSecretPattern = regexp.MustCompile(`(?i)("(?:token|access_token|refresh_token|api_key|apikey|secret|password)"\s*:\s*)"(?:[^"\\]|\\.)*\\?$`)
+)
+
+const redacted = "REDACTED"
+
+// Redact hides secrets and replaces emails with stable pseudonyms, so the
+// same address maps to the same fake one in requests and responses and
+// unique constraints still hold on replay.
+func Redact(s string) string {
+	if s == "" {
+		return s
+	}
+	s = emailPattern.ReplaceAllStringFunc(s, pseudonymEmail)
+	s = secretPattern.ReplaceAllString(s, `$1"`+redacted+`"`)
+	return bearerPattern.ReplaceAllString(s, "${1}"+redacted)
+}
+
+// redactBody returns b redacted and cut to max bytes, and whether it was
+// cut; max 0 is no limit. A cut may split an email or a secret that Redact
+// no longer recognizes, so the word cut at the end is dropped and a secret
+// value left open is hidden.
+func redactBody(b []byte, max int) (string, bool) {
+	if max <= 0 || len(b) <= max {
+		return Redact(string(b)), false
+	}
+	s := strings.TrimRightFunc(string(b[:max]), isTokenRune)
+	return openSecretPattern.ReplaceAllString(Redact(s), `$1"`+redacted), true
+}
+
+func isTokenRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("._%+-@~/=", r)
+}
+
+// RedactQuery hides secret query parameters.
+func RedactQuery(s string) string {
+	return queryPattern.ReplaceAllString(Redact(s), "${1}"+redacted)
+}
+
+func pseudonymEmail(email string) string {
+	h := fnv.New64a()
+	h.Write([]byte(strings.ToLower(email)))
+	return fmt.Sprintf("user-%016x@redacted.invalid", h.Sum64())
+}
@@ -0,0 +1,58 @@
+package reqlog
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	email := pseudonymEmail("Jack@Sparrow.com")
+	if email != pseudonymEmail("jack@sparrow.com") || !strings.HasSuffix(email, "@redacted.invalid") {
+		t.Fatalf("pseudonymEmail = %q", email)
+	}
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"", ""},
+		{`{"email":"jack@sparrow.com"}`, `{"email":"` + email + `"}`},
+		{`{"password": "p\"w", "about":"x"}`, `{"password": "REDACTED", "about":"x"}`},
+		{`{"Token":"abc"}`, `{"Token":"REDACTED"}`},
+		{`Authorization: Bearer abc.def=`, `Authorization: Bearer REDACTED`},
+		{`{"nickname":"password"}`, `{"nickname":"password"}`},
+	}
+	for _, tt := range tests {
+		if got := Redact(tt.src); got != tt.want {
+			t.Errorf("Redact(%q) = %q, want %q", tt.src, got, tt.want)
+		}
+	}
+	if got := RedactQuery("limit=5&token=abc&desc=true"); got != "limit=5&token=REDACTED&desc=true" {
+		t.Errorf("RedactQuery = %q", got)
+	}
+}
+
+func TestRedactBody(t *testing.T) {
+	tests := []struct {
+		name      string
+		src       string
+		max       int
+		want      string
+		truncated bool
+	}{
+		{"no limit", `{"a":"b"}`, 0, `{"a":"b"}`, false},
+		{"at limit", `{"a":"b"}`, 9, `{"a":"b"}`, false},
+		{"cut", `{"about":"long text"}`, 16, `{"about":"long `, true},
+		{"cut email", `{"email":"jack@sparrow.com"}`, 24, `{"email":"`, true},
+		{"cut secret", `{"password":"my secret words"}`, 25, `{"password":"REDACTED`, true},
+		{"cut after secret", `{"password":"pw","about":"text"}`, 28, `{"password":"REDACTED","about":"`, true},
+	}
+	for _, tt := range tests {
+		got, truncated := redactBody([]byte(tt.src), tt.max)
+		if got != tt.want || truncated != tt.truncated {
+			t.Errorf("%s: redactBody = %q, %v, want %q, %v", tt.name, got, truncated, tt.want, tt.truncated)
+		}
+		if tt.max > 0 && len(got) > tt.max+len(redacted) {
+			t.Errorf("%s: %d bytes kept, max %d", tt.name, len(got), tt.max)
+		}
+	}
+}
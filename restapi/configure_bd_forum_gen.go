@@ -3,14 +3,18 @@
 package restapi
 
 import (
+	"context"
 	"crypto/tls"
+	"net"
 	"net/http"
 
-	errors "github.com/go-openapi/errors"
 	runtime "github.com/go-openapi/runtime"
-	middleware "github.com/go-openapi/runtime/middleware"
 
+	"db-forum/api"
+	"db-forum/database"
+	"db-forum/logger"
 	"db-forum/restapi/operations"
+	"db-forum/router"
 )
 
 //go:generate swagger generate server --target .. --name bd-forum-gen --spec ../swagger-8.yaml
@@ -21,7 +25,7 @@ func configureFlags(api *operations.BdForumGenAPI) {
 
 func configureAPI(api *operations.BdForumGenAPI) http.Handler {
 	// configure the api here
-	api.ServeError = errors.ServeError
+	api.ServeError = serveError
 
 	// Set your custom logger if needed. Default one is log.Printf
 	// Expected interface func(string, ...interface{})
@@ -35,57 +39,7 @@ func configureAPI(api *operations.BdForumGenAPI) http.Handler {
 
 	api.JSONProducer = runtime.JSONProducer()
 
-	api.ClearHandler = operations.ClearHandlerFunc(func(params operations.ClearParams) middleware.Responder {
-		return middleware.NotImplemented("operation .Clear has not yet been implemented")
-	})
-	api.ForumCreateHandler = operations.ForumCreateHandlerFunc(func(params operations.ForumCreateParams) middleware.Responder {
-		return middleware.NotImplemented("operation .ForumCreate has not yet been implemented")
-	})
-	api.ForumGetOneHandler = operations.ForumGetOneHandlerFunc(func(params operations.ForumGetOneParams) middleware.Responder {
-		return middleware.NotImplemented("operation .ForumGetOne has not yet been implemented")
-	})
-	api.ForumGetThreadsHandler = operations.ForumGetThreadsHandlerFunc(func(params operations.ForumGetThreadsParams) middleware.Responder {
-		return middleware.NotImplemented("operation .ForumGetThreads has not yet been implemented")
-	})
-	api.ForumGetUsersHandler = operations.ForumGetUsersHandlerFunc(func(params operations.ForumGetUsersParams) middleware.Responder {
-		return middleware.NotImplemented("operation .ForumGetUsers has not yet been implemented")
-	})
-	api.PostGetOneHandler = operations.PostGetOneHandlerFunc(func(params operations.PostGetOneParams) middleware.Responder {
-		return middleware.NotImplemented("operation .PostGetOne has not yet been implemented")
-	})
-	api.PostUpdateHandler = operations.PostUpdateHandlerFunc(func(params operations.PostUpdateParams) middleware.Responder {
-		return middleware.NotImplemented("operation .PostUpdate has not yet been implemented")
-	})
-	api.PostsCreateHandler = operations.PostsCreateHandlerFunc(func(params operations.PostsCreateParams) middleware.Responder {
-		return middleware.NotImplemented("operation .PostsCreate has not yet been implemented")
-	})
-	api.StatusHandler = operations.StatusHandlerFunc(func(params operations.StatusParams) middleware.Responder {
-		return middleware.NotImplemented("operation .Status has not yet been implemented")
-	})
-	api.ThreadCreateHandler = operations.ThreadCreateHandlerFunc(func(params operations.ThreadCreateParams) middleware.Responder {
-		return middleware.NotImplemented("operation .ThreadCreate has not yet been implemented")
-	})
-	api.ThreadGetOneHandler = operations.ThreadGetOneHandlerFunc(func(params operations.ThreadGetOneParams) middleware.Responder {
-		return middleware.NotImplemented("operation .ThreadGetOne has not yet been implemented")
-	})
-	api.ThreadGetPostsHandler = operations.ThreadGetPostsHandlerFunc(func(params operations.ThreadGetPostsParams) middleware.Responder {
-		return middleware.NotImplemented("operation .ThreadGetPosts has not yet been implemented")
-	})
-	api.ThreadUpdateHandler = operations.ThreadUpdateHandlerFunc(func(params operations.ThreadUpdateParams) middleware.Responder {
-		return middleware.NotImplemented("operation .ThreadUpdate has not yet been implemented")
-	})
-	api.ThreadVoteHandler = operations.ThreadVoteHandlerFunc(func(params operations.ThreadVoteParams) middleware.Responder {
-		return middleware.NotImplemented("operation .ThreadVote has not yet been implemented")
-	})
-	api.UserCreateHandler = operations.UserCreateHandlerFunc(func(params operations.UserCreateParams) middleware.Responder {
-		return middleware.NotImplemented("operation .UserCreate has not yet been implemented")
-	})
-	api.UserGetOneHandler = operations.UserGetOneHandlerFunc(func(params operations.UserGetOneParams) middleware.Responder {
-		return middleware.NotImplemented("operation .UserGetOne has not yet been implemented")
-	})
-	api.UserUpdateHandler = operations.UserUpdateHandlerFunc(func(params operations.UserUpdateParams) middleware.Responder {
-		return middleware.NotImplemented("operation .UserUpdate has not yet been implemented")
-	})
+	configureHandlers(api)
 
 	api.ServerShutdown = func() {}
 
@@ -113,5 +67,57 @@ func setupMiddlewares(handler http.Handler) http.Handler {
 // The middleware configuration happens before anything, this middleware also applies to serving the swagger.json document.
 // So this is a good place to plug in a panic handling middleware, logging and metrics
 func setupGlobalMiddleware(handler http.Handler) http.Handler {
-	return handler
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := logger.RequestIDOrNew(r.Header.Get("X-Request-ID"))
+		w.Header().Set("X-Request-ID", id)
+		var seen string
+		if cookie, err := r.Cookie(api.WriteCookie); err == nil {
+			seen = cookie.Value
+		}
+		ctx := database.NewSession(logger.NewContext(r.Context(), id), seen)
+		route := router.Match(r.Method, r.URL.Path)
+		if limiter := router.Limiter(route); limiter != nil {
+			host, _, _ := net.SplitHostPort(r.RemoteAddr)
+			status := limiter.Take(api.RateKey(net.ParseIP(host), []byte(r.Header.Get("Authorization"))))
+			status.Header(w.Header().Set)
+			if !status.Allowed {
+				problem := &errorResponse{status: api.ErrRateLimited.Status, payload: api.ErrRateLimited.Payload(ctx)}
+				problem.WriteResponse(w, runtime.JSONProducer())
+				return
+			}
+		}
+		if timeout := router.Timeout(route); timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		next := handler
+		if router.IdempotentRoutes[route] {
+			next = idempotent(handler)
+		}
+		next.ServeHTTP(&sessionWriter{ResponseWriter: w, ctx: ctx}, r.WithContext(ctx))
+	})
+}
+
+// sessionWriter sets the write cookie of the session ctx before the
+// response header is sent, when the handler is done writing.
+type sessionWriter struct {
+	http.ResponseWriter
+	ctx         context.Context
+	wroteHeader bool
+}
+
+func (w *sessionWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		api.SetWriteCookie(w.ctx, w.Header().Add)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *sessionWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
 }
@@ -752,6 +752,7 @@ func init() {
         "title": {
           "description": "Название форума.",
           "type": "string",
+          "minLength": 1,
           "x-isnullable": false,
           "example": "Pirate stories"
         },
@@ -759,6 +760,7 @@ func init() {
           "description": "Nickname пользователя, который отвечает за форум.",
           "type": "string",
           "format": "identity",
+          "minLength": 1,
           "x-isnullable": false,
           "example": "j.sparrow"
         }
@@ -776,6 +778,7 @@ func init() {
           "description": "Автор, написавший данное сообщение.",
           "type": "string",
           "format": "identity",
+          "minLength": 1,
           "x-isnullable": false,
           "example": "j.sparrow"
         },
@@ -792,6 +795,11 @@ func init() {
           "format": "identity",
           "readOnly": true
         },
+        "html": {
+          "description": "HTML-представление сообщения (только при запросе с render=html).",
+          "type": "string",
+          "readOnly": true
+        },
         "id": {
           "description": "Идентификатор данного сообщения.",
           "type": "number",
@@ -808,6 +816,7 @@ func init() {
           "description": "Собственно сообщение форума.",
           "type": "string",
           "format": "text",
+          "minLength": 1,
           "x-isnullable": false,
           "example": "We should be afraid of the Kraken."
         },
@@ -912,6 +921,7 @@ func init() {
           "description": "Пользователь, создавший данную тему.",
           "type": "string",
           "format": "identity",
+          "minLength": 1,
           "x-isnullable": false,
           "example": "j.sparrow"
         },
@@ -929,6 +939,11 @@ func init() {
           "readOnly": true,
           "example": "pirate-stories"
         },
+        "html": {
+          "description": "HTML-представление описания ветки (только при запросе с render=html).",
+          "type": "string",
+          "readOnly": true
+        },
         "id": {
           "description": "Идентификатор ветки обсуждения.",
           "type": "number",
@@ -940,9 +955,17 @@ func init() {
           "description": "Описание ветки обсуждения.",
           "type": "string",
           "format": "text",
+          "minLength": 1,
           "x-isnullable": false,
           "example": "An urgent need to reveal the hiding place of Davy Jones. Who is willing to help in this matter?"
         },
+        "slowMode": {
+          "description": "Минимальный интервал в секундах между сообщениями одного пользователя в ветке.\n0 — без ограничений.\n",
+          "type": "number",
+          "format": "int32",
+          "readOnly": true,
+          "example": 30
+        },
         "slug": {
           "description": "Человекопонятный URL (https://ru.wikipedia.org/wiki/%D0%A1%D0%B5%D0%BC%D0%B0%D0%BD%D1%82%D0%B8%D1%87%D0%B5%D1%81%D0%BA%D0%B8%D0%B9_URL).\nВ данной структуре slug опционален и не может быть числом.\n",
           "type": "string",
@@ -954,6 +977,7 @@ func init() {
         "title": {
           "description": "Заголовок ветки обсуждения.",
           "type": "string",
+          "minLength": 1,
           "x-isnullable": false,
           "example": "Davy Jones cache"
         },
@@ -975,6 +999,13 @@ func init() {
           "format": "text",
           "example": "An urgent need to reveal the hiding place of Davy Jones. Who is willing to help in this matter?"
         },
+        "slowMode": {
+          "description": "Минимальный интервал в секундах между сообщениями одного пользователя в ветке.\n0 отключает медленный режим.\n",
+          "type": "number",
+          "format": "int32",
+          "minimum": 0,
+          "example": 30
+        },
         "title": {
           "description": "Заголовок ветки обсуждения.",
           "type": "string",
@@ -1012,6 +1043,7 @@ func init() {
         "fullname": {
           "description": "Полное имя пользователя.",
           "type": "string",
+          "minLength": 1,
           "x-isnullable": false,
           "example": "Captain Jack Sparrow"
         },
@@ -1065,6 +1097,7 @@ func init() {
           "description": "Идентификатор пользователя.",
           "type": "string",
           "format": "identity",
+          "minLength": 1,
           "x-isnullable": false
         },
         "voice": {
@@ -1816,6 +1849,7 @@ func init() {
         "title": {
           "description": "Название форума.",
           "type": "string",
+          "minLength": 1,
           "x-isnullable": false,
           "example": "Pirate stories"
         },
@@ -1823,6 +1857,7 @@ func init() {
           "description": "Nickname пользователя, который отвечает за форум.",
           "type": "string",
           "format": "identity",
+          "minLength": 1,
           "x-isnullable": false,
           "example": "j.sparrow"
         }
@@ -1840,6 +1875,7 @@ func init() {
           "description": "Автор, написавший данное сообщение.",
           "type": "string",
           "format": "identity",
+          "minLength": 1,
           "x-isnullable": false,
           "example": "j.sparrow"
         },
@@ -1856,6 +1892,11 @@ func init() {
           "format": "identity",
           "readOnly": true
         },
+        "html": {
+          "description": "HTML-представление сообщения (только при запросе с render=html).",
+          "type": "string",
+          "readOnly": true
+        },
         "id": {
           "description": "Идентификатор данного сообщения.",
           "type": "number",
@@ -1872,6 +1913,7 @@ func init() {
           "description": "Собственно сообщение форума.",
           "type": "string",
           "format": "text",
+          "minLength": 1,
           "x-isnullable": false,
           "example": "We should be afraid of the Kraken."
         },
@@ -1976,6 +2018,7 @@ func init() {
           "description": "Пользователь, создавший данную тему.",
           "type": "string",
           "format": "identity",
+          "minLength": 1,
           "x-isnullable": false,
           "example": "j.sparrow"
         },
@@ -1993,6 +2036,11 @@ func init() {
           "readOnly": true,
           "example": "pirate-stories"
         },
+        "html": {
+          "description": "HTML-представление описания ветки (только при запросе с render=html).",
+          "type": "string",
+          "readOnly": true
+        },
         "id": {
           "description": "Идентификатор ветки обсуждения.",
           "type": "number",
@@ -2004,9 +2052,17 @@ func init() {
           "description": "Описание ветки обсуждения.",
           "type": "string",
           "format": "text",
+          "minLength": 1,
           "x-isnullable": false,
           "example": "An urgent need to reveal the hiding place of Davy Jones. Who is willing to help in this matter?"
         },
+        "slowMode": {
+          "description": "Минимальный интервал в секундах между сообщениями одного пользователя в ветке.\n0 — без ограничений.\n",
+          "type": "number",
+          "format": "int32",
+          "readOnly": true,
+          "example": 30
+        },
         "slug": {
           "description": "Человекопонятный URL (https://ru.wikipedia.org/wiki/%D0%A1%D0%B5%D0%BC%D0%B0%D0%BD%D1%82%D0%B8%D1%87%D0%B5%D1%81%D0%BA%D0%B8%D0%B9_URL).\nВ данной структуре slug опционален и не может быть числом.\n",
           "type": "string",
@@ -2018,6 +2074,7 @@ func init() {
         "title": {
           "description": "Заголовок ветки обсуждения.",
           "type": "string",
+          "minLength": 1,
           "x-isnullable": false,
           "example": "Davy Jones cache"
         },
@@ -2039,6 +2096,13 @@ func init() {
           "format": "text",
           "example": "An urgent need to reveal the hiding place of Davy Jones. Who is willing to help in this matter?"
         },
+        "slowMode": {
+          "description": "Минимальный интервал в секундах между сообщениями одного пользователя в ветке.\n0 отключает медленный режим.\n",
+          "type": "number",
+          "format": "int32",
+          "minimum": 0,
+          "example": 30
+        },
         "title": {
           "description": "Заголовок ветки обсуждения.",
           "type": "string",
@@ -2076,6 +2140,7 @@ func init() {
         "fullname": {
           "description": "Полное имя пользователя.",
           "type": "string",
+          "minLength": 1,
           "x-isnullable": false,
           "example": "Captain Jack Sparrow"
         },
@@ -2129,6 +2194,7 @@ func init() {
           "description": "Идентификатор пользователя.",
           "type": "string",
           "format": "identity",
+          "minLength": 1,
           "x-isnullable": false
         },
         "voice": {
@@ -0,0 +1,21 @@
+package restapi
+
+import (
+	"net/http"
+
+	"db-forum/restapi/operations"
+
+	"github.com/go-openapi/loads"
+	"github.com/pkg/errors"
+)
+
+// Handler returns the API served by the generated go-swagger server: the
+// spec routes requests, binds and validates their parameters and bodies.
+func Handler() (http.Handler, error) {
+	spec, err := loads.Analyzed(SwaggerJSON, "")
+	if err != nil {
+		return nil, errors.Wrap(err, "can't load swagger spec")
+	}
+	api := operations.NewBdForumGenAPI(spec)
+	return configureAPI(api), nil
+}
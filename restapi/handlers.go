@@ -0,0 +1,533 @@
+package restapi
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"db-forum/api"
+	"db-forum/database"
+	"db-forum/logger"
+	"db-forum/models"
+	"db-forum/render"
+	"db-forum/restapi/operations"
+
+	"github.com/go-openapi/errors"
+	"github.com/go-openapi/runtime"
+	"github.com/go-openapi/runtime/middleware"
+	"github.com/go-openapi/swag"
+	"golang.org/x/tools/container/intsets"
+)
+
+// errorResponse answers with a status the spec doesn't declare, such as
+// internal errors and timeouts.
+type errorResponse struct {
+	status     int
+	payload    *models.Error
+	retryAfter time.Duration
+}
+
+func (e *errorResponse) WriteResponse(rw http.ResponseWriter, producer runtime.Producer) {
+	rw.Header().Set("Content-Type", "application/problem+json")
+	if e.retryAfter > 0 {
+		rw.Header().Set("Retry-After", strconv.FormatInt(int64(math.Ceil(e.retryAfter.Seconds())), 10))
+	}
+	rw.WriteHeader(e.status)
+	if err := producer.Produce(rw, e.payload); err != nil {
+		panic(err)
+	}
+}
+
+// problem sends the error payload of a responder generated from the spec
+// as application/problem+json, as the fasthttp server does.
+func problem(responder middleware.Responder) middleware.Responder {
+	return middleware.ResponderFunc(func(rw http.ResponseWriter, producer runtime.Producer) {
+		rw.Header().Set("Content-Type", "application/problem+json")
+		responder.WriteResponse(rw, producer)
+	})
+}
+
+// withETag sends version as the ETag of the response, as the fasthttp
+// server does for the rows clients may update with If-Match.
+func withETag(version int64, responder middleware.Responder) middleware.Responder {
+	return middleware.ResponderFunc(func(rw http.ResponseWriter, producer runtime.Producer) {
+		rw.Header().Set("ETag", api.VersionETag(version))
+		responder.WriteResponse(rw, producer)
+	})
+}
+
+func ifMatchVersion(r *http.Request) int64 {
+	return api.IfMatchVersion(r.Header.Get("If-Match"))
+}
+
+func preconditionFailed(ctx context.Context, message string) middleware.Responder {
+	p := api.ErrPreconditionFailed.WithMessage(message)
+	return &errorResponse{status: p.Status, payload: p.Payload(ctx)}
+}
+
+func wantHTML(r *http.Request) bool {
+	return r.URL.Query().Get("render") == "html"
+}
+
+// serveError answers the errors of go-openapi routing and binding, such as
+// unknown routes and invalid parameters, with the problems of the catalog.
+func serveError(rw http.ResponseWriter, r *http.Request, err error) {
+	ctx := r.Context()
+	p, fields := problemOf(err)
+	if p.Status == http.StatusInternalServerError {
+		logger.FromContext(ctx).Error(err.Error(), "method", r.Method, "path", r.URL.Path)
+	}
+	if e, ok := err.(*errors.MethodNotAllowedError); ok {
+		rw.Header().Set("Allow", strings.Join(e.Allowed, ","))
+	}
+	payload := p.Payload(ctx)
+	payload.Fields = fields
+	(&errorResponse{status: p.Status, payload: payload}).WriteResponse(rw, runtime.JSONProducer())
+}
+
+func problemOf(err error) (api.Problem, []*models.FieldError) {
+	switch e := err.(type) {
+	case *errors.CompositeError:
+		return api.ErrInvalidRequest, api.FieldErrors(e.Errors)
+	case *errors.Validation:
+		return api.ErrInvalidRequest, api.FieldErrors([]error{e})
+	case *errors.ParseError:
+		if e.In == "body" {
+			return api.ErrInvalidJSON, nil
+		}
+		return api.ErrInvalidParameter.WithField(e.Name), nil
+	case *errors.MethodNotAllowedError:
+		return api.ErrMethodNotAllowed, nil
+	case errors.Error:
+		switch code := int(e.Code()); {
+		case code == http.StatusNotFound:
+			return api.ErrRouteNotFound, nil
+		case code >= 400 && code < 500:
+			return api.Problem{Status: code, Code: api.ErrInvalidRequest.Code, Message: e.Error()}, nil
+		}
+	}
+	return api.ErrInternal, nil
+}
+
+// serverError logs err and answers 500 without its text, or 504 and 503
+// when ctx expired or was cancelled.
+func serverError(ctx context.Context, err error) middleware.Responder {
+	problem := api.ErrInternal
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		problem = api.ErrTimeout
+	case context.Canceled:
+		problem = api.ErrCancelled
+	default:
+		logger.FromContext(ctx).Error(err.Error())
+	}
+	return &errorResponse{status: problem.Status, payload: problem.Payload(ctx)}
+}
+
+func limitOf(limit *int32) int {
+	if limit == nil {
+		return intsets.MaxInt
+	}
+	return int(*limit)
+}
+
+func descOf(desc *bool) string {
+	if swag.BoolValue(desc) {
+		return "true"
+	}
+	return ""
+}
+
+func configureHandlers(api *operations.BdForumGenAPI) {
+	api.ClearHandler = operations.ClearHandlerFunc(clear)
+	api.StatusHandler = operations.StatusHandlerFunc(status)
+
+	api.ForumCreateHandler = operations.ForumCreateHandlerFunc(forumCreate)
+	api.ForumGetOneHandler = operations.ForumGetOneHandlerFunc(forumGetOne)
+	api.ForumGetThreadsHandler = operations.ForumGetThreadsHandlerFunc(forumGetThreads)
+	api.ForumGetUsersHandler = operations.ForumGetUsersHandlerFunc(forumGetUsers)
+
+	api.PostGetOneHandler = operations.PostGetOneHandlerFunc(postGetOne)
+	api.PostUpdateHandler = operations.PostUpdateHandlerFunc(postUpdate)
+	api.PostsCreateHandler = operations.PostsCreateHandlerFunc(postsCreate)
+
+	api.ThreadCreateHandler = operations.ThreadCreateHandlerFunc(threadCreate)
+	api.ThreadGetOneHandler = operations.ThreadGetOneHandlerFunc(threadGetOne)
+	api.ThreadGetPostsHandler = operations.ThreadGetPostsHandlerFunc(threadGetPosts)
+	api.ThreadUpdateHandler = operations.ThreadUpdateHandlerFunc(threadUpdate)
+	api.ThreadVoteHandler = operations.ThreadVoteHandlerFunc(threadVote)
+
+	api.UserCreateHandler = operations.UserCreateHandlerFunc(userCreate)
+	api.UserGetOneHandler = operations.UserGetOneHandlerFunc(userGetOne)
+	api.UserUpdateHandler = operations.UserUpdateHandlerFunc(userUpdate)
+}
+
+func clear(params operations.ClearParams) middleware.Responder {
+	database.ClearTable(params.HTTPRequest.Context())
+	render.Reset()
+	return operations.NewClearOK()
+}
+
+func status(params operations.StatusParams) middleware.Responder {
+	return operations.NewStatusOK().WithPayload(database.GetStatus(params.HTTPRequest.Context()))
+}
+
+func forumCreate(params operations.ForumCreateParams) middleware.Responder {
+	ctx := params.HTTPRequest.Context()
+	forum := params.Forum
+	author, err := database.GetUserByUsername(ctx, forum.User)
+	if err != nil {
+		if err == database.ErrNotFound {
+			return problem(operations.NewForumCreateNotFound().WithPayload(api.ErrUserNotFound.Payload(ctx)))
+		}
+		return serverError(ctx, err)
+	}
+	forum.User = author.Nickname
+	newForum, err := database.CreateForum(ctx, forum)
+	if err != nil {
+		if err == database.ErrDuplicate {
+			return operations.NewForumCreateConflict().WithPayload(newForum)
+		}
+		return serverError(ctx, err)
+	}
+	return operations.NewForumCreateCreated().WithPayload(newForum)
+}
+
+func forumGetOne(params operations.ForumGetOneParams) middleware.Responder {
+	ctx := params.HTTPRequest.Context()
+	forum, err := database.GetForum(ctx, params.Slug)
+	if err != nil {
+		if err == database.ErrNotFound {
+			return problem(operations.NewForumGetOneNotFound().WithPayload(api.ErrForumNotFound.Payload(ctx)))
+		}
+		return serverError(ctx, err)
+	}
+	return operations.NewForumGetOneOK().WithPayload(forum)
+}
+
+func forumGetThreads(params operations.ForumGetThreadsParams) middleware.Responder {
+	ctx := params.HTTPRequest.Context()
+	forum, err := database.GetForum(ctx, params.Slug)
+	if err != nil {
+		if err == database.ErrNotFound {
+			return problem(operations.NewForumGetThreadsNotFound().WithPayload(api.ErrForumNotFound.WithMessage("Can't find forum by slug: " + params.Slug).Payload(ctx)))
+		}
+		return serverError(ctx, err)
+	}
+	since, order := "", "ASC"
+	if params.Since != nil {
+		since = params.Since.String()
+	}
+	if swag.BoolValue(params.Desc) {
+		order = "DESC"
+	}
+	threads, err := database.GetForumThreads(ctx, forum.Slug, since, order, limitOf(params.Limit))
+	if err != nil {
+		return serverError(ctx, err)
+	}
+	html := wantHTML(params.HTTPRequest)
+	payload := make(models.Threads, len(*threads))
+	for i := range *threads {
+		if html {
+			(*threads)[i].HTML = render.HTML((*threads)[i].Message)
+		}
+		payload[i] = &(*threads)[i]
+	}
+	return operations.NewForumGetThreadsOK().WithPayload(payload)
+}
+
+func forumGetUsers(params operations.ForumGetUsersParams) middleware.Responder {
+	ctx := params.HTTPRequest.Context()
+	forum, err := database.GetForum(ctx, params.Slug)
+	if err != nil {
+		if err == database.ErrNotFound {
+			return problem(operations.NewForumGetUsersNotFound().WithPayload(api.ErrForumNotFound.Payload(ctx)))
+		}
+		return serverError(ctx, err)
+	}
+	users, err := database.GetForumUsers(ctx, forum.Slug, strconv.Itoa(limitOf(params.Limit)), swag.StringValue(params.Since), descOf(params.Desc))
+	if err != nil {
+		return serverError(ctx, err)
+	}
+	payload := make(models.Users, len(users))
+	for i := range users {
+		payload[i] = &users[i]
+	}
+	return operations.NewForumGetUsersOK().WithPayload(payload)
+}
+
+func postGetOne(params operations.PostGetOneParams) middleware.Responder {
+	ctx := params.HTTPRequest.Context()
+	post, err := database.GetPostByID(ctx, params.ID)
+	if err != nil {
+		if err == database.ErrNotFound {
+			return problem(operations.NewPostGetOneNotFound().WithPayload(api.ErrPostNotFound.Payload(ctx)))
+		}
+		return serverError(ctx, err)
+	}
+	postFull := models.PostFull{Post: &post.Post}
+	for _, related := range params.Related {
+		switch related {
+		case "user":
+			postFull.Author, err = database.GetUserByUsername(ctx, post.Author)
+		case "forum":
+			postFull.Forum, err = database.GetForum(ctx, post.Forum)
+		case "thread":
+			postFull.Thread, err = database.GetThreadByIDint32(ctx, post.Thread)
+		}
+		if err != nil {
+			return serverError(ctx, err)
+		}
+	}
+	if wantHTML(params.HTTPRequest) {
+		post.HTML = render.HTML(post.Message)
+		if postFull.Thread != nil {
+			postFull.Thread.HTML = render.HTML(postFull.Thread.Message)
+		}
+	}
+	if len(params.Related) == 0 {
+		return withETag(post.Version, operations.NewPostGetOneOK().WithPayload(&postFull))
+	}
+	return operations.NewPostGetOneOK().WithPayload(&postFull)
+}
+
+func postUpdate(params operations.PostUpdateParams) middleware.Responder {
+	ctx := params.HTTPRequest.Context()
+	post := database.PostRow{Post: models.Post{ID: params.ID, Message: params.Post.Message}, Version: ifMatchVersion(params.HTTPRequest)}
+	newPost, err := database.UpdatePost(ctx, &post)
+	if err != nil {
+		switch err {
+		case database.ErrNotFound:
+			return problem(operations.NewPostUpdateNotFound().WithPayload(api.ErrPostNotFound.Payload(ctx)))
+		case database.ErrPreconditionFailed:
+			return preconditionFailed(ctx, "post was modified, ETag doesn't match")
+		}
+		return serverError(ctx, err)
+	}
+	return withETag(newPost.Version, operations.NewPostUpdateOK().WithPayload(&newPost.Post))
+}
+
+func postsCreate(params operations.PostsCreateParams) middleware.Responder {
+	ctx := params.HTTPRequest.Context()
+	posts := make([]models.Post, 0, len(params.Posts))
+	for _, post := range params.Posts {
+		if post != nil {
+			posts = append(posts, *post)
+		}
+	}
+	created, err := database.CreatePosts(ctx, &posts, params.SlugOrID)
+	if err != nil {
+		switch err {
+		case database.ErrNotFound:
+			return problem(operations.NewPostsCreateNotFound().WithPayload(api.ErrThreadNotFound.WithMessage("Can't find thread by slug: " + params.SlugOrID).Payload(ctx)))
+		case database.ErrNoAuthor:
+			return problem(operations.NewPostsCreateNotFound().WithPayload(api.ErrUserNotFound.Payload(ctx)))
+		case database.ErrDuplicate:
+			return problem(operations.NewPostsCreateConflict().WithPayload(api.ErrParentInOtherThread.Payload(ctx)))
+		}
+		if e, ok := err.(*database.SlowModeError); ok {
+			return &errorResponse{status: api.ErrSlowMode.Status, payload: api.ErrSlowMode.Payload(ctx), retryAfter: e.Wait}
+		}
+		return serverError(ctx, err)
+	}
+	payload := make(models.Posts, len(*created))
+	for i := range *created {
+		payload[i] = &(*created)[i]
+	}
+	return operations.NewPostsCreateCreated().WithPayload(payload)
+}
+
+func threadCreate(params operations.ThreadCreateParams) middleware.Responder {
+	ctx := params.HTTPRequest.Context()
+	thread := params.Thread
+	user, err := database.GetUserByUsername(ctx, thread.Author)
+	if err != nil {
+		if err == database.ErrNotFound {
+			return problem(operations.NewThreadCreateNotFound().WithPayload(api.ErrUserNotFound.Payload(ctx)))
+		}
+		return serverError(ctx, err)
+	}
+	thread.Author = user.Nickname
+	forum, err := database.GetForum(ctx, params.Slug)
+	if err != nil {
+		if err == database.ErrNotFound {
+			return problem(operations.NewThreadCreateNotFound().WithPayload(api.ErrForumNotFound.Payload(ctx)))
+		}
+		return serverError(ctx, err)
+	}
+	thread.Forum = forum.Slug
+	if thread.Slug != "" {
+		existing, err := database.GetThreadBySlug(ctx, thread.Slug)
+		if err != nil && err != database.ErrNotFound {
+			return serverError(ctx, err)
+		}
+		if existing != nil {
+			return operations.NewThreadCreateConflict().WithPayload(existing)
+		}
+	}
+	newThread, err := database.CreateThread(ctx, thread)
+	if err != nil {
+		if err == database.ErrDuplicate {
+			return operations.NewThreadCreateConflict().WithPayload(newThread)
+		}
+		return serverError(ctx, err)
+	}
+	return operations.NewThreadCreateCreated().WithPayload(newThread)
+}
+
+func threadGetOne(params operations.ThreadGetOneParams) middleware.Responder {
+	ctx := params.HTTPRequest.Context()
+	thread, err := database.GetThreadRow(ctx, params.SlugOrID)
+	if err != nil {
+		if err == database.ErrNotFound {
+			return problem(operations.NewThreadGetOneNotFound().WithPayload(api.ErrThreadNotFound.WithMessage("Can't find thread by slug: " + params.SlugOrID).Payload(ctx)))
+		}
+		return serverError(ctx, err)
+	}
+	if wantHTML(params.HTTPRequest) {
+		thread.HTML = render.HTML(thread.Message)
+	}
+	return withETag(thread.Version, operations.NewThreadGetOneOK().WithPayload(&thread.Thread))
+}
+
+func threadGetPosts(params operations.ThreadGetPostsParams) middleware.Responder {
+	ctx := params.HTTPRequest.Context()
+	thread, err := database.GetThreadBySlugOrID(ctx, params.SlugOrID)
+	if err != nil {
+		if err == database.ErrNotFound {
+			return problem(operations.NewThreadGetPostsNotFound().WithPayload(api.ErrThreadNotFound.WithMessage("Can't find thread by slug: " + params.SlugOrID).Payload(ctx)))
+		}
+		return serverError(ctx, err)
+	}
+	limit, since, desc := strconv.Itoa(limitOf(params.Limit)), "", descOf(params.Desc)
+	if params.Since != nil {
+		since = strconv.FormatInt(*params.Since, 10)
+	}
+	var posts *[]models.Post
+	switch swag.StringValue(params.Sort) {
+	case "tree":
+		posts, err = database.GetPostsTree(ctx, thread.ID, limit, since, desc)
+	case "parent_tree":
+		posts, err = database.GetPostsParentTree(ctx, thread.ID, limit, since, desc)
+	default:
+		posts, err = database.GetPostsFlat(ctx, thread.ID, limit, since, desc)
+	}
+	if err != nil {
+		return serverError(ctx, err)
+	}
+	html := wantHTML(params.HTTPRequest)
+	payload := make(models.Posts, len(*posts))
+	for i := range *posts {
+		if html {
+			(*posts)[i].HTML = render.HTML((*posts)[i].Message)
+		}
+		payload[i] = &(*posts)[i]
+	}
+	return operations.NewThreadGetPostsOK().WithPayload(payload)
+}
+
+func threadUpdate(params operations.ThreadUpdateParams) middleware.Responder {
+	ctx := params.HTTPRequest.Context()
+	thread, err := database.GetThreadRow(ctx, params.SlugOrID)
+	if err != nil {
+		if err == database.ErrNotFound {
+			return problem(operations.NewThreadUpdateNotFound().WithPayload(api.ErrThreadNotFound.WithMessage("Can't find thread by slug: " + params.SlugOrID).Payload(ctx)))
+		}
+		return serverError(ctx, err)
+	}
+	thread.Title, thread.Message = params.Thread.Title, params.Thread.Message
+	thread.Version = ifMatchVersion(params.HTTPRequest)
+	updated, err := database.UpdateThread(ctx, thread, params.Thread.SlowMode)
+	if err != nil {
+		switch err {
+		case database.ErrNotFound:
+			return problem(operations.NewThreadUpdateNotFound().WithPayload(api.ErrThreadNotFound.WithMessage("Can't find thread by slug: " + params.SlugOrID).Payload(ctx)))
+		case database.ErrPreconditionFailed:
+			return preconditionFailed(ctx, "thread was modified, ETag doesn't match")
+		}
+		return serverError(ctx, err)
+	}
+	return withETag(updated.Version, operations.NewThreadUpdateOK().WithPayload(&updated.Thread))
+}
+
+func threadVote(params operations.ThreadVoteParams) middleware.Responder {
+	ctx := params.HTTPRequest.Context()
+	vote := params.Vote
+	user, err := database.GetUserByUsername(ctx, vote.Nickname)
+	if err != nil {
+		if err == database.ErrNotFound {
+			return problem(operations.NewThreadVoteNotFound().WithPayload(api.ErrUserNotFound.Payload(ctx)))
+		}
+		return serverError(ctx, err)
+	}
+	vote.Nickname = user.Nickname
+	thread, err := database.GetThreadBySlugOrID(ctx, params.SlugOrID)
+	if err != nil {
+		if err == database.ErrNotFound {
+			return problem(operations.NewThreadVoteNotFound().WithPayload(api.ErrThreadNotFound.Payload(ctx)))
+		}
+		return serverError(ctx, err)
+	}
+	vote.ThreadId = thread.ID
+	thread.Votes, err = database.VoteThread(ctx, vote)
+	if err != nil {
+		return serverError(ctx, err)
+	}
+	return operations.NewThreadVoteOK().WithPayload(thread)
+}
+
+func userCreate(params operations.UserCreateParams) middleware.Responder {
+	ctx := params.HTTPRequest.Context()
+	user := params.Profile
+	user.Nickname = params.Nickname
+	users, err := database.CreateUser(ctx, user)
+	if err != nil {
+		if err == database.ErrDuplicate {
+			payload := make(models.Users, len(*users))
+			for i := range *users {
+				payload[i] = &(*users)[i]
+			}
+			return operations.NewUserCreateConflict().WithPayload(payload)
+		}
+		return serverError(ctx, err)
+	}
+	return operations.NewUserCreateCreated().WithPayload(&(*users)[0])
+}
+
+func userGetOne(params operations.UserGetOneParams) middleware.Responder {
+	ctx := params.HTTPRequest.Context()
+	user, err := database.GetUserRow(ctx, params.Nickname)
+	if err != nil {
+		if err == database.ErrNotFound {
+			return problem(operations.NewUserGetOneNotFound().WithPayload(api.ErrUserNotFound.Payload(ctx)))
+		}
+		return serverError(ctx, err)
+	}
+	return withETag(user.Version, operations.NewUserGetOneOK().WithPayload(&user.User))
+}
+
+func userUpdate(params operations.UserUpdateParams) middleware.Responder {
+	ctx := params.HTTPRequest.Context()
+	user := database.UserRow{User: models.User{
+		Nickname: params.Nickname,
+		Fullname: params.Profile.Fullname,
+		About:    params.Profile.About,
+		Email:    params.Profile.Email.String(),
+	}, Version: ifMatchVersion(params.HTTPRequest)}
+	updated, err := database.UpdateUser(ctx, &user)
+	if err != nil {
+		switch err {
+		case database.ErrNotFound:
+			return problem(operations.NewUserUpdateNotFound().WithPayload(api.ErrUserNotFound.WithMessage("Can't find user by nickname: " + params.Nickname).Payload(ctx)))
+		case database.ErrDuplicate:
+			return problem(operations.NewUserUpdateConflict().WithPayload(api.ErrEmailTaken.Payload(ctx)))
+		case database.ErrPreconditionFailed:
+			return preconditionFailed(ctx, "user was modified, ETag doesn't match")
+		}
+		return serverError(ctx, err)
+	}
+	return withETag(updated.Version, operations.NewUserUpdateOK().WithPayload(&updated.User))
+}
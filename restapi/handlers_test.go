@@ -0,0 +1,100 @@
+package restapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"db-forum/api"
+	"db-forum/models"
+	"db-forum/restapi/operations"
+
+	"github.com/go-openapi/errors"
+	"github.com/go-openapi/runtime"
+)
+
+func TestServeError(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+		fields int
+	}{
+		{"invalid parameters", errors.CompositeValidationError(errors.Required("limit", "query"), errors.InvalidType("since", "query", "integer", "x")), 400, "invalid_request", 2},
+		{"invalid body field", errors.Required("author", "body"), 400, "invalid_request", 1},
+		{"broken body", errors.NewParseError("body", "body", "", nil), 400, "invalid_json", 0},
+		{"broken parameter", errors.NewParseError("limit", "query", "x", nil), 400, "invalid_parameter", 0},
+		{"unknown route", errors.NotFound("path /api/nothing was not found"), 404, "route_not_found", 0},
+		{"wrong method", errors.MethodNotAllowed("DELETE", []string{"GET", "POST"}), 405, "method_not_allowed", 0},
+		{"unsupported media type", errors.New(http.StatusUnsupportedMediaType, "unsupported media type"), 415, "invalid_request", 0},
+		{"other", errors.New(http.StatusInternalServerError, "SELECT failed"), 500, "internal", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			serveError(w, httptest.NewRequest("GET", "/api/forum/x/details", nil), tt.err)
+			if w.Code != tt.status {
+				t.Errorf("status %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Header().Get("Content-Type"); got != "application/problem+json" {
+				t.Errorf("Content-Type %q", got)
+			}
+			var payload models.Error
+			if err := payload.UnmarshalJSON(w.Body.Bytes()); err != nil {
+				t.Fatal(err)
+			}
+			if payload.Code != tt.code || len(payload.Fields) != tt.fields {
+				t.Errorf("code %q with %d fields, want %q with %d", payload.Code, len(payload.Fields), tt.code, tt.fields)
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	serveError(w, httptest.NewRequest("DELETE", "/api/service/status", nil), errors.MethodNotAllowed("DELETE", []string{"GET"}))
+	if got := w.Header().Get("Allow"); got != "GET" {
+		t.Errorf("Allow %q, want GET", got)
+	}
+}
+
+func TestProblemContentType(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api/forum/x/details", nil)
+	w := httptest.NewRecorder()
+	w.Header().Set("Content-Type", "application/json")
+	problem(operations.NewForumGetOneNotFound().WithPayload(api.ErrForumNotFound.Payload(req.Context()))).WriteResponse(w, runtime.JSONProducer())
+	if w.Code != http.StatusNotFound || w.Header().Get("Content-Type") != "application/problem+json" {
+		t.Errorf("status %d, Content-Type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	w = httptest.NewRecorder()
+	w.Header().Set("Content-Type", "application/json")
+	operations.NewForumGetOneOK().WithPayload(&models.Forum{Slug: "x"}).WriteResponse(w, runtime.JSONProducer())
+	if got := w.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type of a forum %q", got)
+	}
+}
+
+func TestETag(t *testing.T) {
+	w := httptest.NewRecorder()
+	withETag(7, operations.NewUserGetOneOK().WithPayload(&models.User{Nickname: "x"})).WriteResponse(w, runtime.JSONProducer())
+	if got := w.Header().Get("ETag"); got != `"7"` {
+		t.Errorf("ETag %q, want \"7\"", got)
+	}
+
+	for header, want := range map[string]int64{"": 0, "*": 0, `"7"`: 7, `"7-gzip"`: 7, `W/"7"`: -1, "7": -1} {
+		req := httptest.NewRequest("POST", "/api/thread/x/details", nil)
+		if header != "" {
+			req.Header.Set("If-Match", header)
+		}
+		if got := ifMatchVersion(req); got != want {
+			t.Errorf("If-Match %q: version %d, want %d", header, got, want)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	preconditionFailed(context.Background(), "thread was modified").WriteResponse(w, runtime.JSONProducer())
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("status %d, want 412", w.Code)
+	}
+}
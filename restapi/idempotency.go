@@ -0,0 +1,90 @@
+package restapi
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+
+	"db-forum/api"
+	"db-forum/database"
+	"db-forum/logger"
+
+	"github.com/go-openapi/runtime"
+)
+
+// idempotent is api.Idempotent for the swagger server: it stores the first
+// response for a request carrying an Idempotency-Key header and replays it
+// on retries.
+func idempotent(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || api.IdempotencyWindow <= 0 {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		ctx := r.Context()
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			p := api.ErrBodyTooLarge
+			(&errorResponse{status: p.Status, payload: p.Payload(ctx)}).WriteResponse(w, runtime.JSONProducer())
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		stored, problem, err := api.ClaimIdempotencyKey(ctx, key, api.RequestFingerprint(r.Method, r.URL.Path, body))
+		if err != nil {
+			serverError(ctx, err).WriteResponse(w, runtime.JSONProducer())
+			return
+		}
+		if problem != nil {
+			(&errorResponse{status: problem.Status, payload: problem.Payload(ctx)}).WriteResponse(w, runtime.JSONProducer())
+			return
+		}
+		if stored != nil {
+			w.Header().Set("Content-Type", stored.ContentType)
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(stored.Status)
+			w.Write(stored.Body)
+			return
+		}
+
+		saved := false
+		defer func() {
+			if saved {
+				return
+			}
+			if err := database.ReleaseIdempotencyKey(api.DetachedContext(ctx), key); err != nil {
+				logger.FromContext(ctx).Error(err.Error(), "method", r.Method, "path", r.URL.Path)
+			}
+		}()
+
+		recorder := &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+		handler.ServeHTTP(recorder, r)
+
+		if !api.Replayable(recorder.status) {
+			return
+		}
+		if err := database.SaveIdempotencyKey(api.DetachedContext(ctx), key, recorder.status, w.Header().Get("Content-Type"), recorder.body.Bytes()); err != nil {
+			logger.FromContext(ctx).Error(err.Error(), "method", r.Method, "path", r.URL.Path)
+			return
+		}
+		saved = true
+	})
+}
+
+// recordingWriter keeps a copy of the status and body it writes through.
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
@@ -0,0 +1,35 @@
+package restapi
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIdempotentWithoutKey(t *testing.T) {
+	var body string
+	handler := idempotent(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/api/forum/create", strings.NewReader(`{"slug":"x"}`)))
+	if w.Code != http.StatusCreated || body != `{"slug":"x"}` {
+		t.Errorf("status %d, body %q", w.Code, body)
+	}
+}
+
+func TestIdempotentRejectsLongKey(t *testing.T) {
+	calls := 0
+	handler := idempotent(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { calls++ }))
+	req := httptest.NewRequest("POST", "/api/forum/create", strings.NewReader("{}"))
+	req.Header.Set("Idempotency-Key", strings.Repeat("k", 256))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if calls != 0 || w.Code != http.StatusBadRequest {
+		t.Errorf("calls = %d, status = %d, want 0 and 400", calls, w.Code)
+	}
+}
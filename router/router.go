@@ -2,10 +2,14 @@ package router
 
 import (
 	"fmt"
+	"strings"
+	"sync"
+	"time"
 
 	"db-forum/api"
 
 	"github.com/buaazp/fasthttprouter"
+	"github.com/pkg/errors"
 	"github.com/valyala/fasthttp"
 )
 
@@ -24,29 +28,203 @@ func routePostOnForum(ctx *fasthttp.RequestCtx) {
 	api.CreateThread(ctx, options)
 }
 
-func CreateRouter() *fasthttprouter.Router {
-	r := fasthttprouter.New()
+// CachePolicies overrides the Cache-Control policy of GET routes by pattern.
+var CachePolicies = map[string]string{
+	"/api/service/status": "no-store",
+	"/api/service/cache":  "no-store",
+	"/metrics":            "no-store",
+	"/healthz":            "no-store",
+	"/readyz":             "no-store",
+
+	"/api/admin/forum/:slug/export": "no-store",
+}
+
+const defaultCachePolicy = "public, no-cache"
+
+// DefaultTimeout bounds requests to routes missing from Timeouts.
+var DefaultTimeout = 5 * time.Second
+
+// Timeouts overrides the request deadline of routes by pattern. Bulk and
+// streaming routes have none and run for as long as the client waits.
+var Timeouts = map[string]time.Duration{
+	"/api/thread/:slug/posts": 15 * time.Second,
+	"/api/post/:slug/context": 15 * time.Second,
+	"/api/thread/:slug/split": 30 * time.Second,
+	"/api/thread/:slug/merge": 30 * time.Second,
+	"/api/post/:slug/move":    30 * time.Second,
+	"/api/service/clear":      30 * time.Second,
+
+	"/api/thread/:slug/import":      0,
+	"/api/admin/forum/:slug/export": 0,
+	"/api/admin/import":             0,
+}
+
+// Timeout returns the request deadline of the route pattern, 0 for none.
+func Timeout(path string) time.Duration {
+	if timeout, ok := Timeouts[path]; ok {
+		return timeout
+	}
+	return DefaultTimeout
+}
 
-	r.POST("/api/user/:nickname/create", api.CreateUser)
-	r.GET("/api/user/:nickname/profile", api.GetUser)
-	r.POST("/api/user/:nickname/profile", api.UpdateUser)
+// MaxTimeout returns the longest finite request deadline.
+func MaxTimeout() time.Duration {
+	max := DefaultTimeout
+	for _, timeout := range Timeouts {
+		if timeout > max {
+			max = timeout
+		}
+	}
+	return max
+}
 
-	r.POST("/api/forum/*options", routePostOnForum)
-	r.GET("/api/forum/:slug/details", api.GetForum)
-	r.GET("/api/forum/:slug/users", api.GetForumUsers)
-	r.GET("/api/forum/:slug/threads", api.GetForumThreads)
+// Configure overrides the deadlines and Cache-Control policies of the
+// route patterns in timeouts and policies.
+func Configure(timeouts map[string]time.Duration, policies map[string]string) error {
+	for path, timeout := range timeouts {
+		if !known(path, "") {
+			return errors.Errorf("no route %s to set the timeout of", path)
+		}
+		Timeouts[path] = timeout
+	}
+	for path, policy := range policies {
+		if !known(path, "GET") {
+			return errors.Errorf("no GET route %s to set the cache policy of", path)
+		}
+		CachePolicies[path] = policy
+	}
+	return nil
+}
 
-	r.GET("/api/thread/:slug", api.GetThread)
-	r.POST("/api/thread/:slug/create", api.CreatePost)
-	r.GET("/api/thread/:slug/details", api.GetThread)
-	r.POST("/api/thread/:slug/details", api.UpdateThread)
-	r.POST("/api/thread/:slug/vote", api.VoteThread)
+// known reports whether a route has the pattern path and, unless method
+// is "", the method.
+func known(path string, method string) bool {
+	for _, route := range Routes {
+		if route.Path == path && (method == "" || route.Method == method) {
+			return true
+		}
+	}
+	return false
+}
 
-	r.GET("/api/thread/:slug/posts", api.GetPost)
-	r.GET("/api/post/:slug/details", api.GetPostDetails)
-	r.POST("/api/post/:slug/details", api.UpdatePost)
+// IdempotentRoutes are the route patterns that replay their response to
+// retries carrying the same Idempotency-Key.
+var IdempotentRoutes = map[string]bool{
+	"/api/user/:nickname/create": true,
+	"/api/forum/*options":        true,
+	"/api/thread/:slug/create":   true,
+}
 
-	r.GET("/api/service/status", api.GetServiceStatus)
-	r.POST("/api/service/clear", api.ClearService)
+// RateLimits limits how often one client may call a route, by pattern.
+// Routes missing here are not limited.
+var RateLimits = map[string]api.Limit{}
+
+var (
+	limitersOnce sync.Once
+	limiters     map[string]*api.Limiter
+)
+
+// Limiter returns the rate limiter of the route pattern, nil for none. The
+// limiters are built from RateLimits on the first call.
+func Limiter(path string) *api.Limiter {
+	limitersOnce.Do(func() {
+		limiters = make(map[string]*api.Limiter)
+		for path, limit := range RateLimits {
+			if limit.Rate > 0 {
+				limiters[path] = api.NewLimiter(limit)
+			}
+		}
+	})
+	return limiters[path]
+}
+
+// Route is one entry of the API route table.
+type Route struct {
+	Method  string
+	Path    string
+	Handler fasthttp.RequestHandler
+}
+
+// Routes is the route table served by CreateRouter.
+var Routes = []Route{
+	{"POST", "/api/user/:nickname/create", api.CreateUser},
+	{"GET", "/api/user/:nickname/profile", api.GetUser},
+	{"POST", "/api/user/:nickname/profile", api.UpdateUser},
+
+	{"POST", "/api/forum/*options", routePostOnForum},
+	{"GET", "/api/forum/:slug/details", api.GetForum},
+	{"GET", "/api/forum/:slug/users", api.GetForumUsers},
+	{"GET", "/api/forum/:slug/threads", api.GetForumThreads},
+
+	{"GET", "/api/thread/:slug", api.GetThread},
+	{"POST", "/api/thread/:slug/create", api.CreatePost},
+	{"GET", "/api/thread/:slug/details", api.GetThread},
+	{"POST", "/api/thread/:slug/details", api.UpdateThread},
+	{"POST", "/api/thread/:slug/vote", api.VoteThread},
+	{"POST", "/api/thread/:slug/split", api.Admin(api.SplitThread)},
+	{"POST", "/api/thread/:slug/merge", api.Admin(api.MergeThread)},
+	{"POST", "/api/thread/:slug/import", api.Admin(api.ImportPosts)},
+
+	{"GET", "/api/thread/:slug/posts", api.GetPost},
+	{"GET", "/api/post/:slug/details", api.GetPostDetails},
+	{"GET", "/api/post/:slug/context", api.GetPostContext},
+	{"POST", "/api/post/:slug/details", api.UpdatePost},
+	{"POST", "/api/post/:slug/move", api.Admin(api.MovePost)},
+
+	{"GET", "/api/service/status", api.GetServiceStatus},
+	{"GET", "/api/service/cache", api.GetCacheStats},
+	{"POST", "/api/service/clear", api.ClearService},
+	{"GET", "/metrics", api.GetMetrics},
+	{"GET", "/healthz", api.Healthz},
+	{"GET", "/readyz", api.Readyz},
+
+	{"GET", "/api/admin/forum/:slug/export", api.Admin(api.ExportForum)},
+	{"POST", "/api/admin/import", api.Admin(api.ImportArchive)},
+}
+
+func CreateRouter() *fasthttprouter.Router {
+	r := fasthttprouter.New()
+	for _, route := range Routes {
+		handler := route.Handler
+		if IdempotentRoutes[route.Path] {
+			handler = api.Idempotent(handler)
+		}
+		if route.Method == "GET" {
+			policy, ok := CachePolicies[route.Path]
+			if !ok {
+				policy = defaultCachePolicy
+			}
+			handler = api.CacheControl(policy, handler)
+		}
+		handler = api.Deadline(Timeout(route.Path), api.Validate(route.Method, route.Path, handler))
+		handler = api.RateLimit(Limiter(route.Path), handler)
+		r.Handle(route.Method, route.Path, api.Instrument(route.Method, route.Path, handler))
+	}
 	return r
 }
+
+// Match returns the route pattern serving method and path, or "" if none.
+func Match(method string, path string) string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for _, route := range Routes {
+		if route.Method == method && matchSegments(strings.Split(strings.Trim(route.Path, "/"), "/"), segments) {
+			return route.Path
+		}
+	}
+	return ""
+}
+
+func matchSegments(pattern []string, segments []string) bool {
+	for i, p := range pattern {
+		if strings.HasPrefix(p, "*") {
+			return true
+		}
+		if i >= len(segments) {
+			return false
+		}
+		if !strings.HasPrefix(p, ":") && p != segments[i] {
+			return false
+		}
+	}
+	return len(pattern) == len(segments)
+}
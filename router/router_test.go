@@ -0,0 +1,98 @@
+package router
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"db-forum/api"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestConfigure(t *testing.T) {
+	defer func(timeouts map[string]time.Duration, policies map[string]string) {
+		Timeouts, CachePolicies = timeouts, policies
+	}(Timeouts, CachePolicies)
+	Timeouts = map[string]time.Duration{"/api/post/:slug/move": 30 * time.Second}
+	CachePolicies = map[string]string{}
+
+	err := Configure(
+		map[string]time.Duration{"/api/thread/:slug/posts": time.Minute, "/api/post/:slug/move": 0},
+		map[string]string{"/api/forum/:slug/details": "public, max-age=5"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := Timeout("/api/thread/:slug/posts"); got != time.Minute {
+		t.Errorf("timeout of posts = %v, want 1m", got)
+	}
+	if got := Timeout("/api/post/:slug/move"); got != 0 {
+		t.Errorf("timeout of move = %v, want none", got)
+	}
+	if got := CachePolicies["/api/forum/:slug/details"]; got != "public, max-age=5" {
+		t.Errorf("cache policy of details = %q", got)
+	}
+
+	if err := Configure(map[string]time.Duration{"/api/nothing": time.Second}, nil); err == nil {
+		t.Error("Configure accepted the timeout of a missing route")
+	}
+	if err := Configure(nil, map[string]string{"/api/thread/:slug/vote": "no-store"}); err == nil {
+		t.Error("Configure accepted the cache policy of a POST route")
+	}
+}
+
+func handlerOf(t *testing.T, method string, path string) fasthttp.RequestHandler {
+	for _, route := range Routes {
+		if route.Method == method && route.Path == path {
+			return route.Handler
+		}
+	}
+	t.Fatalf("no route %s %s", method, path)
+	return nil
+}
+
+// TestModeratorRoutesNeedAdmin checks that moving posts, splitting or
+// merging threads and importing posts are refused without the admin token.
+func TestModeratorRoutesNeedAdmin(t *testing.T) {
+	defer func(token string) { api.AdminToken = token }(api.AdminToken)
+	tests := []struct {
+		token         string
+		authorization string
+		want          int
+	}{
+		{"", "", http.StatusForbidden},
+		{"secret", "", http.StatusForbidden},
+		{"secret", "Bearer guess", http.StatusForbidden},
+		{"secret", "Bearer secret", http.StatusBadRequest},
+	}
+	// admitted routes reject the broken body before reaching the database,
+	// which shows the admin got through.
+	routes := []struct {
+		path     string
+		admitted bool
+	}{
+		{"/api/thread/:slug/split", true},
+		{"/api/thread/:slug/merge", true},
+		{"/api/post/:slug/move", true},
+		{"/api/thread/:slug/import", false},
+	}
+	for _, route := range routes {
+		path, handler := route.path, handlerOf(t, "POST", route.path)
+		for _, tt := range tests {
+			if tt.want != http.StatusForbidden && !route.admitted {
+				continue
+			}
+			api.AdminToken = tt.token
+			var ctx fasthttp.RequestCtx
+			ctx.Request.Header.SetMethod("POST")
+			ctx.Request.Header.Set("Authorization", tt.authorization)
+			ctx.Request.SetBodyString(`{"post": `)
+			ctx.SetUserValue("slug", "1")
+			handler(&ctx)
+			if got := ctx.Response.StatusCode(); got != tt.want {
+				t.Errorf("%s with token %q and %q: status %d, want %d", path, tt.token, tt.authorization, got, tt.want)
+			}
+		}
+	}
+}
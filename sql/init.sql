@@ -1,3 +1,7 @@
+-- Stop at the first error, so that schema_version at the end is only
+-- written when every statement before it went through.
+\set ON_ERROR_STOP on
+
 CREATE EXTENSION IF NOT EXISTS citext;
 
 CREATE TABLE IF NOT EXISTS users
@@ -8,7 +12,8 @@ CREATE TABLE IF NOT EXISTS users
   nickname CITEXT NOT NULL,
   fullname TEXT   NOT NULL,
   about    TEXT,
-  email    CITEXT NOT NULL
+  email    CITEXT NOT NULL,
+  version  INTEGER DEFAULT 1 NOT NULL
 );
 
 CREATE TABLE IF NOT EXISTS forum
@@ -34,7 +39,9 @@ CREATE TABLE IF NOT EXISTS thread
   message CITEXT NOT NULL,
   votes   INTEGER                  DEFAULT 0,
   created TIMESTAMP WITH TIME ZONE DEFAULT now(),
-  slug    CITEXT
+  slug    CITEXT,
+  version INTEGER                  DEFAULT 1 NOT NULL,
+  slow_mode INTEGER                DEFAULT 0 NOT NULL
 );
 
 CREATE TABLE IF NOT EXISTS post
@@ -50,10 +57,18 @@ CREATE TABLE IF NOT EXISTS post
   thread    INTEGER                  DEFAULT 0,
   created   TIMESTAMP WITH TIME ZONE DEFAULT now(),
   path      INTEGER []               DEFAULT ARRAY [] :: INTEGER [],
-  root      INTEGER                  DEFAULT 0
+  root      INTEGER                  DEFAULT 0,
+  version   INTEGER                  DEFAULT 1 NOT NULL
 );
 
-CREATE TABLE voice
+-- Columns added after the tables were first created. CREATE TABLE IF NOT
+-- EXISTS leaves existing tables as they are, so older databases get them here.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS version INTEGER DEFAULT 1 NOT NULL;
+ALTER TABLE thread ADD COLUMN IF NOT EXISTS version INTEGER DEFAULT 1 NOT NULL;
+ALTER TABLE post ADD COLUMN IF NOT EXISTS version INTEGER DEFAULT 1 NOT NULL;
+ALTER TABLE thread ADD COLUMN IF NOT EXISTS slow_mode INTEGER DEFAULT 0 NOT NULL;
+
+CREATE TABLE IF NOT EXISTS voice
 (
   id         SERIAL            NOT NULL
     CONSTRAINT voice_pkey
@@ -66,6 +81,26 @@ CREATE TABLE voice
   UNIQUE (nickname, vote, thread_id)
 );
 
+CREATE TABLE IF NOT EXISTS forum_users
+(
+  forum    CITEXT NOT NULL,
+  nickname CITEXT NOT NULL,
+  CONSTRAINT forum_users_pkey
+  PRIMARY KEY (forum, nickname)
+);
+
+CREATE UNLOGGED TABLE IF NOT EXISTS idempotency_key
+(
+  key          TEXT NOT NULL
+    CONSTRAINT idempotency_key_pkey
+    PRIMARY KEY,
+  fingerprint  TEXT NOT NULL,
+  status       INTEGER,
+  content_type TEXT,
+  body         BYTEA,
+  created      TIMESTAMP WITH TIME ZONE DEFAULT now()
+);
+
 CREATE UNIQUE INDEX IF NOT EXISTS user_nickname_uindex
   ON users (nickname);
 
@@ -105,3 +140,17 @@ CREATE INDEX IF NOT EXISTS index_post_for_parent_tree_with_sin
 CREATE INDEX IF NOT EXISTS index_post_for_parent_tree_without_sin
   ON post (thread, parent, id);
 
+CREATE INDEX IF NOT EXISTS index_post_forum_author
+  ON post (forum, author);
+
+
+CREATE TABLE IF NOT EXISTS schema_version
+(
+  version INTEGER NOT NULL
+);
+
+-- Keep in sync with database.SchemaVersion.
+BEGIN;
+DELETE FROM schema_version;
+INSERT INTO schema_version (version) VALUES (2);
+COMMIT;